@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// whether new receipts are currently being turned away, reads are served either way
+var maintenance atomic.Bool
+
+// request and response of /admin/maintenance endpoint, whether maintenance mode is on
+type Maintenance struct {
+	Maintenance *bool `json:"maintenance" binding:"required"`
+}
+
+/*
+Only lets through requests bearing the ADMIN_TOKEN as an Authorization: Bearer header
+aborts with 403 error if no token is configured, and with 401 error if the token is missing or wrong
+*/
+func adminOnly(context *gin.Context) {
+	if config.AdminToken == "" {
+		abortWithError(context, http.StatusForbidden, ADMIN_DISABLED_PROBLEM, "Admin endpoints are disabled")
+		return
+	}
+
+	token := strings.TrimPrefix(context.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+		abortWithError(context, http.StatusUnauthorized, UNAUTHORIZED_PROBLEM, "A valid admin token is required")
+		return
+	}
+	context.Next()
+}
+
+// aborts requests that would write receipts with 503 error while maintenance mode is on
+func rejectDuringMaintenance(context *gin.Context) {
+	if maintenance.Load() {
+		abortWithError(context, http.StatusServiceUnavailable, MAINTENANCE_PROBLEM, "New receipts are not being accepted during maintenance, please try again later")
+		return
+	}
+	context.Next()
+}
+
+/*
+Reports whether maintenance mode is on
+responds with the current maintenance mode
+*/
+func getMaintenance(context *gin.Context) {
+	// return the mode as a json object with a 200 status
+	on := maintenance.Load()
+	context.JSON(http.StatusOK, Maintenance{Maintenance: &on})
+}
+
+/*
+Turns maintenance mode on or off
+responds with the new maintenance mode
+*/
+func setMaintenance(context *gin.Context) {
+	var request Maintenance
+
+	// attempt to read the mode from the given JSON object, abort on failure with 400 error
+	err := context.ShouldBindJSON(&request)
+	if err != nil {
+		abortWithError(context, http.StatusBadRequest, INVALID_REQUEST_PROBLEM, "The request must give maintenance as true or false")
+		return
+	}
+	maintenance.Store(*request.Maintenance)
+
+	// return the mode as a json object with a 200 status
+	context.JSON(http.StatusOK, request)
+}
+
+// response of DELETE /receipts endpoint, the number of receipts deleted
+type Deleted struct {
+	Deleted int `json:"deleted"`
+}
+
+/*
+Deletes every stored receipt matching the given filters, forgetting their scores
+and those of any receipt that becomes the first purchase of its day in their place
+takes the retailer the receipts are from, matched ignoring case and punctuation, via the retailer query param,
+and the first and last purchase dates, as YYYY-MM-DD, via the from and to query params
+at least one filter must be given, so every receipt is never deleted by accident, abort otherwise with 400 error
+responds with the number of receipts deleted
+*/
+func deleteReceipts(context *gin.Context) {
+	retailer, byRetailer := context.GetQuery("retailer")
+	from, byFrom := context.GetQuery("from")
+	to, byTo := context.GetQuery("to")
+	if !byRetailer && !byFrom && !byTo {
+		abortWithError(context, http.StatusBadRequest, MISSING_QUERY_PROBLEM, "At least one of the retailer, from, and to query params is required")
+		return
+	}
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse(PURCHASE_DATE_FORMAT, date); date != "" && err != nil {
+			abortWithError(context, http.StatusBadRequest, INVALID_REQUEST_PROBLEM, "The from and to query params must be dates such as 2022-01-01")
+			return
+		}
+	}
+
+	stored, err := receipts.List(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	// dates in YYYY-MM-DD order the same as strings
+	deleted := 0
+	for _, receipt := range stored {
+		if byRetailer && normalizeRetailer(receipt.Retailer) != normalizeRetailer(retailer) ||
+			from != "" && receipt.PurchaseDate < from ||
+			to != "" && receipt.PurchaseDate > to {
+			continue
+		}
+		// the receipt left first on the date, if the deleted one was, now earns the first purchase of the day bonus
+		found := false
+		err := rewritingFirstsOnDates(context.Request.Context(), []string{receipt.PurchaseDate}, func() (err error) {
+			found, err = receipts.Delete(context.Request.Context(), receipt.Id)
+			return err
+		})
+		if err != nil {
+			abortWithStoreError(context, err)
+			return
+		}
+		if found {
+			scores.drop(receipt.Id)
+			awardedByRule.remove(receipt.Id)
+			processedHashes.forgetId(receipt.Id)
+			deleted++
+		}
+	}
+	logInfo("receipts deleted", "retailer", retailer, "from", from, "to", to, "deleted", deleted)
+
+	// return the number of receipts deleted as a json object with a 200 status
+	context.JSON(http.StatusOK, Deleted{Deleted: deleted})
+}
+
+/*
+Reloads the current rules from the RULES_FILE, so they can be changed without a restart
+every cached score is forgotten, so receipts are scored afresh under the reloaded rules
+responds with the reloaded rules, or aborts with 422 error leaving the current rules in place if the file cannot be loaded
+*/
+func reloadRules(context *gin.Context) {
+	// attempt to load the rules, abort on failure with 422 error
+	reloaded, err := loadRules(config.RulesFile)
+	if err == nil {
+		err = replaceRules(reloaded)
+	}
+	if err != nil {
+		abortWithError(context, http.StatusUnprocessableEntity, INVALID_RULES_PROBLEM, "The rules could not be reloaded: "+err.Error())
+		return
+	}
+	scores.forgetAll()
+	logInfo("rules reloaded", "file", config.RulesFile, "version", reloaded.Version)
+
+	// return the reloaded rules as a json object with a 200 status
+	context.JSON(http.StatusOK, reloaded)
+}
+
+/*
+Reports the settings the app is running with, as resolved from the environment and defaults
+responds with every setting keyed by its environment variable, with secrets redacted
+*/
+func getConfig(context *gin.Context) {
+	// return the settings as a json object with a 200 status
+	context.JSON(http.StatusOK, config.report())
+}
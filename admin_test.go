@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// the admin token the admin tests are run with
+const TEST_ADMIN_TOKEN = "secret"
+
+// serves the request through the router as the admin
+func serveAdminRequest(router http.Handler, method string, path string, body string, headers ...string) *httptest.ResponseRecorder {
+	return serveRequest(router, method, path, body, append([]string{"Authorization", "Bearer " + TEST_ADMIN_TOKEN}, headers...)...)
+}
+
+// turns maintenance mode on or off through the admin endpoint, failing the test unless it is
+func setTestMaintenance(t *testing.T, router http.Handler, on bool) {
+	t.Helper()
+	body := `{"maintenance": false}`
+	if on {
+		body = `{"maintenance": true}`
+	}
+	recorder := serveAdminRequest(router, http.MethodPut, "/admin/maintenance", body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("setting maintenance to %t responded %d: %s", on, recorder.Code, recorder.Body)
+	}
+	if got := decodeTestJSON[Maintenance](t, recorder); got.Maintenance == nil || *got.Maintenance != on {
+		t.Fatalf("setting maintenance to %t reported %s", on, recorder.Body)
+	}
+}
+
+func TestMaintenanceRejectsWritesButServesReads(t *testing.T) {
+	resetState(t)
+	config.AdminToken = TEST_ADMIN_TOKEN
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	setTestMaintenance(t, router, true)
+	for _, path := range []string{"/receipts/process", "/receipts/batch"} {
+		body := TARGET_RECEIPT
+		if path == "/receipts/batch" {
+			body = "[" + TARGET_RECEIPT + "]"
+		}
+		recorder := serveRequest(router, http.MethodPost, path, body, "Accept", "application/problem+json")
+		if recorder.Code != http.StatusServiceUnavailable {
+			t.Errorf("posting to %s during maintenance responded %d, expected %d", path, recorder.Code, http.StatusServiceUnavailable)
+			continue
+		}
+		if problem := decodeTestJSON[Problem](t, recorder); problem.Type != MAINTENANCE_PROBLEM {
+			t.Errorf("posting to %s during maintenance was a %s problem, expected %s", path, problem.Type, MAINTENANCE_PROBLEM)
+		}
+	}
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/"+id, ""); recorder.Code != http.StatusOK {
+		t.Errorf("reading the receipt during maintenance responded %d", recorder.Code)
+	}
+	if points := testPoints(t, router, id); points != TARGET_POINTS {
+		t.Errorf("the receipt scored %d during maintenance, expected %d", points, TARGET_POINTS)
+	}
+	recorder := serveAdminRequest(router, http.MethodGet, "/admin/maintenance", "")
+	if reported := decodeTestJSON[Maintenance](t, recorder); reported.Maintenance == nil || !*reported.Maintenance {
+		t.Errorf("maintenance was reported as %s while on", recorder.Body)
+	}
+
+	setTestMaintenance(t, router, false)
+	processTestReceipt(t, router, MM_RECEIPT)
+}
+
+func TestMaintenanceToggleIsAdminOnly(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	// without an admin token configured the admin endpoints are disabled
+	if recorder := serveAdminRequest(router, http.MethodPut, "/admin/maintenance", `{"maintenance": true}`); recorder.Code != http.StatusForbidden {
+		t.Errorf("toggling maintenance with admin disabled responded %d, expected %d", recorder.Code, http.StatusForbidden)
+	}
+
+	config.AdminToken = TEST_ADMIN_TOKEN
+	router = newTestRouter(t)
+	for _, headers := range [][]string{{}, {"Authorization", "Bearer wrong"}} {
+		if recorder := serveRequest(router, http.MethodPut, "/admin/maintenance", `{"maintenance": true}`, headers...); recorder.Code != http.StatusUnauthorized {
+			t.Errorf("toggling maintenance with %v responded %d, expected %d", headers, recorder.Code, http.StatusUnauthorized)
+		}
+	}
+	if maintenance.Load() {
+		t.Errorf("maintenance was turned on without the admin token")
+	}
+	if recorder := serveAdminRequest(router, http.MethodPut, "/admin/maintenance", `{}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("toggling maintenance without saying which way responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestReloadRulesScoresUnderTheNewRules(t *testing.T) {
+	resetState(t)
+	config.AdminToken = TEST_ADMIN_TOKEN
+	config.RulesFile = writeTestRules(t, "rules.json", `{}`)
+	router := newTestRouter(t)
+	target := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	if err := os.WriteFile(config.RulesFile, []byte(`{"version": "v2", "longReceiptThreshold": 3}`), 0o644); err != nil {
+		t.Fatalf("could not rewrite the rules: %v", err)
+	}
+	recorder := serveAdminRequest(router, http.MethodPost, "/admin/rules/reload", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("reloading the rules responded %d: %s", recorder.Code, recorder.Body)
+	}
+	if reloaded := decodeTestJSON[Rules](t, recorder); reloaded.Version != "v2" || reloaded.LongReceiptThreshold != 3 {
+		t.Errorf("the reloaded rules were %+v, expected those in the rewritten file", reloaded)
+	}
+
+	// receipts stored before the reload are scored afresh, as are new ones
+	if points := testPoints(t, router, target); points != TARGET_POINTS+LONG_RECEIPT_BONUS {
+		t.Errorf("the receipt stored before the reload has %d points, expected %d", points, TARGET_POINTS+LONG_RECEIPT_BONUS)
+	}
+	if points := testPoints(t, router, processTestReceipt(t, router, MM_RECEIPT)); points != MM_POINTS+LONG_RECEIPT_BONUS {
+		t.Errorf("a receipt stored after the reload has %d points, expected %d", points, MM_POINTS+LONG_RECEIPT_BONUS)
+	}
+
+	// rules that cannot be loaded leave the current ones in place
+	if err := os.WriteFile(config.RulesFile, []byte(`{"maxPoints": -1}`), 0o644); err != nil {
+		t.Fatalf("could not rewrite the rules: %v", err)
+	}
+	if recorder := serveAdminRequest(router, http.MethodPost, "/admin/rules/reload", ""); recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reloading invalid rules responded %d, expected %d", recorder.Code, http.StatusUnprocessableEntity)
+	}
+	if version := currentRules().Version; version != "v2" {
+		t.Errorf("the rules are version %s after a failed reload, expected v2", version)
+	}
+	if recorder := serveRequest(router, http.MethodPost, "/admin/rules/reload", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("reloading without the admin token responded %d, expected %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestDeleteReceiptsByDateRange(t *testing.T) {
+	resetState(t)
+	config.AdminToken = TEST_ADMIN_TOKEN
+	router := newTestRouter(t)
+	target := processTestReceipt(t, router, TARGET_RECEIPT)
+	second := processTestReceipt(t, router, targetVariant(t, "Target", "2022-01-02", "35.35"))
+	third := processTestReceipt(t, router, targetVariant(t, "Target", "2022-01-31", "35.35"))
+	mm := processTestReceipt(t, router, MM_RECEIPT)
+
+	recorder := serveAdminRequest(router, http.MethodDelete, "/receipts?from=2022-01-02&to=2022-01-31", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("deleting by date range responded %d: %s", recorder.Code, recorder.Body)
+	}
+	if deleted := decodeTestJSON[Deleted](t, recorder).Deleted; deleted != 2 {
+		t.Errorf("deleting by date range deleted %d receipts, expected 2", deleted)
+	}
+	for id, status := range map[string]int{target: http.StatusOK, second: http.StatusNotFound, third: http.StatusNotFound, mm: http.StatusOK} {
+		if recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points", ""); recorder.Code != status {
+			t.Errorf("%s responded %d after deleting by date range, expected %d", id, recorder.Code, status)
+		}
+	}
+
+	for _, test := range []struct {
+		query  string
+		status int
+	}{
+		{"", http.StatusBadRequest},
+		{"?from=yesterday", http.StatusBadRequest},
+		{"?retailer=Walgreens", http.StatusOK},
+	} {
+		if recorder := serveAdminRequest(router, http.MethodDelete, "/receipts"+test.query, ""); recorder.Code != test.status {
+			t.Errorf("deleting with %q responded %d, expected %d", test.query, recorder.Code, test.status)
+		}
+	}
+	if recorder := serveRequest(router, http.MethodDelete, "/receipts?retailer=Target", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("deleting without the admin token responded %d, expected %d", recorder.Code, http.StatusUnauthorized)
+	}
+	if count := testGauge(t, router, "receipts_stored"); count != "2" {
+		t.Errorf("%s receipts were stored after the deletes, expected 2", count)
+	}
+}
+
+func TestDeletePromotesTheNextFirstPurchaseOfTheDay(t *testing.T) {
+	resetState(t)
+	config.AdminToken = TEST_ADMIN_TOKEN
+	replaceTestRules(testRules(t, `{"firstPurchaseOfDay": true}`))
+	router := newTestRouter(t)
+	processTestReceipt(t, router, TARGET_RECEIPT)
+	later := processTestReceipt(t, router, targetVariant(t, "Target Store", "2022-01-01", "35.35"))
+	before := testPoints(t, router, later)
+
+	if recorder := serveAdminRequest(router, http.MethodDelete, "/receipts?retailer=Target", ""); recorder.Code != http.StatusOK {
+		t.Fatalf("deleting by retailer responded %d: %s", recorder.Code, recorder.Body)
+	}
+	if after := testPoints(t, router, later); after != before+FIRST_PURCHASE_OF_DAY_BONUS {
+		t.Errorf("the receipt left first on its day has %d points, expected %d with the bonus", after, before+FIRST_PURCHASE_OF_DAY_BONUS)
+	}
+}
+
+func TestDeleteDropsScoresAndTotals(t *testing.T) {
+	resetState(t)
+	config.AdminToken = TEST_ADMIN_TOKEN
+	router := newTestRouter(t)
+	target := processTestReceipt(t, router, TARGET_RECEIPT)
+	processTestReceipt(t, router, MM_RECEIPT)
+	testPoints(t, router, target)
+
+	if recorder := serveAdminRequest(router, http.MethodDelete, "/receipts?retailer=Target", ""); recorder.Code != http.StatusOK {
+		t.Fatalf("deleting by retailer responded %d: %s", recorder.Code, recorder.Body)
+	}
+	if _, found := scores.get(target, currentRules().Version); found {
+		t.Errorf("the deleted receipt's score is still cached")
+	}
+	if history := scores.historyOf(target); len(history) != 0 {
+		t.Errorf("the deleted receipt's history is still kept: %+v", history)
+	}
+
+	// only the m&m corner market receipt is left counted in the totals
+	expected := map[string]int{
+		RETAILER_NAME_RULE:          14,
+		EVERY_TWO_ITEMS_RULE:        10,
+		ROUND_DOLLAR_TOTAL_RULE:     ROUND_DOLLAR_AMOUNT_BONUS,
+		QUARTER_MULTIPLE_TOTAL_RULE: MULTIPLE_OF_0_POINT_25_BONUS,
+		AFTERNOON_PURCHASE_RULE:     BETWEEN_2PM_AND_4PM_BONUS,
+	}
+	if totals := testRuleStats(t, router); !reflect.DeepEqual(totals, expected) {
+		t.Errorf("the totals after deleting the target receipt were %v, expected %v", totals, expected)
+	}
+}
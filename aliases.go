@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// whether the given name is a field of a receipt, or of its items, as named in json
+func isReceiptField(name string) bool {
+	_, found := receiptSchema.Properties[name]
+	_, foundOnItem := receiptSchema.Properties["items"].Items.Properties[name]
+	return found || foundOnItem
+}
+
+/*
+Renames every field of the given json receipt, and of its items, known by one of the FIELD_ALIASES to the field it aliases
+returns the document unchanged if there are no aliases or it is not an object, leaving binding to reject it,
+and an InvalidReceiptError if a field is given both by name and by alias
+*/
+func remapFields(document []byte) ([]byte, error) {
+	if len(config.FieldAliases) == 0 {
+		return document, nil
+	}
+
+	var receipt map[string]json.RawMessage
+	if err := json.Unmarshal(document, &receipt); err != nil {
+		return document, nil
+	}
+	invalid := InvalidReceiptError{}
+	remapKeys(receipt, "", &invalid)
+
+	// items are remapped the same way, wherever the list of them came from
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(receipt["items"], &items); err == nil {
+		for i, item := range items {
+			remapKeys(item, fmt.Sprintf("items[%d].", i), &invalid)
+		}
+		receipt["items"], _ = json.Marshal(items)
+	}
+
+	if len(invalid.Problems) > 0 {
+		return document, invalid
+	}
+	return json.Marshal(receipt)
+}
+
+// renames the aliased keys of the given object in place, adding a problem for every field given twice
+func remapKeys(object map[string]json.RawMessage, prefix string, invalid *InvalidReceiptError) {
+	// aliases are renamed in order, so the problems are listed the same way every time
+	aliases := make([]string, 0, len(config.FieldAliases))
+	for alias := range config.FieldAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		field := config.FieldAliases[alias]
+		value, found := object[alias]
+		if !found {
+			continue
+		}
+		if _, duplicate := object[field]; duplicate {
+			invalid.Problems = append(invalid.Problems, fmt.Sprintf("%s%s is given both as %s and by its alias %s", prefix, field, field, alias))
+			continue
+		}
+		delete(object, alias)
+		object[field] = value
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// the target receipt with its purchase date and time, and the description of every item, under snake_case keys
+func snakeCaseTargetReceipt() string {
+	return strings.NewReplacer(`"purchaseDate"`, `"purchase_date"`, `"purchaseTime"`, `"purchase_time"`, `"shortDescription"`, `"short_description"`).Replace(TARGET_RECEIPT)
+}
+
+func TestAliasedFieldsAreRemapped(t *testing.T) {
+	t.Setenv("FIELD_ALIASES", "purchase_date=purchaseDate, purchase_time=purchaseTime, short_description=shortDescription")
+	resetState(t)
+	config = loadTestConfig(t)
+	router := newTestRouter(t)
+
+	id := processTestReceipt(t, router, snakeCaseTargetReceipt())
+	if points := testPoints(t, router, id); points != TARGET_POINTS {
+		t.Errorf("the aliased receipt was awarded %d points, expected %d", points, TARGET_POINTS)
+	}
+
+	// a field given both by name and by alias is ambiguous
+	both := strings.Replace(TARGET_RECEIPT, `"purchaseDate"`, `"purchase_date": "2022-01-03", "purchaseDate"`, 1)
+	recorder := serveRequest(router, http.MethodPost, "/receipts/process", both)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "by its alias purchase_date") {
+		t.Errorf("a field given twice responded %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// without the aliases the snake_case fields are unknown
+	config.FieldAliases = nil
+	if recorder := serveRequest(router, http.MethodPost, "/receipts/process", snakeCaseTargetReceipt()); recorder.Code != http.StatusBadRequest {
+		t.Errorf("the aliased receipt without FIELD_ALIASES responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestInvalidFieldAliasesAreRejected(t *testing.T) {
+	for _, aliases := range []string{"purchase_date", "purchase_date=purchased", "retailer=purchaseDate", "=retailer"} {
+		t.Setenv("FIELD_ALIASES", aliases)
+		if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "FIELD_ALIASES") {
+			t.Errorf("FIELD_ALIASES=%s gave error %v, expected one naming it", aliases, err)
+		}
+	}
+}
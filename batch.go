@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/xid"
+)
+
+// default number of receipts in a batch validated or scored at once
+const BATCH_WORKERS = 4
+
+// most receipts a single batch may hold
+const MAX_BATCH_SIZE = 1000
+
+// response of /receipts/batch endpoint, the outcome of one receipt in the batch, in the order they were given
+type BatchResult struct {
+	// the id the receipt was stored under and the points it is worth, when it was valid
+	Id     string `json:"id,omitempty"`
+	Points *int   `json:"points,omitempty"`
+	// every problem found with the receipt when it was not valid, or why it could not be stored or scored
+	Errors []string `json:"errors,omitempty"`
+}
+
+/*
+Processes a batch of receipts, storing each valid one and skipping the rest
+takes the receipts as a json array
+responds with the id and points of each valid receipt, or the problems with each invalid one, in the order they were given
+a receipt that could not be stored or scored is reported with why, the rest of the batch carries on without it
+*/
+func processBatch(context *gin.Context) {
+	var batch []json.RawMessage
+
+	// attempt to read the batch as a JSON array, abort on failure with 400 error
+	err := json.NewDecoder(context.Request.Body).Decode(&batch)
+	if err != nil {
+		abortWithError(context, http.StatusBadRequest, INVALID_REQUEST_PROBLEM, "The batch must be a json array of receipts")
+		return
+	}
+	if len(batch) > MAX_BATCH_SIZE {
+		abortWithError(context, http.StatusBadRequest, INVALID_REQUEST_PROBLEM, fmt.Sprintf("A batch may hold at most %d receipts", MAX_BATCH_SIZE))
+		return
+	}
+
+	// validate every receipt across the workers
+	results := make([]BatchResult, len(batch))
+	valid := make([]Receipt, len(batch))
+	inParallel(len(batch), config.BatchWorkers, func(i int) {
+		receipt, err := decodeReceipt(batch[i])
+		if err != nil {
+			results[i].Errors = problemsOf(err)
+			return
+		}
+		valid[i] = receipt
+	})
+
+	// store the valid receipts one at a time, in the order given, so ids and first purchases of the day follow it too
+	// those already stored are kept when a later one fails, so their ids are still returned
+	stored := make([]StoredReceipt, len(batch))
+	for i := range batch {
+		if results[i].Errors != nil {
+			continue
+		}
+		id := xid.New().String()
+		err = receipts.Save(context.Request.Context(), id, valid[i])
+		if err != nil {
+			results[i].Errors = []string{"the receipt could not be stored: " + err.Error()}
+			continue
+		}
+		stored[i] = StoredReceipt{Id: id, Receipt: valid[i]}
+		results[i].Id = id
+	}
+
+	// score every stored receipt across the workers, caching the scores and counting them towards the per-rule totals
+	inParallel(len(batch), config.BatchWorkers, func(i int) {
+		if results[i].Id == "" {
+			return
+		}
+		score, err := scoreReceipt(context.Request.Context(), stored[i], currentRules())
+		if err != nil {
+			results[i].Errors = []string{"the receipt was stored but could not be scored: " + err.Error()}
+			return
+		}
+		awardedByRule.add(results[i].Id, score.Breakdown)
+		results[i].Points = &score.Breakdown.Points
+	})
+
+	// return the results as a json array with a 200 status, whether or not every receipt was valid
+	context.JSON(http.StatusOK, results)
+}
+
+/*
+Decodes a single receipt from the given json, tidies it up, and validates it
+the same checks are made as bindReceipt makes of a request body, failures are an InvalidReceiptError
+*/
+func decodeReceipt(document []byte) (Receipt, error) {
+	var receipt Receipt
+
+	document, err := remapFields(document)
+	if err != nil {
+		return receipt, err
+	}
+	if config.SchemaValidation {
+		if err := receiptSchema.validateDocument(document); err != nil {
+			return receipt, err
+		}
+	}
+
+	err = json.Unmarshal(document, &receipt)
+	if err != nil {
+		return receipt, InvalidReceiptError{Problems: []string{err.Error()}}
+	}
+	err = binding.Validator.ValidateStruct(receipt)
+	if err != nil {
+		return receipt, bindingError(err)
+	}
+
+	receipt = normalizeReceipt(receipt)
+	return receipt, validateReceipt(receipt)
+}
+
+// the problems listed by an InvalidReceiptError, or the error itself if it is any other
+func problemsOf(err error) []string {
+	var invalid InvalidReceiptError
+	if errors.As(err, &invalid) {
+		return invalid.Problems
+	}
+	return []string{err.Error()}
+}
+
+// calls work with every index below count, running at most workers calls at once, and returns when all are done
+func inParallel(count int, workers int, work func(i int)) {
+	indexes := make(chan int)
+	var wait sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := range indexes {
+				work(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wait.Wait()
+}
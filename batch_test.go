@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// a store that fails to save receipts from one retailer, and to find the first receipt on one date
+type failingStore struct {
+	*MemoryStore
+	retailer     string
+	purchaseDate string
+}
+
+func (store failingStore) Save(ctx context.Context, id string, receipt Receipt) error {
+	if receipt.Retailer == store.retailer {
+		return errors.New("the disk is full")
+	}
+	return store.MemoryStore.Save(ctx, id, receipt)
+}
+
+func (store failingStore) FirstOnDate(ctx context.Context, purchaseDate string) (string, bool, error) {
+	if purchaseDate == store.purchaseDate {
+		return "", false, errors.New("the index is corrupt")
+	}
+	return store.MemoryStore.FirstOnDate(ctx, purchaseDate)
+}
+
+// a batch of the given number of receipts, each worth a different number of points so their order shows
+func testBatch(count int) (batch string, points []int) {
+	var receipts []string
+	for i := 0; i < count; i++ {
+		retailer := strings.Repeat("a", i%40+1)
+		receipts = append(receipts, strings.Replace(TARGET_RECEIPT, `"Target"`, `"`+retailer+`"`, 1))
+		points = append(points, TARGET_POINTS-len("Target")+len(retailer))
+	}
+	return "[" + strings.Join(receipts, ",") + "]", points
+}
+
+// processes the batch through the router, failing the test unless it responds with a result for every receipt
+func processTestBatch(t testing.TB, router http.Handler, batch string) []BatchResult {
+	t.Helper()
+	recorder := serveRequest(router, http.MethodPost, "/receipts/batch", batch)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("the batch responded %d: %s", recorder.Code, recorder.Body)
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil {
+		t.Fatalf("could not decode %q: %v", recorder.Body, err)
+	}
+	return results
+}
+
+func TestLargeBatchKeepsItsOrder(t *testing.T) {
+	resetState(t)
+	config.BatchWorkers = 8
+	router := newTestRouter(t)
+
+	batch, points := testBatch(500)
+	results := processTestBatch(t, router, batch)
+	if len(results) != len(points) {
+		t.Fatalf("the batch of %d gave %d results", len(points), len(results))
+	}
+	ids := make(map[string]bool)
+	for i, result := range results {
+		if result.Points == nil || *result.Points != points[i] || len(result.Errors) != 0 {
+			t.Fatalf("receipt %d of the batch gave %+v, expected %d points", i, result, points[i])
+		}
+		ids[result.Id] = true
+	}
+	if len(ids) != len(points) {
+		t.Errorf("the batch of %d stored %d distinct ids", len(points), len(ids))
+	}
+
+	// the receipts were stored in the order given, so the stored order matches the results
+	stored := decodeTestJSON[ReceiptPage[StoredReceipt]](t, serveRequest(router, http.MethodGet, "/receipts?limit=500", ""))
+	for i, receipt := range stored.Receipts {
+		if receipt.Id != results[i].Id {
+			t.Fatalf("receipt %d was stored as %s, expected %s", i, receipt.Id, results[i].Id)
+		}
+	}
+}
+
+func TestBatchReportsInvalidReceipts(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	results := processTestBatch(t, router, "["+TARGET_RECEIPT+`,{"retailer": "Target"},`+MM_RECEIPT+"]")
+	if len(results) != 3 || results[0].Points == nil || *results[0].Points != TARGET_POINTS || results[2].Points == nil || *results[2].Points != MM_POINTS {
+		t.Fatalf("the batch gave %+v, expected the valid receipts to be scored", results)
+	}
+	if results[1].Id != "" || results[1].Points != nil || len(results[1].Errors) == 0 {
+		t.Errorf("the invalid receipt gave %+v, expected only its problems", results[1])
+	}
+}
+
+func TestBatchReportsStoreAndScoringErrorsPerReceipt(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	receipts = failingStore{MemoryStore: NewMemoryStore(), retailer: "Broken", purchaseDate: "2022-03-20"}
+
+	broken := strings.Replace(TARGET_RECEIPT, `"Target"`, `"Broken"`, 1)
+	results := processTestBatch(t, router, "["+broken+","+MM_RECEIPT+","+TARGET_RECEIPT+"]")
+	if len(results) != 3 {
+		t.Fatalf("the batch of 3 gave %d results", len(results))
+	}
+
+	if results[0].Id != "" || len(results[0].Errors) != 1 || !strings.Contains(results[0].Errors[0], "could not be stored: the disk is full") {
+		t.Errorf("the receipt that could not be stored gave %+v", results[0])
+	}
+	if results[1].Id == "" || results[1].Points != nil || len(results[1].Errors) != 1 || !strings.Contains(results[1].Errors[0], "stored but could not be scored: the index is corrupt") {
+		t.Errorf("the receipt that could not be scored gave %+v", results[1])
+	}
+	// the rest of the batch carries on without them
+	if results[2].Id == "" || results[2].Points == nil || *results[2].Points != TARGET_POINTS || len(results[2].Errors) != 0 {
+		t.Errorf("the receipt after them gave %+v, expected %d points", results[2], TARGET_POINTS)
+	}
+}
+
+func TestBatchSizeIsBounded(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	batch, _ := testBatch(MAX_BATCH_SIZE + 1)
+	if recorder := serveRequest(router, http.MethodPost, "/receipts/batch", batch); recorder.Code != http.StatusBadRequest {
+		t.Errorf("a batch of %d responded %d, expected %d", MAX_BATCH_SIZE+1, recorder.Code, http.StatusBadRequest)
+	}
+	if recorder := serveRequest(router, http.MethodPost, "/receipts/batch", TARGET_RECEIPT); recorder.Code != http.StatusBadRequest {
+		t.Errorf("a batch that is not an array responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+// processes batches with one worker and with several, so the speedup of the pool shows
+func BenchmarkBatch(b *testing.B) {
+	batch, _ := testBatch(MAX_BATCH_SIZE)
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			config = defaultConfig
+			config.BatchWorkers = workers
+			router, err := newRouter()
+			if err != nil {
+				b.Fatalf("could not build router: %v", err)
+			}
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				receipts = NewMemoryStore()
+				scores = &scoreCache{scores: make(map[string]map[string]cachedScore), history: make(map[string][]HistoricalScore)}
+				b.StartTimer()
+				processTestBatch(b, router, batch)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// header clients identify themselves by, so each can be limited in how many of its requests are served at once
+const API_KEY_HEADER = "X-API-Key"
+
+// a counting semaphore for every api key with requests in flight, keys are dropped once they have none so the map stays small
+type keySemaphores struct {
+	lock     sync.Mutex
+	inFlight map[string]int64
+}
+
+// the requests currently being served for each api key
+var keyInFlight = &keySemaphores{inFlight: make(map[string]int64)}
+
+// takes a slot for the api key unless it already has limit requests in flight, in which case ok is false
+func (semaphores *keySemaphores) acquire(key string, limit int64) (ok bool) {
+	semaphores.lock.Lock()
+	defer semaphores.lock.Unlock()
+
+	if semaphores.inFlight[key] >= limit {
+		return false
+	}
+	semaphores.inFlight[key]++
+	return true
+}
+
+// frees a slot taken for the api key
+func (semaphores *keySemaphores) release(key string) {
+	semaphores.lock.Lock()
+	defer semaphores.lock.Unlock()
+
+	semaphores.inFlight[key]--
+	if semaphores.inFlight[key] <= 0 {
+		delete(semaphores.inFlight, key)
+	}
+}
+
+// the most requests the api key may have in flight at once, its own limit from KEY_CONCURRENCY if it has one, 0 for no limit
+func concurrencyLimitOf(key string) int64 {
+	if limit, found := config.KeyConcurrency[key]; found {
+		return limit
+	}
+	return config.MaxConcurrentPerKey
+}
+
+/*
+Only lets through requests whose api key, given by the X-API-Key header, has fewer than its limit of requests in flight
+aborts with 429 error otherwise, requests without a key are not limited
+*/
+func limitPerKey(context *gin.Context) {
+	key := context.GetHeader(API_KEY_HEADER)
+	limit := concurrencyLimitOf(key)
+	if key == "" || limit == 0 {
+		context.Next()
+		return
+	}
+
+	if !keyInFlight.acquire(key, limit) {
+		abortWithError(context, http.StatusTooManyRequests, TOO_MANY_REQUESTS_PROBLEM, "Too many requests are in flight for this API key, please try again once they finish")
+		return
+	}
+	defer keyInFlight.release(key)
+	context.Next()
+}
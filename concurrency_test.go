@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// a store whose reads each report they have started, then wait until released
+type gatedStore struct {
+	*MemoryStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (store gatedStore) Get(ctx context.Context, id string) (StoredReceipt, bool, error) {
+	store.entered <- struct{}{}
+	select {
+	case <-store.release:
+		return store.MemoryStore.Get(ctx, id)
+	case <-ctx.Done():
+		return StoredReceipt{}, false, ctx.Err()
+	}
+}
+
+func TestConcurrentRequestsPerKeyOverTheLimitAreRejected(t *testing.T) {
+	resetState(t)
+	config.MaxConcurrentPerKey = 2
+	config.KeyConcurrency = map[string]int64{"partner": 3}
+	router := newTestRouter(t)
+	store := gatedStore{MemoryStore: NewMemoryStore(), entered: make(chan struct{}), release: make(chan struct{})}
+	receipts = store
+
+	for key, limit := range map[string]int{"basic": 2, "partner": 3} {
+		// fill the key's limit with requests held in the store
+		held := make(chan *httptest.ResponseRecorder, limit)
+		for i := 0; i < limit; i++ {
+			go func() {
+				held <- serveRequest(router, http.MethodGet, "/receipts/missing", "", API_KEY_HEADER, key)
+			}()
+			<-store.entered
+		}
+
+		recorder := serveRequest(router, http.MethodGet, "/receipts/missing", "", API_KEY_HEADER, key)
+		if recorder.Code != http.StatusTooManyRequests {
+			t.Errorf("a request over the %d in flight for %s responded %d, expected %d", limit, key, recorder.Code, http.StatusTooManyRequests)
+		}
+		// other keys, and requests without one, are limited apart from it
+		for _, other := range []string{"other", ""} {
+			if recorder := serveRequest(router, http.MethodGet, "/receipts/count", "", API_KEY_HEADER, other); recorder.Code != http.StatusOK {
+				t.Errorf("a request for %q while %s was at its limit responded %d", other, key, recorder.Code)
+			}
+		}
+
+		for i := 0; i < limit; i++ {
+			store.release <- struct{}{}
+			if recorder := <-held; recorder.Code != http.StatusNotFound {
+				t.Errorf("a request held for %s responded %d, expected it to finish with %d", key, recorder.Code, http.StatusNotFound)
+			}
+		}
+	}
+
+	// once the held requests finish, the key may send more
+	go func() { <-store.entered; store.release <- struct{}{} }()
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/missing", "", API_KEY_HEADER, "basic"); recorder.Code != http.StatusNotFound {
+		t.Errorf("a request once the others finished responded %d, expected %d", recorder.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the ids of the receipts processed so far, keyed by the SHA-256 hash of the body they were sent in
+type contentHashes struct {
+	lock sync.RWMutex
+	ids  map[string]string
+}
+
+// every receipt body processed since startup, so a client resending one can be given the receipt it already created
+var processedHashes = &contentHashes{ids: make(map[string]string)}
+
+// records that the body with the given hash created the receipt with the given id, keeping the first receipt it created
+func (hashes *contentHashes) put(hash string, id string) {
+	hashes.lock.Lock()
+	defer hashes.lock.Unlock()
+
+	if _, found := hashes.ids[hash]; !found {
+		hashes.ids[hash] = id
+	}
+}
+
+// forgets the body that created the receipt with the given id, for when it is deleted
+func (hashes *contentHashes) forgetId(id string) {
+	hashes.lock.Lock()
+	defer hashes.lock.Unlock()
+
+	for hash, created := range hashes.ids {
+		if created == id {
+			delete(hashes.ids, hash)
+		}
+	}
+}
+
+/*
+Finds the receipt created by a body with any of the hashes in the given If-None-Match header
+the hashes may be quoted, weak, or comma separated, found is false if none of them created a receipt
+*/
+func (hashes *contentHashes) match(header string) (id string, found bool) {
+	hashes.lock.RLock()
+	defer hashes.lock.RUnlock()
+
+	for _, hash := range entityTags(header) {
+		if id, found = hashes.ids[strings.ToLower(hash)]; found {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// the entity tags listed in an If-None-Match header, without their quotes or weak prefixes
+func entityTags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+/*
+The entity tag of a receipt's points under the given rules version
+it changes with the rules version as well as the points, so a change of rules busts any cached points
+*/
+func pointsETag(id string, version string, points int) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d", id, version, points)))
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// whether the If-None-Match header lists the given entity tag, or is the wildcard
+func noneMatch(header string, etag string) bool {
+	for _, tag := range entityTags(header) {
+		if tag == "*" || `"`+tag+`"` == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// the hex SHA-256 hash of the request body, which is left in place to be read again
+func hashBody(context *gin.Context) (string, error) {
+	body, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	context.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// the hash of the body, as a client would compute it to send as If-None-Match
+func testContentHash(body string) string {
+	hash := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestConditionalCreationWithContentHash(t *testing.T) {
+	resetState(t)
+	config.AdminToken = TEST_ADMIN_TOKEN
+	router := newTestRouter(t)
+	hash := testContentHash(TARGET_RECEIPT)
+
+	created := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT, "If-None-Match", `"`+hash+`"`)
+	if created.Code != http.StatusOK || created.Header().Get("ETag") != `"`+hash+`"` {
+		t.Fatalf("the first submission responded %d with ETag %q, expected the hash of its body", created.Code, created.Header().Get("ETag"))
+	}
+	first := decodeTestJSON[Id](t, created)
+	if first.Existing {
+		t.Errorf("the first submission was reported as existing")
+	}
+
+	// the same hash, however it is quoted, gets the same receipt back rather than a new one
+	for _, header := range []string{`"` + hash + `"`, `W/"` + hash + `"`, `"other", ` + hash} {
+		recorder := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT, "If-None-Match", header)
+		again := decodeTestJSON[Id](t, recorder)
+		if recorder.Code != http.StatusOK || again.Id != first.Id || !again.Existing {
+			t.Errorf("resubmitting with If-None-Match %s responded %d with %+v, expected the existing %s", header, recorder.Code, again, first.Id)
+		}
+	}
+	if count := testGauge(t, router, "receipts_stored"); count != "1" {
+		t.Errorf("%s receipts were stored after resubmitting with the hash, expected 1", count)
+	}
+
+	// without the header, or once the receipt is deleted, the body creates a new receipt
+	if second := processTestReceipt(t, router, TARGET_RECEIPT); second == first.Id {
+		t.Errorf("resubmitting without If-None-Match gave back the existing receipt")
+	}
+	serveAdminRequest(router, http.MethodDelete, "/receipts?retailer=Target", "")
+	recorder := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT, "If-None-Match", `"`+hash+`"`)
+	if again := decodeTestJSON[Id](t, recorder); again.Id == first.Id || again.Existing {
+		t.Errorf("resubmitting after the receipt was deleted gave %+v, expected a new receipt", again)
+	}
+}
+
+func TestPointsETagChangesWithTheRules(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	before := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points", "").Header().Get("ETag")
+	if before == "" {
+		t.Fatalf("the points were sent without an ETag")
+	}
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points", "", "If-None-Match", before); recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+		t.Errorf("revalidating the points responded %d with %q, expected an empty %d", recorder.Code, recorder.Body, http.StatusNotModified)
+	}
+
+	// new rules worth the same points still change the tag, so caches from before the change are busted
+	replaceTestRules(testRules(t, `{"version": "v2"}`))
+	recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points", "", "If-None-Match", before)
+	if recorder.Code != http.StatusOK || decodeTestJSON[Points](t, recorder).Points != TARGET_POINTS {
+		t.Fatalf("revalidating after the rules changed responded %d: %s", recorder.Code, recorder.Body)
+	}
+	if after := recorder.Header().Get("ETag"); after == before || after == "" {
+		t.Errorf("the ETag was %s before the rules changed and %s after", before, after)
+	}
+}
+
+func TestHeadMatchesGetWithoutABody(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+	// served over a real connection, as only the server leaves the body out of responses to HEAD
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	send := func(method string, path string) (*http.Response, string) {
+		request, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Fatalf("could not build %s %s: %v", method, path, err)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response, string(body)
+	}
+
+	for _, path := range []string{"/receipts/" + id, "/receipts/" + id + "/points", "/receipts/missing", "/receipts/missing/points"} {
+		got, _ := send(http.MethodGet, path)
+		head, body := send(http.MethodHead, path)
+		if head.StatusCode != got.StatusCode {
+			t.Errorf("HEAD %s responded %d, but GET responded %d", path, head.StatusCode, got.StatusCode)
+		}
+		if body != "" {
+			t.Errorf("HEAD %s responded with the body %q", path, body)
+		}
+		for _, header := range []string{"ETag", "Cache-Control", "Content-Type"} {
+			if head.Header.Get(header) != got.Header.Get(header) {
+				t.Errorf("HEAD %s responded with %s %q, but GET with %q", path, header, head.Header.Get(header), got.Header.Get(header))
+			}
+		}
+	}
+
+	if head, _ := send(http.MethodHead, "/receipts/"+id+"/points"); head.StatusCode != http.StatusOK || head.Header.Get("ETag") == "" {
+		t.Errorf("HEAD of the points of a stored receipt responded %d with ETag %q", head.StatusCode, head.Header.Get("ETag"))
+	}
+	if head, _ := send(http.MethodHead, "/receipts/missing"); head.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD of a missing receipt responded %d, expected %d", head.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// the timezone database is built in, so SERVER_TZ works on images without one
+	_ "time/tzdata"
+)
+
+// default settings, used when the matching environment variable is not set
+const REQUEST_TIMEOUT = 5 * time.Second
+const CACHE_MAX_AGE = time.Hour
+const MAX_DESCRIPTION_LENGTH = 500
+const MAX_DECOMPRESSED_SIZE = 10 << 20
+
+// what secrets are reported as in place of their values
+const REDACTED = "[redacted]"
+
+// settings read from the environment, and command-line flags, at startup
+type Config struct {
+	// the port the app listens on, PORT, or the -port flag
+	Port string
+	// path of the json file the scoring rules are loaded from, empty for the defaults, or the -rules flag
+	RulesFile string
+	// paths of json files holding additional rule-sets receipts can be scored against, RULESETS as a comma separated list
+	Rulesets []string
+	// how long a request may run before it is cancelled, REQUEST_TIMEOUT
+	RequestTimeout time.Duration
+	// the largest total in cents, or the minor unit of its currency, a receipt may have, MAX_TOTAL, 0 disables the check
+	MaxTotal int64
+	// token admin endpoints require as an Authorization: Bearer header, ADMIN_TOKEN, empty disables them
+	AdminToken string
+	// whether the app starts in maintenance mode, turning away new receipts, MAINTENANCE
+	Maintenance bool
+	// symbols money strings may be prefixed with, CURRENCY_SYMBOLS as a comma separated list
+	CurrencySymbols []string
+	// how money strings are written, MONEY_LOCALE, either US_MONEY_LOCALE or EU_MONEY_LOCALE
+	MoneyLocale string
+	// how much the app logs, LOG_LEVEL, either LOG_LEVEL_INFO or LOG_LEVEL_DEBUG, or the -log-level flag
+	LogLevel string
+	// how long clients and proxies may cache a stored receipt, CACHE_MAX_AGE
+	CacheMaxAge time.Duration
+	// the timezone purchase dates and times are read in, SERVER_TZ as an IANA name such as "America/Chicago"
+	ServerLocation *time.Location
+	// how the final points of every receipt are rounded, SCORE_ROUNDING, one of SCORE_ROUNDING_NONE, SCORE_ROUNDING_NEAREST_5, or SCORE_ROUNDING_NEAREST_10
+	ScoreRounding string
+	// whether receipts are checked against RECEIPT_SCHEMA before they are bound, SCHEMA_VALIDATION
+	SchemaValidation bool
+	// how many receipts in a batch are validated or scored at once, BATCH_WORKERS
+	BatchWorkers int
+	// whether every request served is logged, ACCESS_LOG
+	AccessLog bool
+	// whether panics while serving a request are recovered from with a 500 response, RECOVER_PANICS
+	RecoverPanics bool
+	// origins browsers may call the app from, CORS_ORIGINS as a comma separated list, ANY_ORIGIN for all, none disables CORS
+	CORSOrigins []string
+	// how long browsers may cache a preflight response, CORS_MAX_AGE
+	CORSMaxAge time.Duration
+	// whether browsers may send credentials to the app from the named CORS_ORIGINS, CORS_CREDENTIALS
+	CORSCredentials bool
+	// how many times a store write is attempted before giving up, STORE_ATTEMPTS
+	StoreAttempts int
+	// how long to wait before retrying a store write, doubling after each retry, STORE_RETRY_BACKOFF
+	StoreRetryBackoff time.Duration
+	// the longest, in characters, an item's short description may be, MAX_DESCRIPTION_LENGTH, 0 disables the check
+	MaxDescriptionLength int64
+	// how long in-flight requests are given to finish once the app is told to stop, SHUTDOWN_TIMEOUT
+	ShutdownTimeout time.Duration
+	// how strictly receipts are validated, VALIDATION_MODE, one of VALIDATION_LENIENT, VALIDATION_STANDARD, or VALIDATION_STRICT
+	ValidationMode string
+	// whether successful json responses are wrapped in an Envelope, ENVELOPE
+	Envelope bool
+	// how many receipts may be scored at once, MAX_CONCURRENT_SCORING, 0 leaves it unlimited
+	MaxConcurrentScoring int64
+	// how long a score waits for one of those slots before the request is turned away, SCORING_QUEUE_TIMEOUT
+	ScoringQueueTimeout time.Duration
+	// other names incoming json fields are known by, keyed by alias, FIELD_ALIASES as comma separated alias=field pairs
+	FieldAliases map[string]string
+	// the only retailers receipts are accepted from, RETAILER_ALLOWLIST, none accepts every retailer not blocked
+	RetailerAllowlist []string
+	// retailers receipts are rejected from, RETAILER_BLOCKLIST, entries in either list ending in * match by prefix
+	RetailerBlocklist []string
+	// how many requests each api key may have in flight at once, MAX_CONCURRENT_PER_KEY, 0 leaves them unlimited
+	MaxConcurrentPerKey int64
+	// limits for particular api keys in place of that, KEY_CONCURRENCY as comma separated key=limit pairs
+	KeyConcurrency map[string]int64
+	// how long the same receipt body from the same client ip is rejected as a resubmission, DUPLICATE_WINDOW, 0 disables it
+	DuplicateWindow time.Duration
+	// the earliest purchase date, as YYYY-MM-DD, a receipt may have, MIN_PURCHASE_DATE, empty disables the check
+	MinPurchaseDate string
+	// proxies whose X-Forwarded-For header is believed for the client ip, TRUSTED_PROXIES as a comma separated list of ips and CIDRs
+	// none trusts no proxy, so the client ip is always the address the request came from
+	TrustedProxies []string
+	// the most tags a receipt may carry, MAX_TAGS, and the longest a tag may be in characters, MAX_TAG_LENGTH
+	MaxTags      int
+	MaxTagLength int
+	// the most bytes a gzip or deflate request body may decompress to, MAX_DECOMPRESSED_SIZE, 0 disables the check
+	MaxDecompressedSize int64
+}
+
+// the settings the app is running with
+var config = Config{
+	Port:                 PORT,
+	RequestTimeout:       REQUEST_TIMEOUT,
+	CurrencySymbols:      []string{CURRENCY_SYMBOLS},
+	MoneyLocale:          US_MONEY_LOCALE,
+	LogLevel:             LOG_LEVEL_INFO,
+	CacheMaxAge:          CACHE_MAX_AGE,
+	ServerLocation:       time.UTC,
+	ScoreRounding:        SCORE_ROUNDING_NONE,
+	BatchWorkers:         BATCH_WORKERS,
+	MaxTags:              MAX_TAGS,
+	MaxTagLength:         MAX_TAG_LENGTH,
+	AccessLog:            true,
+	RecoverPanics:        true,
+	CORSMaxAge:           CORS_MAX_AGE,
+	StoreAttempts:        STORE_ATTEMPTS,
+	StoreRetryBackoff:    STORE_RETRY_BACKOFF,
+	MaxDescriptionLength: MAX_DESCRIPTION_LENGTH,
+	ShutdownTimeout:      SHUTDOWN_TIMEOUT,
+	ValidationMode:       VALIDATION_STANDARD,
+	ScoringQueueTimeout:  SCORING_QUEUE_TIMEOUT,
+	MaxDecompressedSize:  MAX_DECOMPRESSED_SIZE,
+}
+
+/*
+Reads the settings from the environment
+unset variables fall back to their defaults, malformed ones are an error
+*/
+func loadConfig() (Config, error) {
+	var loaded Config
+	var err error
+
+	loaded.Port = PORT
+	if port := strings.TrimSpace(os.Getenv("PORT")); port != "" {
+		loaded.Port, err = parsePort("PORT", port)
+		if err != nil {
+			return loaded, err
+		}
+	}
+	loaded.RulesFile = os.Getenv("RULES_FILE")
+	loaded.Rulesets = envList("RULESETS")
+	loaded.RequestTimeout, err = envDuration("REQUEST_TIMEOUT", REQUEST_TIMEOUT)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.MaxTotal, err = envInt("MAX_TOTAL", 0)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.AdminToken = os.Getenv("ADMIN_TOKEN")
+	loaded.Maintenance, err = envBool("MAINTENANCE", false)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.CurrencySymbols = envList("CURRENCY_SYMBOLS")
+	if len(loaded.CurrencySymbols) == 0 {
+		loaded.CurrencySymbols = []string{CURRENCY_SYMBOLS}
+	}
+	loaded.MoneyLocale, err = envChoice("MONEY_LOCALE", US_MONEY_LOCALE, EU_MONEY_LOCALE)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.LogLevel, err = envChoice("LOG_LEVEL", LOG_LEVEL_INFO, LOG_LEVEL_DEBUG)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.CacheMaxAge, err = envDuration("CACHE_MAX_AGE", CACHE_MAX_AGE)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.ServerLocation, err = envLocation("SERVER_TZ", time.UTC)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.ScoreRounding, err = envChoice("SCORE_ROUNDING", SCORE_ROUNDING_NONE, SCORE_ROUNDING_NEAREST_5, SCORE_ROUNDING_NEAREST_10)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.SchemaValidation, err = envBool("SCHEMA_VALIDATION", false)
+	if err != nil {
+		return loaded, err
+	}
+	batchWorkers, err := envInt("BATCH_WORKERS", BATCH_WORKERS)
+	if err != nil || batchWorkers < 1 {
+		return loaded, fmt.Errorf("BATCH_WORKERS must be a positive whole number, got %q", os.Getenv("BATCH_WORKERS"))
+	}
+	loaded.BatchWorkers = int(batchWorkers)
+	loaded.AccessLog, err = envBool("ACCESS_LOG", true)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.RecoverPanics, err = envBool("RECOVER_PANICS", true)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.CORSOrigins = envList("CORS_ORIGINS")
+	loaded.CORSMaxAge, err = envDuration("CORS_MAX_AGE", CORS_MAX_AGE)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.CORSCredentials, err = envBool("CORS_CREDENTIALS", false)
+	if err != nil {
+		return loaded, err
+	}
+	for _, origin := range loaded.CORSOrigins {
+		if loaded.CORSCredentials && origin == ANY_ORIGIN {
+			return loaded, fmt.Errorf("CORS_CREDENTIALS needs CORS_ORIGINS to name specific origins, not %q", ANY_ORIGIN)
+		}
+	}
+	storeAttempts, err := envInt("STORE_ATTEMPTS", STORE_ATTEMPTS)
+	if err != nil || storeAttempts < 1 {
+		return loaded, fmt.Errorf("STORE_ATTEMPTS must be a positive whole number, got %q", os.Getenv("STORE_ATTEMPTS"))
+	}
+	loaded.StoreAttempts = int(storeAttempts)
+	loaded.StoreRetryBackoff, err = envDuration("STORE_RETRY_BACKOFF", STORE_RETRY_BACKOFF)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.MaxDescriptionLength, err = envInt("MAX_DESCRIPTION_LENGTH", MAX_DESCRIPTION_LENGTH)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", SHUTDOWN_TIMEOUT)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.ValidationMode, err = envChoice("VALIDATION_MODE", VALIDATION_STANDARD, VALIDATION_LENIENT, VALIDATION_STRICT)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.Envelope, err = envBool("ENVELOPE", false)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.MaxConcurrentScoring, err = envInt("MAX_CONCURRENT_SCORING", 0)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.ScoringQueueTimeout, err = envDuration("SCORING_QUEUE_TIMEOUT", SCORING_QUEUE_TIMEOUT)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.FieldAliases, err = envAliases("FIELD_ALIASES")
+	if err != nil {
+		return loaded, err
+	}
+	loaded.RetailerAllowlist = envList("RETAILER_ALLOWLIST")
+	loaded.RetailerBlocklist = envList("RETAILER_BLOCKLIST")
+	loaded.MaxConcurrentPerKey, err = envInt("MAX_CONCURRENT_PER_KEY", 0)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.KeyConcurrency, err = envLimits("KEY_CONCURRENCY")
+	if err != nil {
+		return loaded, err
+	}
+	loaded.DuplicateWindow, err = envDuration("DUPLICATE_WINDOW", 0)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.MinPurchaseDate = strings.TrimSpace(os.Getenv("MIN_PURCHASE_DATE"))
+	if _, err := time.Parse(PURCHASE_DATE_FORMAT, loaded.MinPurchaseDate); loaded.MinPurchaseDate != "" && err != nil {
+		return loaded, fmt.Errorf("MIN_PURCHASE_DATE must be a date such as \"2020-01-01\", got %q", loaded.MinPurchaseDate)
+	}
+	// no tags at all may be allowed, but not fewer
+	maxTags, err := envInt("MAX_TAGS", MAX_TAGS)
+	if err != nil || maxTags < 0 {
+		return loaded, fmt.Errorf("MAX_TAGS must be a whole number of at least 0, got %q", os.Getenv("MAX_TAGS"))
+	}
+	loaded.MaxTags = int(maxTags)
+	maxTagLength, err := envInt("MAX_TAG_LENGTH", MAX_TAG_LENGTH)
+	if err != nil || maxTagLength < 1 {
+		return loaded, fmt.Errorf("MAX_TAG_LENGTH must be a positive whole number, got %q", os.Getenv("MAX_TAG_LENGTH"))
+	}
+	loaded.MaxTagLength = int(maxTagLength)
+	loaded.TrustedProxies = envList("TRUSTED_PROXIES")
+	for _, proxy := range loaded.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return loaded, fmt.Errorf("TRUSTED_PROXIES must be ips or CIDRs such as \"10.0.0.0/8\", got %q", proxy)
+		}
+	}
+	loaded.MaxDecompressedSize, err = envInt("MAX_DECOMPRESSED_SIZE", MAX_DECOMPRESSED_SIZE)
+	if err != nil {
+		return loaded, err
+	}
+
+	return loaded, nil
+}
+
+/*
+Overrides the given settings with any command-line flags given in args, which take precedence over the environment
+flags that are not given leave their settings as they are
+*/
+func applyFlags(loaded Config, args []string) (Config, error) {
+	flags := flag.NewFlagSet("receipt-processor", flag.ContinueOnError)
+	port := flags.String("port", "", "the port to listen on, overriding PORT")
+	rulesFile := flags.String("rules", "", "json file to load the scoring rules from, overriding RULES_FILE")
+	logLevel := flags.String("log-level", "", "info or debug, overriding LOG_LEVEL")
+	err := flags.Parse(args)
+	if err != nil {
+		return loaded, err
+	}
+
+	// only the flags actually given are applied, so an empty one can still clear its setting
+	flags.Visit(func(given *flag.Flag) {
+		if err != nil {
+			return
+		}
+		switch given.Name {
+		case "port":
+			loaded.Port, err = parsePort("-port", *port)
+		case "rules":
+			loaded.RulesFile = *rulesFile
+		case "log-level":
+			loaded.LogLevel = strings.ToLower(strings.TrimSpace(*logLevel))
+			if loaded.LogLevel != LOG_LEVEL_INFO && loaded.LogLevel != LOG_LEVEL_DEBUG {
+				err = fmt.Errorf("-log-level must be one of %s, %s, got %q", LOG_LEVEL_INFO, LOG_LEVEL_DEBUG, *logLevel)
+			}
+		}
+	})
+	return loaded, err
+}
+
+// reads a port such as "8080" for the named setting, as the ":8080" the app listens on
+func parsePort(name string, value string) (string, error) {
+	port, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(value), ":"))
+	if err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("%s must be a port between 1 and 65535, got %q", name, value)
+	}
+	return ":" + strconv.Itoa(port), nil
+}
+
+/*
+Reports the settings keyed by the environment variable each is read from, for operators to check a deployment
+secrets are redacted, reporting only whether they are set
+*/
+func (config Config) report() map[string]any {
+	return map[string]any{
+		"PORT":                   config.Port,
+		"RULES_FILE":             config.RulesFile,
+		"RULESETS":               config.Rulesets,
+		"REQUEST_TIMEOUT":        config.RequestTimeout.String(),
+		"MAX_TOTAL":              config.MaxTotal,
+		"ADMIN_TOKEN":            redacted(config.AdminToken),
+		"MAINTENANCE":            config.Maintenance,
+		"CURRENCY_SYMBOLS":       config.CurrencySymbols,
+		"MONEY_LOCALE":           config.MoneyLocale,
+		"LOG_LEVEL":              config.LogLevel,
+		"CACHE_MAX_AGE":          config.CacheMaxAge.String(),
+		"SERVER_TZ":              config.ServerLocation.String(),
+		"SCORE_ROUNDING":         config.ScoreRounding,
+		"SCHEMA_VALIDATION":      config.SchemaValidation,
+		"BATCH_WORKERS":          config.BatchWorkers,
+		"ACCESS_LOG":             config.AccessLog,
+		"RECOVER_PANICS":         config.RecoverPanics,
+		"CORS_ORIGINS":           config.CORSOrigins,
+		"CORS_MAX_AGE":           config.CORSMaxAge.String(),
+		"CORS_CREDENTIALS":       config.CORSCredentials,
+		"STORE_ATTEMPTS":         config.StoreAttempts,
+		"STORE_RETRY_BACKOFF":    config.StoreRetryBackoff.String(),
+		"MAX_DESCRIPTION_LENGTH": config.MaxDescriptionLength,
+		"SHUTDOWN_TIMEOUT":       config.ShutdownTimeout.String(),
+		"VALIDATION_MODE":        config.ValidationMode,
+		"ENVELOPE":               config.Envelope,
+		"MAX_CONCURRENT_SCORING": config.MaxConcurrentScoring,
+		"SCORING_QUEUE_TIMEOUT":  config.ScoringQueueTimeout.String(),
+		"FIELD_ALIASES":          config.FieldAliases,
+		"RETAILER_ALLOWLIST":     config.RetailerAllowlist,
+		"RETAILER_BLOCKLIST":     config.RetailerBlocklist,
+		"MAX_CONCURRENT_PER_KEY": config.MaxConcurrentPerKey,
+		"DUPLICATE_WINDOW":       config.DuplicateWindow.String(),
+		"MIN_PURCHASE_DATE":      config.MinPurchaseDate,
+		"TRUSTED_PROXIES":        config.TrustedProxies,
+		"MAX_TAGS":               config.MaxTags,
+		"MAX_TAG_LENGTH":         config.MaxTagLength,
+		"MAX_DECOMPRESSED_SIZE":  config.MaxDecompressedSize,
+		// the api keys are secrets, so only how many have their own limit is reported
+		"KEY_CONCURRENCY": len(config.KeyConcurrency),
+	}
+}
+
+// stands in for a secret in reports, empty when the secret is not set
+func redacted(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return REDACTED
+}
+
+// reads the named environment variable as a duration such as "5s", falling back to the given default when unset
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value, set := os.LookupEnv(name)
+	if !set || value == "" {
+		return fallback, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fallback, fmt.Errorf("%s must be a positive duration, got %q", name, value)
+	}
+	return duration, nil
+}
+
+// reads the named environment variable as a non-negative whole number, falling back to the given default when unset
+func envInt(name string, fallback int64) (int64, error) {
+	value, set := os.LookupEnv(name)
+	if !set || value == "" {
+		return fallback, nil
+	}
+
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number < 0 {
+		return fallback, fmt.Errorf("%s must be a non-negative whole number, got %q", name, value)
+	}
+	return number, nil
+}
+
+// reads the named environment variable as a boolean such as "true" or "0", falling back to the given default when unset
+func envBool(name string, fallback bool) (bool, error) {
+	value, set := os.LookupEnv(name)
+	if !set || value == "" {
+		return fallback, nil
+	}
+
+	boolean, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback, fmt.Errorf("%s must be true or false, got %q", name, value)
+	}
+	return boolean, nil
+}
+
+// reads the named environment variable as one of the given choices, falling back to the first when unset
+func envChoice(name string, choices ...string) (string, error) {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv(name)))
+	if value == "" {
+		return choices[0], nil
+	}
+
+	for _, choice := range choices {
+		if value == choice {
+			return value, nil
+		}
+	}
+	return choices[0], fmt.Errorf("%s must be one of %s, got %q", name, strings.Join(choices, ", "), value)
+}
+
+// reads the named environment variable as an IANA timezone name such as "Europe/Berlin", falling back to the given default when unset
+func envLocation(name string, fallback *time.Location) (*time.Location, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+
+	location, err := time.LoadLocation(value)
+	if err != nil {
+		return fallback, fmt.Errorf("%s must be a timezone such as \"America/Chicago\", got %q", name, value)
+	}
+	return location, nil
+}
+
+// reads the named environment variable as a comma separated list, skipping empty entries
+func envList(name string) []string {
+	var list []string
+	for _, entry := range strings.Split(os.Getenv(name), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+/*
+Reads the named environment variable as comma separated alias=field pairs, such as "purchase_date=purchaseDate"
+every field must be one a receipt or its items has, and no alias may be one itself
+*/
+func envAliases(name string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	for _, pair := range envList(name) {
+		alias, field, found := strings.Cut(pair, "=")
+		alias, field = strings.TrimSpace(alias), strings.TrimSpace(field)
+		if !found || alias == "" || !isReceiptField(field) {
+			return nil, fmt.Errorf("%s must be alias=field pairs naming receipt fields, got %q", name, pair)
+		}
+		if isReceiptField(alias) {
+			return nil, fmt.Errorf("%s may not alias the receipt field %q", name, alias)
+		}
+		aliases[alias] = field
+	}
+	return aliases, nil
+}
+
+// reads the named environment variable as comma separated key=limit pairs, such as "partner=10", each limit a non-negative whole number
+func envLimits(name string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, pair := range envList(name) {
+		key, value, found := strings.Cut(pair, "=")
+		limit, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if !found || strings.TrimSpace(key) == "" || err != nil || limit < 0 {
+			return nil, fmt.Errorf("%s must be key=limit pairs with non-negative whole limits, got %q", name, pair)
+		}
+		limits[strings.TrimSpace(key)] = limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// loads the settings from the environment as main would, failing the test if they are invalid
+func loadTestConfig(t *testing.T) Config {
+	t.Helper()
+	loaded, err := loadConfig()
+	if err != nil {
+		t.Fatalf("could not load the config: %v", err)
+	}
+	loaded.AccessLog = false
+	return loaded
+}
+
+func TestDebugConfigReportsOverridesAndRedactsSecrets(t *testing.T) {
+	resetState(t)
+	t.Setenv("REQUEST_TIMEOUT", "7s")
+	t.Setenv("MAX_TOTAL", "5000")
+	t.Setenv("ADMIN_TOKEN", "top-secret-token")
+	t.Setenv("KEY_CONCURRENCY", "partner-api-key=2")
+	config = loadTestConfig(t)
+	router := newTestRouter(t)
+
+	recorder := serveRequest(router, http.MethodGet, "/debug/config", "", "Authorization", "Bearer top-secret-token")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("the config responded %d: %s", recorder.Code, recorder.Body)
+	}
+	reported := decodeTestJSON[map[string]any](t, recorder)
+	for name, value := range map[string]any{
+		"REQUEST_TIMEOUT": "7s",
+		"MAX_TOTAL":       float64(5000),
+		"ADMIN_TOKEN":     REDACTED,
+		"KEY_CONCURRENCY": float64(1),
+	} {
+		if reported[name] != value {
+			t.Errorf("%s was reported as %v, expected %v", name, reported[name], value)
+		}
+	}
+	for _, secret := range []string{"top-secret-token", "partner-api-key"} {
+		if strings.Contains(recorder.Body.String(), secret) {
+			t.Errorf("the config reported the secret %q: %s", secret, recorder.Body)
+		}
+	}
+
+	if recorder := serveRequest(router, http.MethodGet, "/debug/config", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("the config without the admin token responded %d, expected %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestInvalidEnvironmentIsRejected(t *testing.T) {
+	for name, value := range map[string]string{
+		"REQUEST_TIMEOUT":       "soon",
+		"MAX_TAGS":              "-1",
+		"LOG_LEVEL":             "verbose",
+		"KEY_CONCURRENCY":       "key",
+		"MAX_DECOMPRESSED_SIZE": "-1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("%s=%s gave error %v, expected one naming it", name, value, err)
+			}
+		})
+	}
+}
+
+func TestFlagsOverrideTheEnvironment(t *testing.T) {
+	t.Setenv("PORT", "9000")
+	t.Setenv("RULES_FILE", "env-rules.json")
+	t.Setenv("LOG_LEVEL", LOG_LEVEL_INFO)
+	loaded := loadTestConfig(t)
+
+	overridden, err := applyFlags(loaded, []string{"-port", "8081", "-rules", "flag-rules.json", "-log-level", "DEBUG"})
+	if err != nil {
+		t.Fatalf("the flags were rejected: %v", err)
+	}
+	if overridden.Port != ":8081" || overridden.RulesFile != "flag-rules.json" || overridden.LogLevel != LOG_LEVEL_DEBUG {
+		t.Errorf("the flags gave port %q, rules %q, log level %q", overridden.Port, overridden.RulesFile, overridden.LogLevel)
+	}
+
+	kept, err := applyFlags(loaded, []string{"-log-level", "debug"})
+	if err != nil {
+		t.Fatalf("the flags were rejected: %v", err)
+	}
+	if kept.Port != ":9000" || kept.RulesFile != "env-rules.json" {
+		t.Errorf("the flags not given did not keep the environment, got port %q, rules %q", kept.Port, kept.RulesFile)
+	}
+
+	for _, args := range [][]string{{"-port", "70000"}, {"-log-level", "verbose"}, {"-unknown"}} {
+		if _, err := applyFlags(loaded, args); err == nil {
+			t.Errorf("the flags %v were accepted", args)
+		}
+	}
+}
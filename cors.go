@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// default time browsers may cache a preflight response for
+const CORS_MAX_AGE = 10 * time.Minute
+
+// the origin that allows any site to call the app
+const ANY_ORIGIN = "*"
+
+// what browsers are told cross-origin requests may use
+const CORS_ALLOWED_METHODS = "GET, HEAD, POST, PUT, DELETE, OPTIONS"
+const CORS_ALLOWED_HEADERS = "Authorization, Content-Type, Content-Encoding, If-None-Match, " + API_KEY_HEADER
+
+/*
+Lets browsers on the CORS_ORIGINS call the app, answering their preflight requests itself
+requests from any other origin are served as usual, without the headers browsers need to let them through
+*/
+func cors(context *gin.Context) {
+	origin := context.GetHeader("Origin")
+	allowed := allowedOrigin(origin)
+	if origin == "" || allowed == "" {
+		context.Next()
+		return
+	}
+
+	context.Header("Access-Control-Allow-Origin", allowed)
+	context.Writer.Header().Add("Vary", "Origin")
+	// browsers refuse credentials alongside the wildcard origin, so they are only allowed for named origins
+	if config.CORSCredentials && allowed != ANY_ORIGIN {
+		context.Header("Access-Control-Allow-Credentials", "true")
+	}
+
+	// answer a preflight request with a 204 status, letting the browser cache it for the configured max-age
+	if context.Request.Method == http.MethodOptions && context.GetHeader("Access-Control-Request-Method") != "" {
+		context.Header("Access-Control-Allow-Methods", CORS_ALLOWED_METHODS)
+		context.Header("Access-Control-Allow-Headers", CORS_ALLOWED_HEADERS)
+		context.Header("Access-Control-Max-Age", strconv.Itoa(int(config.CORSMaxAge.Seconds())))
+		context.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+	context.Next()
+}
+
+// the Access-Control-Allow-Origin to respond to the given origin with, empty if it is not allowed
+func allowedOrigin(origin string) string {
+	for _, allowed := range config.CORSOrigins {
+		if allowed == ANY_ORIGIN {
+			return ANY_ORIGIN
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sends a preflight request from the origin for the given method through the router
+func servePreflight(router http.Handler, path string, origin string, method string) *httptest.ResponseRecorder {
+	return serveRequest(router, http.MethodOptions, path, "", "Origin", origin, "Access-Control-Request-Method", method)
+}
+
+func TestPreflightCarriesMaxAgeAndCredentials(t *testing.T) {
+	resetState(t)
+	config.CORSOrigins = []string{"https://app.example.com"}
+	config.CORSMaxAge = 90 * time.Second
+	config.CORSCredentials = true
+	router := newTestRouter(t)
+
+	recorder := servePreflight(router, "/receipts/process", "https://app.example.com", http.MethodPost)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("the preflight responded %d, expected %d", recorder.Code, http.StatusNoContent)
+	}
+	for header, value := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "90",
+		"Vary":                             "Origin",
+	} {
+		if got := recorder.Header().Get(header); got != value {
+			t.Errorf("the preflight gave %s %q, expected %q", header, got, value)
+		}
+	}
+}
+
+func TestCredentialsAreNeverAllowedForAnyOrigin(t *testing.T) {
+	resetState(t)
+	config.CORSOrigins = []string{ANY_ORIGIN}
+	config.CORSCredentials = true
+	router := newTestRouter(t)
+
+	recorder := servePreflight(router, "/receipts/process", "https://anywhere.example.com", http.MethodPost)
+	if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != ANY_ORIGIN {
+		t.Errorf("the preflight allowed the origin %q, expected %s", origin, ANY_ORIGIN)
+	}
+	if credentials := recorder.Header().Get("Access-Control-Allow-Credentials"); credentials != "" {
+		t.Errorf("the preflight allowed credentials %q alongside the wildcard origin", credentials)
+	}
+	if maxAge := recorder.Header().Get("Access-Control-Max-Age"); maxAge != "600" {
+		t.Errorf("the preflight gave a max-age of %q, expected the default of 600", maxAge)
+	}
+}
+
+func TestOtherOriginsAreNotAllowed(t *testing.T) {
+	resetState(t)
+	config.CORSOrigins = []string{"https://app.example.com"}
+	config.CORSCredentials = true
+	router := newTestRouter(t)
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts/count", "", "Origin", "https://evil.example.com")
+	if recorder.Code != http.StatusOK {
+		t.Errorf("a request from another origin responded %d, expected it to be served as usual", recorder.Code)
+	}
+	for _, header := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials"} {
+		if got := recorder.Header().Get(header); got != "" {
+			t.Errorf("a request from another origin was given %s %q", header, got)
+		}
+	}
+}
+
+func TestPreflightAllowsTheAPIKeyHeader(t *testing.T) {
+	resetState(t)
+	config.CORSOrigins = []string{"https://app.example.com"}
+	router := newTestRouter(t)
+
+	recorder := servePreflight(router, "/receipts/process", "https://app.example.com", http.MethodPost)
+	allowed := strings.Split(recorder.Header().Get("Access-Control-Allow-Headers"), ",")
+	for i := range allowed {
+		allowed[i] = http.CanonicalHeaderKey(strings.TrimSpace(allowed[i]))
+	}
+	for _, header := range []string{http.CanonicalHeaderKey(API_KEY_HEADER), "Authorization", "Content-Type"} {
+		found := false
+		for _, allowedHeader := range allowed {
+			found = found || allowedHeader == header
+		}
+		if !found {
+			t.Errorf("the preflight allowed the headers %v, expected them to include %s", allowed, header)
+		}
+	}
+}
+
+func TestPreflightAllowsDelete(t *testing.T) {
+	resetState(t)
+	config.CORSOrigins = []string{"https://app.example.com"}
+	router := newTestRouter(t)
+
+	recorder := servePreflight(router, "/receipts", "https://app.example.com", http.MethodDelete)
+	if recorder.Code != http.StatusNoContent || !strings.Contains(recorder.Header().Get("Access-Control-Allow-Methods"), http.MethodDelete) {
+		t.Errorf("the DELETE preflight responded %d allowing %q", recorder.Code, recorder.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
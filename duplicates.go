@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// when each client ip last submitted each receipt body, keyed by ip then the SHA-256 hash of the body
+type recentSubmissions struct {
+	lock      sync.Mutex
+	submitted map[string]map[string]time.Time
+	// when expired submissions were last swept out, which happens at most once per window
+	swept time.Time
+}
+
+// every receipt body submitted within the DUPLICATE_WINDOW, so a double-click does not store the receipt twice
+var submissions = &recentSubmissions{submitted: make(map[string]map[string]time.Time)}
+
+/*
+Claims the body with the given hash for the client ip, unless the ip already submitted it within the window
+ok is false if it did, otherwise the claim lasts for the window unless it is released
+*/
+func (recent *recentSubmissions) claim(ip string, hash string, window time.Duration) (ok bool) {
+	recent.lock.Lock()
+	defer recent.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(recent.swept) >= window {
+		recent.sweep(now, window)
+	}
+
+	if submitted, found := recent.submitted[ip][hash]; found && now.Sub(submitted) < window {
+		return false
+	}
+	if recent.submitted[ip] == nil {
+		recent.submitted[ip] = make(map[string]time.Time)
+	}
+	recent.submitted[ip][hash] = now
+	return true
+}
+
+// releases the claim on the body with the given hash, for when the receipt it holds could not be stored after all
+func (recent *recentSubmissions) release(ip string, hash string) {
+	recent.lock.Lock()
+	defer recent.lock.Unlock()
+
+	delete(recent.submitted[ip], hash)
+}
+
+// forgets every submission older than the window, and every ip left without one, the lock must be held
+func (recent *recentSubmissions) sweep(now time.Time, window time.Duration) {
+	for ip, hashes := range recent.submitted {
+		for hash, submitted := range hashes {
+			if now.Sub(submitted) >= window {
+				delete(hashes, hash)
+			}
+		}
+		if len(hashes) == 0 {
+			delete(recent.submitted, ip)
+		}
+	}
+	recent.swept = now
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResubmissionWithinTheWindowIsRejected(t *testing.T) {
+	resetState(t)
+	config.DuplicateWindow = 100 * time.Millisecond
+	router := newTestRouter(t)
+
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+	recorder := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT, "Accept", MIME_PROBLEM_JSON)
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("resubmitting within the window responded %d, expected %d", recorder.Code, http.StatusConflict)
+	}
+	if problem := decodeTestJSON[Problem](t, recorder); problem.Type != DUPLICATE_SUBMISSION_PROBLEM {
+		t.Errorf("resubmitting within the window responded with a %q problem", problem.Type)
+	}
+
+	// other receipts from the same client, and the same receipt from other clients, are let through
+	processTestReceipt(t, router, MM_RECEIPT)
+	if !submissions.claim("203.0.113.7", testContentHash(TARGET_RECEIPT), config.DuplicateWindow) {
+		t.Error("the same receipt from another client was taken for a resubmission")
+	}
+
+	// once the window has passed, the receipt is taken as meant to be sent again
+	time.Sleep(config.DuplicateWindow)
+	if again := processTestReceipt(t, router, TARGET_RECEIPT); again == id {
+		t.Errorf("resubmitting after the window returned the existing %s, expected it stored again", id)
+	}
+}
+
+func TestResubmissionIsAllowedWithoutAWindow(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	processTestReceipt(t, router, TARGET_RECEIPT)
+	processTestReceipt(t, router, TARGET_RECEIPT)
+	if count := testGauge(t, router, "receipts_stored"); count != "2" {
+		t.Errorf("%s receipts were stored after resubmitting without a window, expected 2", count)
+	}
+}
+
+func TestClientIpIsTakenFromTrustedProxies(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		proxies []string
+		status  int
+	}{
+		// httptest requests come from 192.0.2.1
+		{"behind a trusted proxy", []string{"192.0.2.0/24"}, http.StatusOK},
+		{"behind an untrusted proxy", []string{"10.0.0.0/8"}, http.StatusConflict},
+		{"trusting no proxy", nil, http.StatusConflict},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resetState(t)
+			config.DuplicateWindow = time.Minute
+			config.TrustedProxies = test.proxies
+			router := newTestRouter(t)
+
+			if recorder := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT, "X-Forwarded-For", "203.0.113.7"); recorder.Code != http.StatusOK {
+				t.Fatalf("the first client's receipt responded %d: %s", recorder.Code, recorder.Body)
+			}
+			// the same receipt from another client is only told apart when the proxy naming it is trusted
+			if recorder := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT, "X-Forwarded-For", "198.51.100.9"); recorder.Code != test.status {
+				t.Errorf("the second client's receipt responded %d, expected %d", recorder.Code, test.status)
+			}
+			if recorder := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT, "X-Forwarded-For", "203.0.113.7"); recorder.Code != http.StatusConflict {
+				t.Errorf("the first client's resubmission responded %d, expected %d", recorder.Code, http.StatusConflict)
+			}
+		})
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, proxy.internal")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "TRUSTED_PROXIES") {
+		t.Errorf("an invalid TRUSTED_PROXIES gave error %v, expected one naming it", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/xid"
+)
+
+// successful json responses when ENVELOPE is on, the response as it would otherwise be, along with metadata about the request
+type Envelope struct {
+	Data json.RawMessage `json:"data"`
+	Meta EnvelopeMeta    `json:"meta"`
+}
+
+// what is known about the request an enveloped response answers
+type EnvelopeMeta struct {
+	RequestId  string  `json:"requestId"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// holds back the body of a successful json response so it can be enveloped, letting any other response straight through
+type envelopeWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	decided   bool
+	enveloped bool
+}
+
+func (writer *envelopeWriter) Write(data []byte) (int, error) {
+	// whether to envelope is decided by the first write, once the status and content type are set
+	if !writer.decided {
+		writer.decided = true
+		status := writer.ResponseWriter.Status()
+		writer.enveloped = status >= 200 && status < 300 && strings.HasPrefix(writer.Header().Get("Content-Type"), gin.MIMEJSON)
+	}
+	if writer.enveloped {
+		return writer.body.Write(data)
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *envelopeWriter) WriteString(data string) (int, error) {
+	return writer.Write([]byte(data))
+}
+
+// flushing is put off until the envelope is written
+func (writer *envelopeWriter) Flush() {
+	if !writer.enveloped {
+		writer.ResponseWriter.Flush()
+	}
+}
+
+/*
+Wraps every successful json response in an Envelope, tagging the request with an id sent back as X-Request-Id
+error responses, and anything that is not json such as qr codes and ndjson exports, are left as they are
+*/
+func envelope(context *gin.Context) {
+	start := time.Now()
+	requestId := xid.New().String()
+	context.Header("X-Request-Id", requestId)
+
+	writer := &envelopeWriter{ResponseWriter: context.Writer}
+	context.Writer = writer
+	context.Next()
+	context.Writer = writer.ResponseWriter
+
+	if !writer.enveloped {
+		return
+	}
+	wrapped, err := json.Marshal(Envelope{
+		Data: writer.body.Bytes(),
+		Meta: EnvelopeMeta{RequestId: requestId, DurationMs: float64(time.Since(start)) / float64(time.Millisecond)},
+	})
+	if err != nil {
+		// the body was not valid json after all, so it is sent as it was
+		wrapped = writer.body.Bytes()
+	}
+	writer.ResponseWriter.Write(wrapped)
+}
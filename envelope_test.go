@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestEnvelopeWrapsPoints(t *testing.T) {
+	for _, enveloped := range []bool{false, true} {
+		// the receipt is processed before the envelope is turned on, so its id can be read as usual
+		resetState(t)
+		id := processTestReceipt(t, newTestRouter(t), TARGET_RECEIPT)
+		config.Envelope = enveloped
+		router := newTestRouter(t)
+
+		recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("points with ENVELOPE=%t responded %d: %s", enveloped, recorder.Code, recorder.Body)
+		}
+		if !enveloped {
+			if points := decodeTestJSON[Points](t, recorder); points.Points != TARGET_POINTS {
+				t.Errorf("the raw points were %d, expected %d", points.Points, TARGET_POINTS)
+			}
+			if requestId := recorder.Header().Get("X-Request-Id"); requestId != "" {
+				t.Errorf("a raw response was tagged with request id %s", requestId)
+			}
+			continue
+		}
+
+		wrapped := decodeTestJSON[Envelope](t, recorder)
+		var points Points
+		if err := json.Unmarshal(wrapped.Data, &points); err != nil || points.Points != TARGET_POINTS {
+			t.Errorf("the enveloped data was %s, expected %d points", wrapped.Data, TARGET_POINTS)
+		}
+		if wrapped.Meta.RequestId == "" || wrapped.Meta.RequestId != recorder.Header().Get("X-Request-Id") {
+			t.Errorf("the envelope has request id %q, expected the X-Request-Id %q", wrapped.Meta.RequestId, recorder.Header().Get("X-Request-Id"))
+		}
+		if wrapped.Meta.DurationMs < 0 {
+			t.Errorf("the envelope has a duration of %fms", wrapped.Meta.DurationMs)
+		}
+
+		// errors are left as they are, so clients can read them the same either way
+		missing := serveRequest(router, http.MethodGet, "/receipts/missing/points", "")
+		if missing.Code != http.StatusNotFound || decodeTestJSON[Description](t, missing).Description == "" {
+			t.Errorf("a missing receipt responded %d: %s, expected an unwrapped description", missing.Code, missing.Body)
+		}
+	}
+}
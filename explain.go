@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// response of /receipts/explain endpoint, what a receipt would be worth and how it could be worth more
+type Explanation struct {
+	Breakdown
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+// one change to a receipt that would make it worth more points, and how many more
+type Suggestion struct {
+	Suggestion string `json:"suggestion"`
+	Points     int    `json:"points"`
+}
+
+/*
+Scores the given receipt without storing it, explaining the points it would be worth and the bonuses it missed
+takes the receipt as a json object
+responds with the points the receipt would be worth broken down by rule, and suggestions for how it could be worth more
+*/
+func explainReceipt(context *gin.Context) {
+	// attempt to create a valid Receipt struct from the given JSON object, abort on failure with 400 error
+	receipt, err := bindReceipt(context)
+	if err != nil {
+		abortWithError(context, http.StatusBadRequest, INVALID_RECEIPT_PROBLEM, "The receipt is invalid: "+err.Error())
+		return
+	}
+
+	// the receipt is not stored, so it would be the first of its day if none is stored for it yet
+	_, found, err := receipts.FirstOnDate(context.Request.Context(), receipt.PurchaseDate)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	facts := ScoringFacts{FirstOnDate: !found}
+
+	release, err := acquireScoringSlot(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	defer release()
+
+	// return the explanation as a json object with a 200 status
+	ruleset := currentRules()
+	breakdown := CalculateBreakdown(receipt, ruleset, facts)
+	context.JSON(http.StatusOK, Explanation{Breakdown: breakdown, Suggestions: suggestionsFor(receipt, ruleset, facts, breakdown.Points)})
+}
+
+/*
+Suggests each change to the receipt's total, day, or time of purchase that on its own would make it worth more points
+the same changes are tried as CalculateMaxPoints combines, and only the best total is suggested
+*/
+func suggestionsFor(receipt Receipt, rules Rules, facts ScoringFacts, points int) []Suggestion {
+	suggestions := []Suggestion{}
+	suggest := func(changed Receipt, changedFacts ScoringFacts, suggestion string) {
+		if more := CalculatePoints(changed, rules, changedFacts) - points; more > 0 {
+			suggestions = append(suggestions, Suggestion{Suggestion: fmt.Sprintf("%s for %d more points", suggestion, more), Points: more})
+		}
+	}
+
+	if purchaseTime, ok := bonusWindowTime(rules); ok {
+		suggest(purchasedAt(receipt, receipt.PurchaseDate, purchaseTime), facts, fmt.Sprintf("purchase between %s and %s", rules.BonusWindowStart, rules.BonusWindowEnd))
+	}
+	if purchaseDate, ok := oddDayBefore(receipt.PurchaseDate); ok {
+		// whether the receipt would be the first stored on the day before is not known, so it is not assumed to be
+		suggest(purchasedAt(receipt, purchaseDate, receipt.PurchaseTime), ScoringFacts{}, "purchase on an odd day of the month, such as "+purchaseDate)
+	}
+
+	bestTotal, bestPoints := "", points
+	for _, total := range quarterTotals(receipt.Total, currencyOf(receipt)) {
+		changed := receipt
+		changed.Total = total
+		if totalPoints := CalculatePoints(changed, rules, facts); totalPoints > bestPoints {
+			bestTotal, bestPoints = total, totalPoints
+		}
+	}
+	if bestTotal != "" {
+		changed := receipt
+		changed.Total = bestTotal
+		suggest(changed, facts, "spend a total of "+bestTotal)
+	}
+	return suggestions
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestExplainSuggestsMissedBonuses(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	for _, test := range []struct {
+		name        string
+		receipt     string
+		points      int
+		suggestions []Suggestion
+	}{
+		// purchased at 13:01 for a total that is neither round nor a quarter
+		{"target", TARGET_RECEIPT, TARGET_POINTS, []Suggestion{
+			{"purchase between 14:00 and 16:00 for 10 more points", BETWEEN_2PM_AND_4PM_BONUS},
+			{"spend a total of 35.00 for 75 more points", ROUND_DOLLAR_AMOUNT_BONUS + MULTIPLE_OF_0_POINT_25_BONUS},
+		}},
+		// purchased on the 20th, but otherwise at its best
+		{"m&m corner market", MM_RECEIPT, MM_POINTS, []Suggestion{
+			{"purchase on an odd day of the month, such as 2022-03-19 for 6 more points", ODD_DAY_BONUS},
+		}},
+	} {
+		recorder := serveRequest(router, http.MethodPost, "/receipts/explain", test.receipt)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("explaining %s responded %d: %s", test.name, recorder.Code, recorder.Body)
+		}
+		explanation := decodeTestJSON[Explanation](t, recorder)
+		if explanation.Points != test.points || len(explanation.Rules) == 0 {
+			t.Errorf("%s was explained as %d points from %d rules, expected %d", test.name, explanation.Points, len(explanation.Rules), test.points)
+		}
+		if !reflect.DeepEqual(explanation.Suggestions, test.suggestions) {
+			t.Errorf("%s was given the suggestions %+v, expected %+v", test.name, explanation.Suggestions, test.suggestions)
+		}
+	}
+
+	// explaining a receipt does not store it
+	if count := testGauge(t, router, "receipts_stored"); count != "0" {
+		t.Errorf("%s receipts were stored after explaining, expected none", count)
+	}
+	if recorder := serveRequest(router, http.MethodPost, "/receipts/explain", `{"retailer":"Target"}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("explaining an invalid receipt responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// content type of newline delimited json, one receipt per line
+const MIME_NDJSON = "application/x-ndjson"
+
+// content type of gzipped gob, one GobReceipt after another
+const MIME_GOB = "application/x-gob"
+
+// the formats receipts can be exported and imported in
+const JSON_FORMAT = "json"
+const NDJSON_FORMAT = "ndjson"
+const GOB_FORMAT = "gob"
+
+// how many receipts are streamed between flushes of an ndjson export
+const EXPORT_FLUSH_EVERY = 100
+
+// response of /receipts/import endpoint, the number of receipts imported
+type Imported struct {
+	Imported int `json:"imported"`
+}
+
+// a stored receipt as it is written in a gob export, kept apart from StoredReceipt so backups still read if that changes
+type GobReceipt struct {
+	Id           string
+	Retailer     string
+	PurchaseDate string
+	PurchaseTime string
+	Total        string
+	Currency     string
+	Items        []GobItem
+	Tags         []string
+	CreatedAt    time.Time
+}
+
+// an item as it is written in a gob export
+type GobItem struct {
+	ShortDescription string
+	Price            string
+	Category         string
+}
+
+/*
+Exports every stored receipt, along with its id and when it was stored
+takes the format via the format query param, either json for a single array, ndjson for one receipt per line,
+or gob for a compact binary backup
+responds with the receipts in the order they were stored
+*/
+func exportReceipts(context *gin.Context) {
+	format := context.DefaultQuery("format", JSON_FORMAT)
+	if format != JSON_FORMAT && format != NDJSON_FORMAT && format != GOB_FORMAT {
+		abortWithError(context, http.StatusBadRequest, UNKNOWN_FORMAT_PROBLEM, "The format must be json, ndjson, or gob")
+		return
+	}
+
+	stored, err := receipts.List(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	// return the receipts as a json array with a 200 status
+	if format == JSON_FORMAT {
+		context.JSON(http.StatusOK, stored)
+		return
+	}
+
+	// or as gzipped gob with a 200 status, compressed as part of the format so a saved backup can be imported as it is
+	if format == GOB_FORMAT {
+		context.Header("Content-Type", MIME_GOB)
+		context.Status(http.StatusOK)
+		compressor := gzip.NewWriter(context.Writer)
+		defer compressor.Close()
+		encoder := gob.NewEncoder(compressor)
+		for _, receipt := range stored {
+			if err := encoder.Encode(toGob(receipt)); err != nil {
+				// the client has gone away, there is no one left to tell
+				return
+			}
+		}
+		return
+	}
+
+	// or stream them one per line with a 200 status, flushing as we go so the client can start parsing
+	context.Header("Content-Type", MIME_NDJSON)
+	context.Status(http.StatusOK)
+	encoder := json.NewEncoder(context.Writer)
+	for i, receipt := range stored {
+		if err := encoder.Encode(receipt); err != nil {
+			// the client has gone away, there is no one left to tell
+			return
+		}
+		if (i+1)%EXPORT_FLUSH_EVERY == 0 {
+			context.Writer.Flush()
+		}
+	}
+}
+
+/*
+Imports receipts, keeping the ids they were exported with and when they were stored
+takes the receipts as a json array, or as ndjson with one receipt per line or a gob export when the Content-Type says so
+responds with the number of receipts imported, or imports none of them if any is invalid
+*/
+func importReceipts(context *gin.Context) {
+	var imported []StoredReceipt
+	var err error
+	switch context.ContentType() {
+	case MIME_NDJSON:
+		imported, err = decodeNDJSON(context.Request.Body)
+	case MIME_GOB:
+		imported, err = decodeGob(context.Request.Body)
+	default:
+		err = json.NewDecoder(context.Request.Body).Decode(&imported)
+	}
+	if err != nil {
+		abortWithError(context, http.StatusBadRequest, INVALID_RECEIPT_PROBLEM, "The receipts could not be read: "+err.Error())
+		return
+	}
+
+	// every receipt must be valid before any is stored, abort otherwise with 400 error
+	for i, receipt := range imported {
+		receipt.Receipt = normalizeReceipt(receipt.Receipt)
+		imported[i] = receipt
+		if receipt.Id == "" {
+			err = fmt.Errorf("no id given")
+		} else if err = binding.Validator.ValidateStruct(receipt.Receipt); err == nil {
+			err = validateReceipt(receipt.Receipt)
+		}
+		if err != nil {
+			abortWithError(context, http.StatusBadRequest, INVALID_RECEIPT_PROBLEM, fmt.Sprintf("Receipt %d is invalid: %v", i+1, err))
+			return
+		}
+	}
+
+	// each receipt keeps when it was stored, and a receipt it replaces is no longer counted for its old purchase date
+	for _, receipt := range imported {
+		purchaseDates := []string{receipt.PurchaseDate}
+		previous, found, err := receipts.Get(context.Request.Context(), receipt.Id)
+		if err != nil {
+			abortWithStoreError(context, err)
+			return
+		}
+		if found && previous.PurchaseDate != receipt.PurchaseDate {
+			purchaseDates = append(purchaseDates, previous.PurchaseDate)
+		}
+		err = rewritingFirstsOnDates(context.Request.Context(), purchaseDates, func() error {
+			return receipts.Restore(context.Request.Context(), receipt)
+		})
+		if err != nil {
+			abortWithStoreError(context, err)
+			return
+		}
+		scores.forget(receipt.Id)
+	}
+
+	// return the number of receipts imported as a json object with a 200 status
+	context.JSON(http.StatusOK, Imported{Imported: len(imported)})
+}
+
+// reads one receipt from each line of the given ndjson until it runs out
+func decodeNDJSON(reader io.Reader) ([]StoredReceipt, error) {
+	decoded := []StoredReceipt{}
+	decoder := json.NewDecoder(reader)
+	for {
+		var receipt StoredReceipt
+		err := decoder.Decode(&receipt)
+		if err == io.EOF {
+			return decoded, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("receipt %d: %w", len(decoded)+1, err)
+		}
+		decoded = append(decoded, receipt)
+	}
+}
+
+// reads every receipt from the given gob export until it runs out
+func decodeGob(reader io.Reader) ([]StoredReceipt, error) {
+	decompressor, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+
+	decoded := []StoredReceipt{}
+	decoder := gob.NewDecoder(decompressor)
+	for {
+		var receipt GobReceipt
+		err := decoder.Decode(&receipt)
+		if err == io.EOF {
+			return decoded, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("receipt %d: %w", len(decoded)+1, err)
+		}
+		decoded = append(decoded, fromGob(receipt))
+	}
+}
+
+// the stored receipt as it is written in a gob export
+func toGob(stored StoredReceipt) GobReceipt {
+	items := make([]GobItem, len(stored.Items))
+	for i, item := range stored.Items {
+		items[i] = GobItem{ShortDescription: item.ShortDescription, Price: item.Price, Category: item.Category}
+	}
+	return GobReceipt{
+		Id:           stored.Id,
+		Retailer:     stored.Retailer,
+		PurchaseDate: stored.PurchaseDate,
+		PurchaseTime: stored.PurchaseTime,
+		Total:        stored.Total,
+		Currency:     stored.Currency,
+		Items:        items,
+		Tags:         stored.Tags,
+		CreatedAt:    stored.CreatedAt,
+	}
+}
+
+// the stored receipt read from a gob export
+func fromGob(receipt GobReceipt) StoredReceipt {
+	items := make([]Item, len(receipt.Items))
+	for i, item := range receipt.Items {
+		items[i] = Item{ShortDescription: item.ShortDescription, Price: item.Price, Category: item.Category}
+	}
+	return StoredReceipt{
+		Id: receipt.Id,
+		Receipt: Receipt{
+			Retailer:     receipt.Retailer,
+			PurchaseDate: receipt.PurchaseDate,
+			PurchaseTime: receipt.PurchaseTime,
+			Total:        receipt.Total,
+			Currency:     receipt.Currency,
+			Items:        items,
+			Tags:         receipt.Tags,
+		},
+		CreatedAt: receipt.CreatedAt,
+	}
+}
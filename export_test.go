@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the stored receipts exported in the given format, failing the test unless they are
+func exportTestReceipts(t *testing.T, router http.Handler, format string) string {
+	t.Helper()
+	recorder := serveRequest(router, http.MethodGet, "/receipts/export?format="+format, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("exporting as %s responded %d: %s", format, recorder.Code, recorder.Body)
+	}
+	return recorder.Body.String()
+}
+
+// imports the receipts with the given Content-Type into an empty store, failing the test unless every one is imported
+func importTestReceipts(t *testing.T, body string, contentType string, count int) http.Handler {
+	t.Helper()
+	resetState(t)
+	config.AdminToken = TEST_ADMIN_TOKEN
+	router := newTestRouter(t)
+
+	recorder := serveAdminRequest(router, http.MethodPost, "/receipts/import", body, "Content-Type", contentType)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("importing as %s responded %d: %s", contentType, recorder.Code, recorder.Body)
+	}
+	if imported := decodeTestJSON[Imported](t, recorder).Imported; imported != count {
+		t.Fatalf("imported %d receipts as %s, expected %d", imported, contentType, count)
+	}
+	return router
+}
+
+func TestExportRoundTripsThroughNDJSON(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	target := processTestReceipt(t, router, TARGET_RECEIPT)
+	mm := processTestReceipt(t, router, MM_RECEIPT)
+	exported := exportTestReceipts(t, router, JSON_FORMAT)
+
+	ndjson := exportTestReceipts(t, router, NDJSON_FORMAT)
+	lines := strings.Split(strings.TrimSuffix(ndjson, "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], target) || !strings.Contains(lines[1], mm) {
+		t.Fatalf("exported %q, expected one line for each receipt in the order they were stored", ndjson)
+	}
+
+	imported := importTestReceipts(t, ndjson, MIME_NDJSON, 2)
+	if reexported := exportTestReceipts(t, imported, JSON_FORMAT); reexported != exported {
+		t.Errorf("the round trip exported %s, expected %s", reexported, exported)
+	}
+	if points := testPoints(t, imported, mm); points != MM_POINTS {
+		t.Errorf("the imported receipt scored %d, expected %d", points, MM_POINTS)
+	}
+}
+
+func TestExportStreamsNDJSON(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	for i := 0; i < EXPORT_FLUSH_EVERY+1; i++ {
+		processTestReceipt(t, router, MM_RECEIPT)
+	}
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts/export?format=ndjson", "")
+	if contentType := recorder.Header().Get("Content-Type"); contentType != MIME_NDJSON {
+		t.Errorf("exported as %q, expected %s", contentType, MIME_NDJSON)
+	}
+	if lines := strings.Count(recorder.Body.String(), "\n"); lines != EXPORT_FLUSH_EVERY+1 {
+		t.Errorf("exported %d lines, expected %d", lines, EXPORT_FLUSH_EVERY+1)
+	}
+}
+
+func TestExportRejectsUnknownFormat(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/export?format=xml", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("exporting as xml responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestImportRejectsMalformedNDJSON(t *testing.T) {
+	resetState(t)
+	config.AdminToken = TEST_ADMIN_TOKEN
+	router := newTestRouter(t)
+
+	recorder := serveAdminRequest(router, http.MethodPost, "/receipts/import", `{"id": "a"}`+"\nnot json\n", "Content-Type", MIME_NDJSON)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("importing malformed ndjson responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExportRoundTripsThroughGob(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	first := processTestReceipt(t, router, TARGET_RECEIPT)
+	later := processTestReceipt(t, router, targetVariant(t, "Target Store", "2022-01-01", "35.35"))
+	processTestReceipt(t, router, MM_RECEIPT)
+	exported := exportTestReceipts(t, router, JSON_FORMAT)
+
+	gobExport := exportTestReceipts(t, router, GOB_FORMAT)
+	if len(gobExport) >= len(exported) {
+		t.Errorf("the gob export is %d bytes, expected it smaller than the %d bytes of json", len(gobExport), len(exported))
+	}
+
+	// every field of every receipt survives the round trip, including when it was stored
+	imported := importTestReceipts(t, gobExport, MIME_GOB, 3)
+	if reexported := exportTestReceipts(t, imported, JSON_FORMAT); reexported != exported {
+		t.Errorf("the gob round trip exported %s, expected %s", reexported, exported)
+	}
+	replaceTestRules(testRules(t, `{"firstPurchaseOfDay": true}`))
+	if points := testPoints(t, imported, first); points != TARGET_POINTS+FIRST_PURCHASE_OF_DAY_BONUS {
+		t.Errorf("the first imported receipt of its day scored %d, expected %d", points, TARGET_POINTS+FIRST_PURCHASE_OF_DAY_BONUS)
+	}
+	laterReceipt := testReceipt(t, targetVariant(t, "Target Store", "2022-01-01", "35.35"))
+	if points, expected := testPoints(t, imported, later), CalculatePoints(laterReceipt, defaultRules(), ScoringFacts{}); points != expected {
+		t.Errorf("the later imported receipt of its day scored %d, expected %d", points, expected)
+	}
+}
+
+func TestImportRebuildsFirstPurchasesOfTheDay(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	first := processTestReceipt(t, router, TARGET_RECEIPT)
+	later := processTestReceipt(t, router, targetVariant(t, "Target Store", "2022-01-01", "35.35"))
+	laterPoints := testPoints(t, router, later)
+
+	// the later receipt is imported first, but the first of the day is still the one stored earliest
+	stored := decodeTestJSON[[]StoredReceipt](t, serveRequest(router, http.MethodGet, "/receipts/export", ""))
+	stored[0], stored[1] = stored[1], stored[0]
+	reversed, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatalf("could not encode the reversed export: %v", err)
+	}
+	imported := importTestReceipts(t, string(reversed), gin.MIMEJSON, 2)
+	replaceTestRules(testRules(t, `{"firstPurchaseOfDay": true}`))
+
+	if points := testPoints(t, imported, first); points != TARGET_POINTS+FIRST_PURCHASE_OF_DAY_BONUS {
+		t.Errorf("the receipt stored first on its day scored %d after import, expected %d", points, TARGET_POINTS+FIRST_PURCHASE_OF_DAY_BONUS)
+	}
+	if points := testPoints(t, imported, later); points != laterPoints {
+		t.Errorf("the receipt stored later on its day scored %d after import, expected %d", points, laterPoints)
+	}
+	for _, receipt := range decodeTestJSON[[]StoredReceipt](t, serveRequest(imported, http.MethodGet, "/receipts/export", "")) {
+		if original := stored[0]; receipt.Id == original.Id && !receipt.CreatedAt.Equal(original.CreatedAt) {
+			t.Errorf("%s was imported as stored at %s, expected %s", receipt.Id, receipt.CreatedAt, original.CreatedAt)
+		}
+	}
+}
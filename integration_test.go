@@ -0,0 +1,80 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// decodes the json body of the live response into the given value, failing the test unless it is a 200
+func decodeLiveJSON(t *testing.T, response *http.Response, err error, decoded any) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("the request failed: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("responded %d", response.StatusCode)
+	}
+	if err := json.NewDecoder(response.Body).Decode(decoded); err != nil {
+		t.Fatalf("could not decode the response: %v", err)
+	}
+}
+
+func TestLiveServerScoresTheChallengeReceipts(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	listener, err := net.Listen("tcp", HOST+":0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	stop, shutDown := context.WithCancel(context.Background())
+	defer shutDown()
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(router, listener, stop, time.Second)
+	}()
+
+	base := fmt.Sprintf("http://%s", listener.Addr())
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, test := range []struct {
+		receipt string
+		points  int
+	}{
+		{TARGET_RECEIPT, TARGET_POINTS},
+		{MM_RECEIPT, MM_POINTS},
+	} {
+		var id Id
+		response, err := client.Post(base+"/receipts/process", "application/json", bytes.NewBufferString(test.receipt))
+		decodeLiveJSON(t, response, err, &id)
+
+		var points Points
+		response, err = client.Get(base + "/receipts/" + id.Id + "/points")
+		decodeLiveJSON(t, response, err, &points)
+		if points.Points != test.points {
+			t.Errorf("receipt %s scored %d, expected %d", id.Id, points.Points, test.points)
+		}
+	}
+
+	// the server stops cleanly once told to, and no longer accepts connections
+	shutDown()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("the server stopped with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the server did not stop")
+	}
+	if _, err := client.Get(base + "/receipts/count"); err == nil {
+		t.Errorf("the server still accepted connections once stopped")
+	}
+}
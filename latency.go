@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many of the most recent request latencies are kept per endpoint
+const LATENCY_RESERVOIR_SIZE = 1024
+
+// the most recent latencies of one endpoint, older samples are overwritten once it is full
+type latencyReservoir struct {
+	samples []time.Duration
+	next    int
+}
+
+// the latency reservoirs of every endpoint, keyed by route
+type latencyRecorder struct {
+	lock       sync.Mutex
+	reservoirs map[string]*latencyReservoir
+}
+
+// response of /stats/latency endpoint, the recent latency percentiles of one endpoint in milliseconds
+type LatencyPercentiles struct {
+	Samples int     `json:"samples"`
+	P50     float64 `json:"p50"`
+	P95     float64 `json:"p95"`
+	P99     float64 `json:"p99"`
+}
+
+// the recent latencies of every request the app has served
+var latencies = &latencyRecorder{reservoirs: make(map[string]*latencyReservoir)}
+
+// records the latency of every request against its route, requests that matched no route are ignored
+func recordLatency(context *gin.Context) {
+	start := time.Now()
+	context.Next()
+
+	if route := context.FullPath(); route != "" {
+		latencies.record(context.Request.Method+" "+route, time.Since(start))
+	}
+}
+
+// adds the latency to the endpoint's reservoir, replacing the oldest sample if it is full
+func (recorder *latencyRecorder) record(endpoint string, latency time.Duration) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	reservoir, found := recorder.reservoirs[endpoint]
+	if !found {
+		reservoir = &latencyReservoir{}
+		recorder.reservoirs[endpoint] = reservoir
+	}
+
+	if len(reservoir.samples) < LATENCY_RESERVOIR_SIZE {
+		reservoir.samples = append(reservoir.samples, latency)
+	} else {
+		reservoir.samples[reservoir.next] = latency
+	}
+	reservoir.next = (reservoir.next + 1) % LATENCY_RESERVOIR_SIZE
+}
+
+// the latency percentiles of every endpoint with at least one sample
+func (recorder *latencyRecorder) percentiles() map[string]LatencyPercentiles {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	percentiles := make(map[string]LatencyPercentiles, len(recorder.reservoirs))
+	for endpoint, reservoir := range recorder.reservoirs {
+		sorted := append([]time.Duration(nil), reservoir.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		percentiles[endpoint] = LatencyPercentiles{
+			Samples: len(sorted),
+			P50:     percentile(sorted, 50),
+			P95:     percentile(sorted, 95),
+			P99:     percentile(sorted, 99),
+		}
+	}
+	return percentiles
+}
+
+// the nearest-rank percentile of the sorted latencies, in milliseconds
+func percentile(sorted []time.Duration, rank float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	index := int(math.Ceil(rank/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return float64(sorted[index]) / float64(time.Millisecond)
+}
+
+/*
+Reports the recent request latencies of every endpoint
+responds with the p50, p95, and p99 latencies in milliseconds, keyed by endpoint
+*/
+func getLatencyStats(context *gin.Context) {
+	// return the percentiles as a json object with a 200 status
+	context.JSON(http.StatusOK, latencies.percentiles())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLatencyPercentiles(t *testing.T) {
+	resetState(t)
+
+	// the latencies 1ms through 100ms, in no particular order
+	for _, i := range rand.Perm(100) {
+		latencies.record("GET /test", time.Duration(i+1)*time.Millisecond)
+	}
+
+	got := latencies.percentiles()["GET /test"]
+	expected := LatencyPercentiles{Samples: 100, P50: 50, P95: 95, P99: 99}
+	if got != expected {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+}
+
+func TestPercentileOfFewSamples(t *testing.T) {
+	for _, test := range []struct {
+		samples  []time.Duration
+		rank     float64
+		expected float64
+	}{
+		{nil, 50, 0},
+		{[]time.Duration{3 * time.Millisecond}, 99, 3},
+		{[]time.Duration{time.Millisecond, 2 * time.Millisecond}, 50, 1},
+		{[]time.Duration{time.Millisecond, 2 * time.Millisecond}, 51, 2},
+		{[]time.Duration{time.Millisecond, 1500 * time.Microsecond, 2 * time.Millisecond}, 50, 1.5},
+	} {
+		if got := percentile(test.samples, test.rank); got != test.expected {
+			t.Errorf("p%g of %v is %g, expected %g", test.rank, test.samples, got, test.expected)
+		}
+	}
+}
+
+func TestLatencyReservoirIsBounded(t *testing.T) {
+	resetState(t)
+
+	// once full, the oldest samples are the ones replaced
+	for i := 1; i <= LATENCY_RESERVOIR_SIZE+10; i++ {
+		latencies.record("GET /test", time.Duration(i)*time.Millisecond)
+	}
+
+	reservoir := latencies.reservoirs["GET /test"]
+	if len(reservoir.samples) != LATENCY_RESERVOIR_SIZE {
+		t.Fatalf("kept %d samples, expected %d", len(reservoir.samples), LATENCY_RESERVOIR_SIZE)
+	}
+	for _, sample := range reservoir.samples {
+		if sample <= 10*time.Millisecond {
+			t.Errorf("kept the sample %s, one of the 10 oldest", sample)
+		}
+	}
+}
+
+func TestLatencyEndpoint(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	serveRequest(router, http.MethodGet, "/receipts/count", "")
+	serveRequest(router, http.MethodGet, "/receipts/count", "")
+	serveRequest(router, http.MethodGet, "/no/such/route", "")
+
+	recorder := serveRequest(router, http.MethodGet, "/stats/latency", "")
+	stats := decodeTestJSON[map[string]LatencyPercentiles](t, recorder)
+	if stats["GET /receipts/count"].Samples != 2 {
+		t.Errorf("recorded %+v for the count endpoint, expected 2 samples", stats["GET /receipts/count"])
+	}
+	if len(stats) != 1 {
+		t.Errorf("recorded latencies for %v, expected only the count endpoint", stats)
+	}
+}
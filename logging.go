@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the levels the app can log at, debug includes everything logged at info
+const LOG_LEVEL_DEBUG = "debug"
+const LOG_LEVEL_INFO = "info"
+
+/*
+Logs the message with the given key value pairs at debug level, as logfmt
+does nothing unless the app is running at debug level
+*/
+func logDebug(message string, keyValues ...interface{}) {
+	if config.LogLevel != LOG_LEVEL_DEBUG {
+		return
+	}
+	logfmt(LOG_LEVEL_DEBUG, message, keyValues...)
+}
+
+// logs the message with the given key value pairs at info level, as logfmt
+func logInfo(message string, keyValues ...interface{}) {
+	logfmt(LOG_LEVEL_INFO, message, keyValues...)
+}
+
+// logs the message with the given key value pairs as a single logfmt line at the given level
+func logfmt(level string, message string, keyValues ...interface{}) {
+	var line strings.Builder
+	fmt.Fprintf(&line, "level=%s msg=%q", level, message)
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		fmt.Fprintf(&line, " %v=%q", keyValues[i], fmt.Sprint(keyValues[i+1]))
+	}
+	log.Print(line.String())
+}
+
+// logs every request once it has been served, at info level
+func accessLog(context *gin.Context) {
+	start := time.Now()
+	context.Next()
+
+	logInfo("request served",
+		"method", context.Request.Method,
+		"path", context.Request.URL.Path,
+		"status", context.Writer.Status(),
+		"latency", time.Since(start),
+		"client", context.ClientIP())
+}
+
+// logs every rule's contribution to the receipt's points, and the total, at debug level
+func traceBreakdown(id string, ruleset Rules, breakdown Breakdown) {
+	for _, contribution := range breakdown.Rules {
+		logDebug("rule contribution", "receipt", id, "rules", ruleset.Version, "rule", contribution.Rule, "points", contribution.Points, "detail", contribution.Detail)
+	}
+	logDebug("receipt scored", "receipt", id, "rules", ruleset.Version, "points", breakdown.Points)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// collects everything logged until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &logged
+}
+
+func TestAccessLogIsStructured(t *testing.T) {
+	resetState(t)
+	config.AccessLog = true
+	router := newTestRouter(t)
+	logged := captureLog(t)
+
+	serveRequest(router, http.MethodGet, "/receipts/missing", "")
+
+	line := logged.String()
+	for _, field := range []string{`level=info`, `msg="request served"`, `method="GET"`, `path="/receipts/missing"`, `status="404"`, `latency=`, `client=`} {
+		if !strings.Contains(line, field) {
+			t.Errorf("the access log %q has no %s", line, field)
+		}
+	}
+	if count := strings.Count(line, "\n"); count != 1 {
+		t.Errorf("logged %d lines for one request, expected 1", count)
+	}
+}
+
+func TestScoringTraceAtDebugLevel(t *testing.T) {
+	resetState(t)
+	config.LogLevel = LOG_LEVEL_DEBUG
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+	logged := captureLog(t)
+
+	testPoints(t, router, id)
+
+	trace := logged.String()
+	for _, rule := range []string{RETAILER_NAME_RULE, EVERY_TWO_ITEMS_RULE, ITEM_DESCRIPTION_RULE, ODD_PURCHASE_DAY_RULE} {
+		if !strings.Contains(trace, `msg="rule contribution" receipt="`+id+`" rules="`+RULES_VERSION+`" rule="`+rule+`"`) {
+			t.Errorf("the trace %q has no contribution of %s", trace, rule)
+		}
+	}
+	if !strings.Contains(trace, `level=debug msg="receipt scored" receipt="`+id+`" rules="`+RULES_VERSION+`" points="28"`) {
+		t.Errorf("the trace %q has no total", trace)
+	}
+}
+
+func TestNoScoringTraceAtInfoLevel(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+	logged := captureLog(t)
+
+	testPoints(t, router, id)
+
+	if logged.Len() != 0 {
+		t.Errorf("logged %q at info level, expected nothing", logged)
+	}
+}
+
+func TestPanicsAreRecoveredAndLoggedOnce(t *testing.T) {
+	resetState(t)
+	config.AccessLog, config.RecoverPanics = true, true
+	router := newTestRouter(t)
+	router.GET("/panic", func(*gin.Context) { panic("something broke") })
+	logged := captureLog(t)
+
+	recorder := serveRequest(router, http.MethodGet, "/panic", "", "Accept", MIME_PROBLEM_JSON)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("a panic responded %d, expected %d", recorder.Code, http.StatusInternalServerError)
+	}
+	if problem := decodeTestJSON[Problem](t, recorder); problem.Type != INTERNAL_ERROR_PROBLEM {
+		t.Errorf("a panic was a %s problem, expected %s", problem.Type, INTERNAL_ERROR_PROBLEM)
+	}
+
+	// only the app's own middleware logs, once for the panic and once for the request, and gin's logger adds nothing
+	lines := strings.Split(strings.TrimSuffix(logged.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `msg="panic recovered"`) || !strings.Contains(lines[0], `panic="something broke"`) || !strings.Contains(lines[1], `msg="request served"`) || !strings.Contains(lines[1], `status="500"`) {
+		t.Errorf("logged %q, expected the recovered panic and then the request", lines)
+	}
+}
+
+func TestMiddlewareCanBeTurnedOff(t *testing.T) {
+	resetState(t)
+	config.AccessLog, config.RecoverPanics = false, false
+	router := newTestRouter(t)
+	router.GET("/panic", func(*gin.Context) { panic("something broke") })
+	logged := captureLog(t)
+
+	serveRequest(router, http.MethodGet, "/receipts/missing", "")
+	if logged.Len() != 0 {
+		t.Errorf("logged %q with the access log off", logged)
+	}
+
+	// without recovery the panic is left to the server, as gin's own recovery is not installed either
+	defer func() {
+		if recovered := recover(); recovered != "something broke" {
+			t.Errorf("recovered %v from the router, expected the panic", recovered)
+		}
+	}()
+	serveRequest(router, http.MethodGet, "/panic", "")
+}
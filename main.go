@@ -1,17 +1,27 @@
 package main
 
 import (
-	"math"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
-	"regexp"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/xid"
+	"github.com/skip2/go-qrcode"
 )
 
-// host and port the app is running on
+// host the app is running on, and the port it listens on unless PORT says otherwise
 const HOST = "127.0.0.1"
 const PORT = ":8080"
 
@@ -23,131 +33,738 @@ const MULTIPLE_OF_0_POINT_25_BONUS = 25
 const ODD_DAY_BONUS = 6
 const BETWEEN_2PM_AND_4PM_BONUS = 10
 const ITEM_PRICE_MULTIPLIER = 0.2
+const DESC_LENGTH_DIVISOR = 3
+const LONG_RECEIPT_BONUS = 10
+const FIRST_PURCHASE_OF_DAY_BONUS = 5
+const HOLIDAY_BONUS = 15
+const BIG_SPENDER_POINTS_PER_UNIT = 1
+
+// default, smallest, and largest width in pixels of the qr code images
+const QR_CODE_SIZE = 256
+const MIN_QR_CODE_SIZE = 64
+const MAX_QR_CODE_SIZE = 1024
+
+// default for the most tags a receipt may carry, and the longest a tag may be in characters
+const MAX_TAGS = 10
+const MAX_TAG_LENGTH = 32
+
+// formats accepted for the purchase date, and for the purchase time with and without seconds
+const PURCHASE_DATE_FORMAT = "2006-01-02"
+
+var PURCHASE_TIME_FORMATS = []string{"15:04", "15:04:05"}
 
 // response for aborted endpoints, the description of the error
 type Description struct {
 	Description string `json:"description"`
 }
 
-// response of /receipts/process endpoint, the id of the new receipt
+// response of /receipts/process endpoint, the id of the new receipt, or of the existing one if it was already sent
 type Id struct {
-	Id string `json:"id"`
+	Id       string `json:"id"`
+	Existing bool   `json:"existing,omitempty"`
 }
 
-// response of /receipts/:id/points endpoint, the number of points awarded to the given receipt
+// response of /receipts/count endpoint, the number of receipts stored
+type Count struct {
+	Count int `json:"count"`
+}
+
+// response of /receipts/:id/points endpoint, the number of points awarded to the given receipt and the rules version that awarded them
 type Points struct {
-	Points int `json:"points"`
+	Points       int    `json:"points"`
+	RulesVersion string `json:"rulesVersion"`
+	// the most points the receipt could be worth were its total, day, and time of purchase at their best, only when asked for
+	MaxPoints *int `json:"maxPoints,omitempty"`
+	// when the points were computed, and whether they came from the cache, only when asked for
+	ComputedAt *time.Time `json:"computedAt,omitempty"`
+	Cached     *bool      `json:"cached,omitempty"`
+	// the points to the cent, as they would be if weighted rules were not rounded to whole points, only when asked for
+	RawPoints *float64 `json:"rawPoints,omitempty"`
+}
+
+// response of /receipts/:id/full endpoint, everything known about a receipt
+type FullReceipt struct {
+	Id        string    `json:"id"`
+	Receipt   Receipt   `json:"receipt"`
+	Points    int       `json:"points"`
+	Breakdown Breakdown `json:"breakdown"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
 // a specific item purchased
 type Item struct {
 	ShortDescription string `json:"shortDescription" binding:"required"`
 	Price            string `json:"price" binding:"required"`
+	// optional category the item is weighted by, one of the categoryMultipliers of the current rules
+	Category string `json:"category,omitempty"`
 }
 
 // a receipt
 type Receipt struct {
-	Retailer     string  `json:"retailer" binding:"required"`
-	PurchaseDate string  `json:"purchaseDate" binding:"required"`
-	PurchaseTime string  `json:"purchaseTime" binding:"required"`
-	Total        string  `json:"total" binding:"required"`
-	Items        []*Item `json:"items" binding:"required"`
+	Retailer     string `json:"retailer" binding:"required"`
+	PurchaseDate string `json:"purchaseDate" binding:"required"`
+	PurchaseTime string `json:"purchaseTime" binding:"required"`
+	Total        string `json:"total" binding:"required"`
+	Items        []Item `json:"items" binding:"required,dive"`
+	// optional ISO 4217 code of the currency the receipt is in, DEFAULT_CURRENCY if not given
+	Currency string `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	// optional labels the client files the receipt under, such as "groceries", at most MAX_TAGS of at most MAX_TAG_LENGTH characters
+	// kept lowercase and without duplicates
+	Tags []string `json:"tags,omitempty"`
+	// the instant the purchase date and time name together in SERVER_TZ, worked out when the receipt is validated
+	PurchasedAt time.Time `json:"-"`
 }
 
-// map of all receipts processed, a real implementation would use a database
-var receipts map[string]Receipt = make(map[string]Receipt)
-
 func main() {
-	router := gin.Default()
-	router.POST(`/receipts/process`, processReceipts)
+	var err error
+	config, err = loadConfig()
+	if err != nil {
+		log.Fatalf("could not load config: %v", err)
+	}
+	config, err = applyFlags(config, os.Args[1:])
+	if err != nil {
+		log.Fatalf("could not parse flags: %v", err)
+	}
+	rules, err = loadRules(config.RulesFile)
+	if err != nil {
+		log.Fatalf("could not load rules: %v", err)
+	}
+	rulesets, err = loadRulesets(rules, config.Rulesets)
+	if err != nil {
+		log.Fatalf("could not load rule-sets: %v", err)
+	}
+	maintenance.Store(config.Maintenance)
+	receipts = NewRetryingStore(receipts, config.StoreAttempts, config.StoreRetryBackoff)
+	if config.MaxConcurrentScoring > 0 {
+		scoringSlots = make(chan struct{}, config.MaxConcurrentScoring)
+	}
+
+	router, err := newRouter()
+	if err != nil {
+		log.Fatalf("could not build router: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", HOST+config.Port)
+	if err != nil {
+		log.Fatalf("could not listen: %v", err)
+	}
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	err = serve(router, listener, stop, config.ShutdownTimeout)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("could not serve: %v", err)
+	}
+}
+
+/*
+Builds the router serving every endpoint, with the middleware the settings ask for
+*/
+func newRouter() (*gin.Engine, error) {
+	// the app's own logging and recovery stand in for gin's defaults, so requests are not logged twice
+	router := gin.New()
+
+	// the client ip, as logged and as duplicate submissions are tracked by, is only taken from X-Forwarded-For behind a trusted proxy
+	err := router.SetTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("could not trust proxies: %w", err)
+	}
+	if config.AccessLog {
+		router.Use(accessLog)
+	}
+	if config.RecoverPanics {
+		router.Use(recoverPanics)
+	}
+	if len(config.CORSOrigins) > 0 {
+		router.Use(cors)
+	}
+	if config.Envelope {
+		router.Use(envelope)
+	}
+	if config.MaxConcurrentPerKey > 0 || len(config.KeyConcurrency) > 0 {
+		router.Use(limitPerKey)
+	}
+
+	// a path with a stray trailing slash, or in the wrong case, is redirected to its route rather than 404ing
+	// GETs are redirected with 301 and other methods with 307, so the method and body are kept
+	router.RedirectTrailingSlash = true
+	router.RedirectFixedPath = false
+
+	router.Use(countInFlight, recordLatency, timeout(config.RequestTimeout))
+	router.POST(`/receipts/process`, rejectDuringMaintenance, requireContentType(gin.MIMEJSON, gin.MIMEMultipartPOSTForm), decompressBody, processReceipts)
+	router.POST(`/receipts/batch`, rejectDuringMaintenance, requireContentType(gin.MIMEJSON), decompressBody, processBatch)
+	router.POST(`/receipts/validate`, requireContentType(gin.MIMEJSON), decompressBody, validateReceiptOnly)
+	router.POST(`/receipts/explain`, requireContentType(gin.MIMEJSON), decompressBody, explainReceipt)
+	router.GET(`/receipts`, listReceipts)
+	router.DELETE(`/receipts`, adminOnly, rejectDuringMaintenance, deleteReceipts)
+	router.GET(`/receipts/count`, getCount)
+	router.GET(`/receipts/invalid`, getInvalidReceipts)
+	router.GET(`/receipts/search`, searchReceipts)
+	router.GET(`/receipts/by-retailer/:retailer`, getReceiptIdsByRetailer)
+	router.GET(`/receipts/export`, exportReceipts)
+	router.POST(`/receipts/import`, adminOnly, rejectDuringMaintenance, requireContentType(gin.MIMEJSON, MIME_NDJSON, MIME_GOB), decompressBody, importReceipts)
+	router.GET(`/receipts/:id`, getReceipt)
+	router.HEAD(`/receipts/:id`, getReceipt)
 	router.GET(`/receipts/:id/points`, getPoints)
+	router.HEAD(`/receipts/:id/points`, getPoints)
+	router.GET(`/receipts/:id/points/history`, getPointsHistory)
+	router.GET(`/receipts/:id/breakdown`, getBreakdown)
+	router.GET(`/receipts/:id/full`, getFullReceipt)
+	router.GET(`/receipts/:id/qr`, getQRCode)
+	router.GET(`/receipts/:id/similar`, getSimilarReceipts)
+	router.GET(`/receipts/:id/percentile`, getPercentile)
+	router.GET(`/rules`, getRules)
+	router.GET(`/selftest`, getSelfTest)
+	router.GET(`/stats/latency`, getLatencyStats)
+	router.GET(`/stats/rules`, getRuleStats)
+	router.POST(`/stats/ruleset-diff`, adminOnly, requireContentType(gin.MIMEJSON), diffRuleset)
+	router.GET(`/metrics`, gin.WrapH(promhttp.Handler()))
+	router.GET(`/metrics/receipts`, getDailyReceipts)
 
-	router.Run(HOST + PORT)
+	admin := router.Group(`/admin`, adminOnly)
+	admin.GET(`/maintenance`, getMaintenance)
+	admin.PUT(`/maintenance`, setMaintenance)
+	admin.POST(`/rules/reload`, reloadRules)
+	router.GET(`/debug/config`, adminOnly, getConfig)
+	router.NoRoute(redirectToFixedPath(router))
+
+	return router, nil
 }
 
 /*
-Processes the given receipt and adds it to the receipts map
-responds with the unique id assigned to the receipt
+Processes the given receipt, as json or a multipart form, and adds it to the receipts store
+optionally takes the hash of a body already sent via the If-None-Match header, to get its receipt back rather than a duplicate
+responds with the unique id assigned to the receipt, and the hash of the body as its ETag
 */
 func processReceipts(context *gin.Context) {
-	var receipt Receipt
+	// a client that sends the SHA-256 hash of a body it already sent, as If-None-Match, is given back the receipt it created
+	hash, err := hashBody(context)
+	if err != nil {
+		abortWithError(context, http.StatusBadRequest, MALFORMED_BODY_PROBLEM, "The body could not be read")
+		return
+	}
+	if id, found := processedHashes.match(context.GetHeader("If-None-Match")); found {
+		// return the existing id as a json object with a 200 status
+		context.Header("ETag", `"`+hash+`"`)
+		context.JSON(http.StatusOK, Id{Id: id, Existing: true})
+		return
+	}
 
-	// attempt to create a Receipt struct from the given JSON object, abort on failure with 400 error
-	err := context.ShouldBindJSON(&receipt)
+	// attempt to create a valid Receipt struct from the given JSON object, abort on failure with 400 error
+	receipt, err := bindReceipt(context)
 	if err != nil {
-		context.AbortWithStatusJSON(http.StatusBadRequest, Description{Description: "The receipt is invalid"})
+		abortWithError(context, http.StatusBadRequest, INVALID_RECEIPT_PROBLEM, "The receipt is invalid: "+err.Error())
 		return
 	}
 
-	// use xid to create a random, unique id for the receipt and add it to the receipts map
+	// the same body from the same client within the DUPLICATE_WINDOW is taken for a double-click, abort with 409 error
+	if config.DuplicateWindow > 0 {
+		if !submissions.claim(context.ClientIP(), hash, config.DuplicateWindow) {
+			abortWithError(context, http.StatusConflict, DUPLICATE_SUBMISSION_PROBLEM, "The same receipt was just submitted, it has not been stored again")
+			return
+		}
+	}
+
+	// use xid to create a random, unique id for the receipt and add it to the receipts store
 	id := xid.New().String()
-	receipts[id] = receipt
+	err = receipts.Save(context.Request.Context(), id, receipt)
+	if err != nil {
+		// a failed submission does not count, so it can be retried straight away
+		submissions.release(context.ClientIP(), hash)
+		abortWithStoreError(context, err)
+		return
+	}
+	processedHashes.put(hash, id)
+
+	// count the points the receipt is worth towards the running per-rule totals
+	// failing to gather the facts about it only costs the totals the rules that compare receipts
+	facts, _ := scoringFacts(context.Request.Context(), receipts, StoredReceipt{Id: id, Receipt: receipt})
+	awardedByRule.add(id, CalculateBreakdown(receipt, currentRules(), facts))
 
 	// return the id as a json object with a 200 status
+	context.Header("ETag", `"`+hash+`"`)
 	context.JSON(http.StatusOK, Id{Id: id})
 }
 
 /*
-Calculates the number of points a given receipt is worth
+Validates the given receipt without scoring or storing it
+responds with whether the receipt is valid, and every problem found with it if not
+*/
+func validateReceiptOnly(context *gin.Context) {
+	_, err := bindReceipt(context)
+
+	// return the validity as a json object with a 200 status, whether or not the receipt is valid
+	var invalid InvalidReceiptError
+	if errors.As(err, &invalid) {
+		context.JSON(http.StatusOK, Validity{Valid: false, Errors: invalid.Problems})
+		return
+	}
+	context.JSON(http.StatusOK, Validity{Valid: true})
+}
+
+/*
+Checks every stored receipt against the current validations, to find those a tightened VALIDATION_MODE would now reject
+nothing is changed, the receipts stay stored and keep their scores
+responds with the id of every receipt that would now fail, and every problem found with it, in the order they were stored
+*/
+func getInvalidReceipts(context *gin.Context) {
+	stored, err := receipts.List(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	// the same checks are made as when a receipt is first processed, less the schema, which applies to the raw body
+	invalid := []InvalidReceipt{}
+	for _, receipt := range stored {
+		err := binding.Validator.ValidateStruct(receipt.Receipt)
+		if err != nil {
+			invalid = append(invalid, InvalidReceipt{Id: receipt.Id, Errors: bindingError(err).Problems})
+			continue
+		}
+		err = validateReceipt(normalizeReceipt(receipt.Receipt))
+		if err != nil {
+			invalid = append(invalid, InvalidReceipt{Id: receipt.Id, Errors: problemsOf(err)})
+		}
+	}
+
+	// return the invalid receipts as a json array with a 200 status, empty if every receipt is still valid
+	context.JSON(http.StatusOK, invalid)
+}
+
+/*
+Lists the stored receipts in the order they were stored
+takes the page via the offset and limit query params, optionally a tag the receipts must carry via the tag query param,
+and optionally a comma separated list of the fields to respond with via the fields query param
+responds with the page of receipts, with every field unless only some are asked for
+*/
+func listReceipts(context *gin.Context) {
+	offset, limit, ok := pagination(context)
+	if !ok {
+		return
+	}
+	fields, ok := projection(context)
+	if !ok {
+		return
+	}
+
+	stored, err := receipts.List(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	// keep every receipt carrying the tag, ignoring case, when one is given
+	tag, filtered := context.GetQuery("tag")
+	if filtered {
+		matches := []StoredReceipt{}
+		for _, receipt := range stored {
+			for _, receiptTag := range receipt.Tags {
+				if receiptTag == strings.ToLower(strings.TrimSpace(tag)) {
+					matches = append(matches, receipt)
+					break
+				}
+			}
+		}
+		stored = matches
+	}
+
+	// return the page of receipts, or of just the fields asked for, as a json object with a 200 status
+	page := paginate(stored, offset, limit)
+	if fields != nil {
+		context.JSON(http.StatusOK, ReceiptPage[map[string]any]{Receipts: project(page.Receipts, fields), Total: page.Total, Offset: page.Offset, Limit: page.Limit})
+		return
+	}
+	context.JSON(http.StatusOK, page)
+}
+
+/*
+Counts the receipts currently stored
+responds with the number of receipts
+*/
+func getCount(context *gin.Context) {
+	count, err := receipts.Count(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	// return the count as a json object with a 200 status
+	context.JSON(http.StatusOK, Count{Count: count})
+}
+
+/*
+Searches the stored receipts for items whose short description contains the given term, ignoring case
+takes the term via the q query param, and the page via the offset and limit query params
+responds with the page of receipts holding at least one matching item
+*/
+func searchReceipts(context *gin.Context) {
+	// the term is required, abort without one with 400 error
+	term := strings.ToLower(strings.TrimSpace(context.Query("q")))
+	if term == "" {
+		abortWithError(context, http.StatusBadRequest, MISSING_QUERY_PROBLEM, "The q query param is required")
+		return
+	}
+	offset, limit, ok := pagination(context)
+	if !ok {
+		return
+	}
+
+	stored, err := receipts.List(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	// keep every receipt with at least one matching item
+	matches := []StoredReceipt{}
+	for _, receipt := range stored {
+		for _, item := range receipt.Items {
+			if strings.Contains(strings.ToLower(item.ShortDescription), term) {
+				matches = append(matches, receipt)
+				break
+			}
+		}
+	}
+
+	// return the page of matches as a json object with a 200 status
+	context.JSON(http.StatusOK, paginate(matches, offset, limit))
+}
+
+/*
+Lists the ids of the stored receipts from a given retailer, ignoring case and anything but letters and digits
+takes the retailer via url param, and the page via the offset and limit query params
+responds with the page of ids, in the order the receipts were stored
+*/
+func getReceiptIdsByRetailer(context *gin.Context) {
+	retailer := normalizeRetailer(context.Param("retailer"))
+	offset, limit, ok := pagination(context)
+	if !ok {
+		return
+	}
+
+	stored, err := receipts.List(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	// keep the id of every receipt from the retailer
+	ids := []string{}
+	for _, receipt := range stored {
+		if normalizeRetailer(receipt.Retailer) == retailer {
+			ids = append(ids, receipt.Id)
+		}
+	}
+
+	// return the page of ids as a json object with a 200 status
+	context.JSON(http.StatusOK, paginate(ids, offset, limit))
+}
+
+/*
+Finds a given receipt
 takes the id of the receipt via url param
-responds with the number of points the receipt is worth
+responds with the receipt, its id, and when it was stored
+*/
+func getReceipt(context *gin.Context) {
+	// the id comes from the url
+	id := context.Param("id")
+
+	// attempt to find the receipt from the receipts store, abort on failure with 404 error
+	stored, found, err := receipts.Get(context.Request.Context(), id)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	if !found {
+		abortWithError(context, http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM, "No receipt found for that id")
+		return
+	}
+	setCacheHeaders(context, stored)
+
+	// return the receipt as a json object with a 200 status
+	context.JSON(http.StatusOK, stored)
+}
+
+/*
+Calculates the number of points a given receipt is worth
+takes the id of the receipt via url param, optionally the version of the rules to score it against via the ruleset query param,
+and optionally a total to score it as if it had instead via the total query param,
+and optionally whether to include the most points it could be worth via the includeMax query param,
+and optionally whether to include when the points were computed, and if they were cached, via the cacheInfo query param,
+and optionally whether to include the points as a decimal, before weighted rules round them, via the decimal query param
+responds with the number of points the receipt is worth, tagged with an ETag that changes with the rules version
 */
 func getPoints(context *gin.Context) {
 	// the id comes from the url
 	id := context.Param("id")
 
-	// attempt to find the receipt from the receipts map, abort on failure with 404 error
-	receipt, found := receipts[id]
+	// attempt to find the receipt from the receipts store, abort on failure with 404 error
+	stored, found, err := receipts.Get(context.Request.Context(), id)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
 	if !found {
-		context.AbortWithStatusJSON(http.StatusBadRequest, Description{Description: "No receipt found for that id"})
-		return
-	}
-
-	points := 0
-
-	/*
-		Add the points pers
-			One point for every alphanumeric character in the retailer name.
-			5 points for every two items on the receipt.
-	*/
-	points += len(regexp.MustCompile(`[^a-zA-Z0-9]+`).ReplaceAllString(receipt.Retailer, "")) * VALUE_PER_ALPHANUMERIC_CHAR
-	points += (len(receipt.Items) / 2) * VALUE_PER_TWO_ITEMS
-
-	/*
-		Add the points bonuses
-			50 points if the total is a round dollar amount with no cents.
-			25 points if the total is a multiple of `0.25`.
-			6 points if the day in the purchase date is odd.
-			10 points if the time of purchase is after 2:00pm and before 4:00pm.
-	*/
-	total, err := strconv.ParseFloat(receipt.Total, 64)
-	if err == nil && math.Mod(total, 1) == 0 {
-		points += ROUND_DOLLAR_AMOUNT_BONUS
-	}
-	if err == nil && math.Mod(total, 0.25) == 0 {
-		points += MULTIPLE_OF_0_POINT_25_BONUS
-	}
-	day, err := strconv.Atoi(strings.Split(receipt.PurchaseDate, "-")[2])
-	if err == nil && day%2 == 1 {
-		points += ODD_DAY_BONUS
-	}
-	hour, err := strconv.Atoi(strings.Split(receipt.PurchaseTime, ":")[0])
-	if err == nil && hour >= 14 && hour < 16 {
-		points += BETWEEN_2PM_AND_4PM_BONUS
-	}
-
-	/*
-		Add the value of each item
-	*/
-	for i := 0; i < len(receipt.Items); i++ {
-		if len(strings.TrimSpace(receipt.Items[i].ShortDescription))%3 == 0 {
-			price, err := strconv.ParseFloat(receipt.Items[i].Price, 64)
-			if err == nil {
-				points += int(math.Ceil(price * ITEM_PRICE_MULTIPLIER))
-			}
+		abortWithError(context, http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM, "No receipt found for that id")
+		return
+	}
+
+	// the current rules are used unless another loaded rule-set is asked for, abort on an unknown one with 400 error
+	ruleset := currentRules()
+	if version, given := context.GetQuery("ruleset"); given {
+		ruleset, found = findRuleset(version)
+		if !found {
+			abortWithError(context, http.StatusBadRequest, UNKNOWN_RULESET_PROBLEM, "No rule-set found for that version")
+			return
+		}
+	}
+
+	// abort on an includeMax, cacheInfo, or decimal that is not a boolean with 400 error
+	includeMax, cacheInfo, decimal := false, false, false
+	if value, given := context.GetQuery("includeMax"); given {
+		includeMax, err = strconv.ParseBool(value)
+		if err != nil {
+			abortWithError(context, http.StatusBadRequest, INVALID_REQUEST_PROBLEM, "The includeMax query param must be true or false")
+			return
+		}
+	}
+	if value, given := context.GetQuery("cacheInfo"); given {
+		cacheInfo, err = strconv.ParseBool(value)
+		if err != nil {
+			abortWithError(context, http.StatusBadRequest, INVALID_REQUEST_PROBLEM, "The cacheInfo query param must be true or false")
+			return
+		}
+	}
+	if value, given := context.GetQuery("decimal"); given {
+		decimal, err = strconv.ParseBool(value)
+		if err != nil {
+			abortWithError(context, http.StatusBadRequest, INVALID_REQUEST_PROBLEM, "The decimal query param must be true or false")
+			return
+		}
+	}
+
+	// a what-if total is scored afresh on a copy of the receipt, which is neither cached nor stored
+	if total, given := context.GetQuery("total"); given {
+		whatIf := stored
+		whatIf.Total = normalizeMoney(total)
+		if _, err := parseCents(whatIf.Total, currencyOf(whatIf.Receipt)); err != nil {
+			abortWithError(context, http.StatusBadRequest, INVALID_REQUEST_PROBLEM, "The total query param "+err.Error())
+			return
+		}
+		facts, err := scoringFacts(context.Request.Context(), receipts, whatIf)
+		if err != nil {
+			abortWithStoreError(context, err)
+			return
 		}
+		release, err := acquireScoringSlot(context.Request.Context())
+		if err != nil {
+			abortWithStoreError(context, err)
+			return
+		}
+		defer release()
+
+		// return the what-if points as a json object with a 200 status
+		breakdown := CalculateBreakdown(whatIf.Receipt, ruleset, facts)
+		points := Points{Points: breakdown.Points, RulesVersion: ruleset.Version}
+		if decimal {
+			rawPoints := roundToHundredths(breakdown.RawPoints)
+			points.RawPoints = &rawPoints
+		}
+		if cacheInfo {
+			computedAt, cached := time.Now(), false
+			points.ComputedAt, points.Cached = &computedAt, &cached
+		}
+		if includeMax {
+			maxPoints := CalculateMaxPoints(whatIf.Receipt, ruleset, facts)
+			points.MaxPoints = &maxPoints
+		}
+		context.JSON(http.StatusOK, points)
+		return
+	}
+
+	score, err := scoreReceipt(context.Request.Context(), stored, ruleset)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	traceBreakdown(id, ruleset, score.Breakdown)
+	setScoreCacheHeaders(context)
+
+	// a client that already has these points, under this version of the rules, is told so with a 304 status
+	etag := pointsETag(id, ruleset.Version, score.Breakdown.Points)
+	context.Header("ETag", etag)
+	if noneMatch(context.GetHeader("If-None-Match"), etag) {
+		context.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	// the most points the receipt could be worth are worked out afresh, since they are only asked for now and then
+	points := Points{Points: score.Breakdown.Points, RulesVersion: score.Breakdown.RulesVersion}
+	if decimal {
+		rawPoints := roundToHundredths(score.Breakdown.RawPoints)
+		points.RawPoints = &rawPoints
+	}
+	if cacheInfo {
+		points.ComputedAt, points.Cached = &score.ComputedAt, &score.Cached
+	}
+	if includeMax {
+		facts, err := scoringFacts(context.Request.Context(), receipts, stored)
+		if err != nil {
+			abortWithStoreError(context, err)
+			return
+		}
+		maxPoints := CalculateMaxPoints(stored.Receipt, ruleset, facts)
+		points.MaxPoints = &maxPoints
 	}
 
 	// return the points as a json object with a 200 status
-	context.JSON(http.StatusOK, Points{Points: points})
+	context.JSON(http.StatusOK, points)
+}
+
+/*
+Lists every time the points of a given receipt were computed, which happens once per rules version it is scored against
+takes the id of the receipt via url param
+responds with the points, the rules version, and when they were computed, oldest first
+*/
+func getPointsHistory(context *gin.Context) {
+	// the id comes from the url
+	id := context.Param("id")
+
+	// attempt to find the receipt from the receipts store, abort on failure with 404 error
+	_, found, err := receipts.Get(context.Request.Context(), id)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	if !found {
+		abortWithError(context, http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM, "No receipt found for that id")
+		return
+	}
+
+	// return the history as a json array with a 200 status
+	context.JSON(http.StatusOK, scores.historyOf(id))
+}
+
+/*
+Breaks down the number of points a given receipt is worth by the rules that awarded them
+takes the id of the receipt via url param
+responds with the number of points the receipt is worth and the contribution of each rule
+*/
+func getBreakdown(context *gin.Context) {
+	// the id comes from the url
+	id := context.Param("id")
+
+	// attempt to find the receipt from the receipts store, abort on failure with 404 error
+	stored, found, err := receipts.Get(context.Request.Context(), id)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	if !found {
+		abortWithError(context, http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM, "No receipt found for that id")
+		return
+	}
+
+	score, err := scoreReceipt(context.Request.Context(), stored, currentRules())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	setScoreCacheHeaders(context)
+
+	// return the breakdown as a json object with a 200 status
+	context.JSON(http.StatusOK, score.Breakdown)
+}
+
+/*
+Gathers everything known about a given receipt
+takes the id of the receipt via url param
+responds with the receipt, the number of points it is worth and their breakdown, and when it was stored
+*/
+func getFullReceipt(context *gin.Context) {
+	// the id comes from the url
+	id := context.Param("id")
+
+	// attempt to find the receipt from the receipts store, abort on failure with 404 error
+	stored, found, err := receipts.Get(context.Request.Context(), id)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	if !found {
+		abortWithError(context, http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM, "No receipt found for that id")
+		return
+	}
+	score, err := scoreReceipt(context.Request.Context(), stored, currentRules())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	setScoreCacheHeaders(context)
+
+	// return everything as a json object with a 200 status
+	context.JSON(http.StatusOK, FullReceipt{
+		Id:        id,
+		Receipt:   stored.Receipt,
+		Points:    score.Breakdown.Points,
+		Breakdown: score.Breakdown,
+		CreatedAt: stored.CreatedAt,
+	})
+}
+
+/*
+Generates a qr code linking to the points of a given receipt
+takes the id of the receipt via url param, and optionally the width of the image in pixels via the size query param
+responds with the qr code as a png image
+*/
+func getQRCode(context *gin.Context) {
+	// the id comes from the url
+	id := context.Param("id")
+
+	// the size is optional, but must be a whole number of pixels within bounds, abort otherwise with 400 error
+	size, err := strconv.Atoi(context.DefaultQuery("size", strconv.Itoa(QR_CODE_SIZE)))
+	if err != nil || size < MIN_QR_CODE_SIZE || size > MAX_QR_CODE_SIZE {
+		abortWithError(context, http.StatusBadRequest, INVALID_QR_CODE_SIZE_PROBLEM, fmt.Sprintf("The size must be between %d and %d", MIN_QR_CODE_SIZE, MAX_QR_CODE_SIZE))
+		return
+	}
+
+	// attempt to find the receipt from the receipts store, abort on failure with 404 error
+	_, found, err := receipts.Get(context.Request.Context(), id)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	if !found {
+		abortWithError(context, http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM, "No receipt found for that id")
+		return
+	}
+
+	// the qr code encodes the absolute url of the points endpoint, as reached by the client
+	scheme := "http"
+	if context.Request.TLS != nil {
+		scheme = "https"
+	}
+	url := scheme + "://" + context.Request.Host + "/receipts/" + id + "/points"
+	image, err := qrcode.Encode(url, qrcode.Medium, size)
+	if err != nil {
+		context.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// return the png image with a 200 status
+	context.Data(http.StatusOK, "image/png", image)
+}
+
+// receipts never change once stored, so a receipt may be cached for the configured max-age
+func setCacheHeaders(context *gin.Context, stored StoredReceipt) {
+	context.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(config.CacheMaxAge.Seconds())))
+	context.Header("Last-Modified", stored.CreatedAt.UTC().Format(http.TimeFormat))
+}
+
+// a receipt's points change whenever the rules are reloaded, so responses holding them must be revalidated before they are reused
+func setScoreCacheHeaders(context *gin.Context) {
+	context.Header("Cache-Control", "no-cache")
 }
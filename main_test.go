@@ -0,0 +1,611 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// the example receipts from the challenge, and the points they are documented to be worth
+const TARGET_RECEIPT = `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"},{"shortDescription":"Emils Cheese Pizza","price":"12.25"},{"shortDescription":"Knorr Creamy Chicken","price":"1.26"},{"shortDescription":"Doritos Nacho Cheese","price":"3.35"},{"shortDescription":"   Klarbrunn 12-PK 12 FL OZ  ","price":"12.00"}],"total":"35.35"}`
+const TARGET_POINTS = 28
+const MM_RECEIPT = `{"retailer":"M&M Corner Market","purchaseDate":"2022-03-20","purchaseTime":"14:33","items":[{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"}],"total":"9.00"}`
+const MM_POINTS = 109
+
+// the settings every test starts from, as they were before any test changed them
+var defaultConfig Config
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	config.AccessLog = false
+	defaultConfig = config
+	os.Exit(m.Run())
+}
+
+// puts every setting, the rules, the store, and everything remembered about past requests back as they were at startup
+func resetState(t *testing.T) {
+	t.Helper()
+	config = defaultConfig
+	replaceTestRules(defaultRules())
+	receipts = NewMemoryStore()
+	receiptsStored.Set(0)
+	scores = &scoreCache{scores: make(map[string]map[string]cachedScore), history: make(map[string][]HistoricalScore)}
+	awardedByRule = &ruleTotals{points: make(map[string]int), counted: make(map[string][]Contribution)}
+	processedHashes = &contentHashes{ids: make(map[string]string)}
+	submissions = &recentSubmissions{submitted: make(map[string]map[string]time.Time)}
+	keyInFlight = &keySemaphores{inFlight: make(map[string]int64)}
+	latencies = &latencyRecorder{reservoirs: make(map[string]*latencyReservoir)}
+	maintenance.Store(false)
+	scoringSlots = nil
+}
+
+// makes the given rules the current ones, and the only rule-set
+func replaceTestRules(current Rules) {
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	rules = current
+	rulesets = map[string]Rules{current.Version: current}
+}
+
+// builds the router as main would, with the settings as they are
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	router, err := newRouter()
+	if err != nil {
+		t.Fatalf("could not build router: %v", err)
+	}
+	return router
+}
+
+// serves the request through the router, with the given headers as name value pairs
+func serveRequest(router http.Handler, method string, path string, body string, headers ...string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if body != "" {
+		request.Header.Set("Content-Type", gin.MIMEJSON)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// processes the receipt through the router, failing the test unless it is stored, and returns its id
+func processTestReceipt(t *testing.T, router http.Handler, receipt string) string {
+	t.Helper()
+	recorder := serveRequest(router, http.MethodPost, "/receipts/process", receipt)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("processing %s responded %d: %s", receipt, recorder.Code, recorder.Body)
+	}
+	return decodeTestJSON[Id](t, recorder).Id
+}
+
+// the points of the stored receipt, failing the test unless they are found
+func testPoints(t *testing.T, router http.Handler, id string) int {
+	t.Helper()
+	recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("points of %s responded %d: %s", id, recorder.Code, recorder.Body)
+	}
+	return decodeTestJSON[Points](t, recorder).Points
+}
+
+// decodes the json body of the response, failing the test if it is not what was expected
+func decodeTestJSON[T any](t *testing.T, recorder *httptest.ResponseRecorder) T {
+	t.Helper()
+	var decoded T
+	if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("could not decode %q: %v", recorder.Body, err)
+	}
+	return decoded
+}
+
+// decodes the receipt from json and tidies it up as it would be once stored, failing the test if it is malformed
+func testReceipt(t *testing.T, receipt string) Receipt {
+	t.Helper()
+	var decoded Receipt
+	if err := json.Unmarshal([]byte(receipt), &decoded); err != nil {
+		t.Fatalf("could not decode %q: %v", receipt, err)
+	}
+	return normalizeReceipt(decoded)
+}
+
+// the receipt as json, failing the test if it cannot be encoded
+func testReceiptJSON(t *testing.T, receipt Receipt) string {
+	t.Helper()
+	encoded, err := json.Marshal(receipt)
+	if err != nil {
+		t.Fatalf("could not encode %+v: %v", receipt, err)
+	}
+	return string(encoded)
+}
+
+func TestMultipleItemsScoreThroughTheEndpoints(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	for _, test := range []struct {
+		receipt string
+		points  int
+	}{
+		{TARGET_RECEIPT, TARGET_POINTS},
+		{MM_RECEIPT, MM_POINTS},
+	} {
+		id := processTestReceipt(t, router, test.receipt)
+		if points := testPoints(t, router, id); points != test.points {
+			t.Errorf("receipt %s scored %d, expected %d", id, points, test.points)
+		}
+	}
+}
+
+func TestItemsAreStoredAsValues(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	// changing the stored receipt's items changes nothing shared with another receipt
+	first := processTestReceipt(t, router, MM_RECEIPT)
+	second := processTestReceipt(t, router, MM_RECEIPT)
+	stored, _, _ := receipts.Get(context.Background(), first)
+	stored.Items[0].ShortDescription = "Gatorade Zero"
+
+	if other, _, _ := receipts.Get(context.Background(), second); other.Items[0].ShortDescription != "Gatorade" {
+		t.Errorf("changing one receipt's item changed another's to %q", other.Items[0].ShortDescription)
+	}
+	if points := testPoints(t, router, second); points != MM_POINTS {
+		t.Errorf("changing one receipt's item changed another's points to %d rather than %d", points, MM_POINTS)
+	}
+}
+
+func TestEveryItemIsValidated(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	// an item missing its price is caught even behind a valid one
+	receipt := `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Pepsi","price":"1.25"},{"shortDescription":"Dasani"}],"total":"1.25"}`
+	if recorder := serveRequest(router, http.MethodPost, "/receipts/process", receipt); recorder.Code != http.StatusBadRequest {
+		t.Errorf("a receipt with an item missing its price responded %d, expected 400", recorder.Code)
+	}
+}
+
+// the value /metrics reports for the named gauge, failing the test if it is missing
+func testGauge(t *testing.T, router http.Handler, name string) string {
+	t.Helper()
+	recorder := serveRequest(router, http.MethodGet, "/metrics", "")
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if strings.HasPrefix(line, name+" ") {
+			return strings.TrimPrefix(line, name+" ")
+		}
+	}
+	t.Fatalf("no %s gauge in %s", name, recorder.Body)
+	return ""
+}
+
+func TestCountReflectsInsertsAndDeletes(t *testing.T) {
+	resetState(t)
+	config.AdminToken = "secret"
+	router := newTestRouter(t)
+
+	count := func() int {
+		t.Helper()
+		recorder := serveRequest(router, http.MethodGet, "/receipts/count", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("count responded %d: %s", recorder.Code, recorder.Body)
+		}
+		return decodeTestJSON[Count](t, recorder).Count
+	}
+
+	if got := count(); got != 0 {
+		t.Fatalf("an empty store counted %d receipts", got)
+	}
+	processTestReceipt(t, router, TARGET_RECEIPT)
+	processTestReceipt(t, router, MM_RECEIPT)
+	if got := count(); got != 2 {
+		t.Errorf("counted %d receipts after two inserts", got)
+	}
+	if gauge := testGauge(t, router, "receipts_stored"); gauge != "2" {
+		t.Errorf("the gauge read %s after two inserts", gauge)
+	}
+
+	recorder := serveRequest(router, http.MethodDelete, "/receipts?retailer=Target", "", "Authorization", "Bearer secret")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("delete responded %d: %s", recorder.Code, recorder.Body)
+	}
+	if got := count(); got != 1 {
+		t.Errorf("counted %d receipts after deleting one of two", got)
+	}
+	if gauge := testGauge(t, router, "receipts_stored"); gauge != "1" {
+		t.Errorf("the gauge read %s after deleting one of two", gauge)
+	}
+}
+
+func TestQRCodeEncodesPointsURL(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/qr?size=128", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("responded %d: %s", recorder.Code, recorder.Body)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Errorf("responded as %q, expected image/png", contentType)
+	}
+	image, err := png.Decode(bytes.NewReader(recorder.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("could not decode the png: %v", err)
+	}
+	if bounds := image.Bounds(); bounds.Dx() != 128 || bounds.Dy() != 128 {
+		t.Errorf("the image is %dx%d, expected 128x128", bounds.Dx(), bounds.Dy())
+	}
+
+	// qr codes are encoded deterministically, so the image encodes the url if it is the same image the url encodes to
+	expected, err := qrcode.Encode("http://example.com/receipts/"+id+"/points", qrcode.Medium, 128)
+	if err != nil {
+		t.Fatalf("could not encode the expected url: %v", err)
+	}
+	if !bytes.Equal(recorder.Body.Bytes(), expected) {
+		t.Errorf("the image does not encode the points url of %s", id)
+	}
+}
+
+func TestQRCodeSizeBounds(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	for _, size := range []string{"63", "1025", "big"} {
+		if recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/qr?size="+size, ""); recorder.Code != http.StatusBadRequest {
+			t.Errorf("a size of %s responded %d, expected %d", size, recorder.Code, http.StatusBadRequest)
+		}
+	}
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/missing/qr", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("a missing receipt responded %d, expected %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+func TestSearchMatchesItemDescriptions(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	target := processTestReceipt(t, router, TARGET_RECEIPT)
+	mm := processTestReceipt(t, router, MM_RECEIPT)
+
+	search := func(query string) ReceiptPage[StoredReceipt] {
+		t.Helper()
+		recorder := serveRequest(router, http.MethodGet, "/receipts/search?"+query, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("searching %s responded %d: %s", query, recorder.Code, recorder.Body)
+		}
+		return decodeTestJSON[ReceiptPage[StoredReceipt]](t, recorder)
+	}
+
+	for _, test := range []struct {
+		query string
+		ids   []string
+	}{
+		{"q=cheese", []string{target}},
+		{"q=GATORADE", []string{mm}},
+		{"q=+pizza+", []string{target}},
+		{"q=a", []string{target, mm}},
+		{"q=soda", []string{}},
+	} {
+		page := search(test.query)
+		ids := []string{}
+		for _, receipt := range page.Receipts {
+			ids = append(ids, receipt.Id)
+		}
+		if strings.Join(ids, ",") != strings.Join(test.ids, ",") || page.Total != len(test.ids) {
+			t.Errorf("searching %s found %v of %d, expected %v", test.query, ids, page.Total, test.ids)
+		}
+	}
+
+	// the matches are paged like any other list of receipts
+	page := search("q=a&offset=1&limit=1")
+	if len(page.Receipts) != 1 || page.Receipts[0].Id != mm || page.Total != 2 {
+		t.Errorf("the second page of one found %+v, expected only %s of 2", page, mm)
+	}
+}
+
+func TestSearchRequiresTerm(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	for _, query := range []string{"", "?q=", "?q=+"} {
+		if recorder := serveRequest(router, http.MethodGet, "/receipts/search"+query, ""); recorder.Code != http.StatusBadRequest {
+			t.Errorf("searching %q responded %d, expected %d", query, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestFullReceiptMatchesTheIndividualEndpoints(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/full", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("full responded %d: %s", recorder.Code, recorder.Body)
+	}
+	for _, field := range []string{`"id"`, `"receipt"`, `"points"`, `"breakdown"`, `"createdAt"`} {
+		if !strings.Contains(recorder.Body.String(), field) {
+			t.Errorf("the full receipt %s has no %s", recorder.Body, field)
+		}
+	}
+	full := decodeTestJSON[FullReceipt](t, recorder)
+
+	stored := decodeTestJSON[StoredReceipt](t, serveRequest(router, http.MethodGet, "/receipts/"+id, ""))
+	breakdown := decodeTestJSON[Breakdown](t, serveRequest(router, http.MethodGet, "/receipts/"+id+"/breakdown", ""))
+	if full.Id != id || !reflect.DeepEqual(full.Receipt, stored.Receipt) || !full.CreatedAt.Equal(stored.CreatedAt) {
+		t.Errorf("the full receipt %+v does not match the stored one %+v", full, stored)
+	}
+	if full.Points != testPoints(t, router, id) || full.Points != TARGET_POINTS {
+		t.Errorf("the full receipt has %d points, expected %d", full.Points, TARGET_POINTS)
+	}
+	if !reflect.DeepEqual(full.Breakdown, breakdown) {
+		t.Errorf("the full receipt has the breakdown %+v, expected %+v", full.Breakdown, breakdown)
+	}
+
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/missing/full", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("a missing receipt responded %d, expected %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+// serves the request through the router, following a single redirect the way an http client would
+func serveFollowingRedirect(t *testing.T, router http.Handler, method string, path string, body string) (redirect *httptest.ResponseRecorder, followed *httptest.ResponseRecorder) {
+	t.Helper()
+	redirect = serveRequest(router, method, path, body)
+	location := redirect.Header().Get("Location")
+	if location == "" {
+		t.Fatalf("%s %s was not redirected, it responded %d: %s", method, path, redirect.Code, redirect.Body)
+	}
+	// a permanent redirect of a GET becomes a GET, the temporary one of anything else keeps its method and body
+	if redirect.Code != http.StatusTemporaryRedirect && redirect.Code != http.StatusPermanentRedirect {
+		method, body = http.MethodGet, ""
+	}
+	return redirect, serveRequest(router, method, location, body)
+}
+
+func TestTrailingSlashesAndCaseAreRedirected(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	if recorder := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT); recorder.Code != http.StatusOK {
+		t.Errorf("posting without a trailing slash responded %d", recorder.Code)
+	}
+	redirect, followed := serveFollowingRedirect(t, router, http.MethodPost, "/receipts/process/", TARGET_RECEIPT)
+	if redirect.Code != http.StatusTemporaryRedirect || redirect.Header().Get("Location") != "/receipts/process" {
+		t.Errorf("posting with a trailing slash redirected %d to %q, expected %d to /receipts/process", redirect.Code, redirect.Header().Get("Location"), http.StatusTemporaryRedirect)
+	}
+	if followed.Code != http.StatusOK {
+		t.Fatalf("following the redirect responded %d: %s", followed.Code, followed.Body)
+	}
+	id := decodeTestJSON[Id](t, followed).Id
+
+	redirect, followed = serveFollowingRedirect(t, router, http.MethodPost, "/Receipts/Process/", MM_RECEIPT)
+	if redirect.Code != http.StatusTemporaryRedirect || followed.Code != http.StatusOK {
+		t.Errorf("posting in the wrong case redirected %d and then responded %d: %s", redirect.Code, followed.Code, followed.Body)
+	}
+
+	for _, path := range []string{"/receipts/" + id + "/points/", "/RECEIPTS/" + id + "/Points", "/Receipts/" + id + "/Points/"} {
+		redirect, followed := serveFollowingRedirect(t, router, http.MethodGet, path, "")
+		if redirect.Code != http.StatusMovedPermanently {
+			t.Errorf("getting %s redirected %d, expected %d", path, redirect.Code, http.StatusMovedPermanently)
+		}
+		if followed.Code != http.StatusOK || decodeTestJSON[Points](t, followed).Points != TARGET_POINTS {
+			t.Errorf("following the redirect of %s responded %d: %s", path, followed.Code, followed.Body)
+		}
+	}
+}
+
+func TestWrongCaseRedirectsToTheMostSpecificRoute(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	// the count is a route of its own, not a receipt with the id count
+	redirect, followed := serveFollowingRedirect(t, router, http.MethodGet, "/Receipts/Count?x=1", "")
+	if location := redirect.Header().Get("Location"); location != "/receipts/count?x=1" {
+		t.Errorf("redirected to %q, expected /receipts/count?x=1", location)
+	}
+	if followed.Code != http.StatusOK || decodeTestJSON[Count](t, followed).Count != 0 {
+		t.Errorf("following the redirect responded %d: %s", followed.Code, followed.Body)
+	}
+
+	if recorder := serveRequest(router, http.MethodGet, "/nothing/here", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("a path matching no route responded %d, expected %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+// the receipt with the given tags, as json, with the tags left as they are given
+func withTestTags(t *testing.T, receipt string, tags ...string) string {
+	t.Helper()
+	var tagged map[string]any
+	if err := json.Unmarshal([]byte(receipt), &tagged); err != nil {
+		t.Fatalf("could not decode %q: %v", receipt, err)
+	}
+	tagged["tags"] = tags
+	encoded, err := json.Marshal(tagged)
+	if err != nil {
+		t.Fatalf("could not encode %+v: %v", tagged, err)
+	}
+	return string(encoded)
+}
+
+func TestReceiptsAreFilteredByTag(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	groceries := processTestReceipt(t, router, withTestTags(t, TARGET_RECEIPT, "Groceries", " snacks "))
+	fuel := processTestReceipt(t, router, withTestTags(t, MM_RECEIPT, "fuel", "snacks"))
+	untagged := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	// tags are stored tidied up and returned with the receipt
+	stored := decodeTestJSON[StoredReceipt](t, serveRequest(router, http.MethodGet, "/receipts/"+groceries, ""))
+	if strings.Join(stored.Tags, ",") != "groceries,snacks" {
+		t.Errorf("the tags were stored as %v, expected [groceries snacks]", stored.Tags)
+	}
+
+	for _, test := range []struct {
+		query string
+		ids   []string
+	}{
+		{"", []string{groceries, fuel, untagged}},
+		{"?tag=groceries", []string{groceries}},
+		{"?tag=GROCERIES", []string{groceries}},
+		{"?tag=snacks", []string{groceries, fuel}},
+		{"?tag=toys", []string{}},
+	} {
+		recorder := serveRequest(router, http.MethodGet, "/receipts"+test.query, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("listing %s responded %d: %s", test.query, recorder.Code, recorder.Body)
+		}
+		ids := []string{}
+		for _, receipt := range decodeTestJSON[ReceiptPage[StoredReceipt]](t, recorder).Receipts {
+			ids = append(ids, receipt.Id)
+		}
+		if strings.Join(ids, ",") != strings.Join(test.ids, ",") {
+			t.Errorf("listing %s found %v, expected %v", test.query, ids, test.ids)
+		}
+	}
+}
+
+func TestReceiptCachingHeaders(t *testing.T) {
+	resetState(t)
+	config.CacheMaxAge = 5 * time.Minute
+	router := newTestRouter(t)
+	before := time.Now().Truncate(time.Second)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+	after := time.Now()
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts/"+id, "")
+	if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != "public, max-age=300" {
+		t.Errorf("the receipt was sent with Cache-Control %q, expected public, max-age=300", cacheControl)
+	}
+	lastModified, err := http.ParseTime(recorder.Header().Get("Last-Modified"))
+	if err != nil {
+		t.Fatalf("the receipt was sent with an unreadable Last-Modified: %v", err)
+	}
+	if lastModified.Before(before) || lastModified.After(after) {
+		t.Errorf("the receipt was last modified at %s, expected when it was stored between %s and %s", lastModified, before, after)
+	}
+
+	// the points change whenever the rules are reloaded, so responses holding them are always revalidated
+	for _, endpoint := range []string{"points", "breakdown", "full"} {
+		if cacheControl := serveRequest(router, http.MethodGet, "/receipts/"+id+"/"+endpoint, "").Header().Get("Cache-Control"); cacheControl != "no-cache" {
+			t.Errorf("%s was sent with Cache-Control %q, expected no-cache", endpoint, cacheControl)
+		}
+	}
+
+	// a missing receipt is not cached
+	if cacheControl := serveRequest(router, http.MethodGet, "/receipts/missing", "").Header().Get("Cache-Control"); cacheControl != "" {
+		t.Errorf("a missing receipt was sent with Cache-Control %q", cacheControl)
+	}
+}
+
+func TestReceiptIdsByRetailer(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	target := []string{
+		processTestReceipt(t, router, TARGET_RECEIPT),
+		processTestReceipt(t, router, targetVariant(t, "target", "2022-01-02", "35.35")),
+		processTestReceipt(t, router, targetVariant(t, "T-A-R-G-E-T", "2022-01-03", "35.35")),
+	}
+	processTestReceipt(t, router, MM_RECEIPT)
+
+	list := func(path string) ReceiptPage[string] {
+		recorder := serveRequest(router, http.MethodGet, path, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s responded %d: %s", path, recorder.Code, recorder.Body)
+		}
+		return decodeTestJSON[ReceiptPage[string]](t, recorder)
+	}
+
+	if page := list("/receipts/by-retailer/TARGET"); page.Total != 3 || strings.Join(page.Receipts, ",") != strings.Join(target, ",") {
+		t.Errorf("the target ids were %v of %d, expected %v", page.Receipts, page.Total, target)
+	}
+	if page := list("/receipts/by-retailer/Target?offset=1&limit=1"); page.Total != 3 || len(page.Receipts) != 1 || page.Receipts[0] != target[1] {
+		t.Errorf("the second page of target ids was %v of %d, expected [%s]", page.Receipts, page.Total, target[1])
+	}
+	if page := list("/receipts/by-retailer/Walgreens"); page.Total != 0 || len(page.Receipts) != 0 {
+		t.Errorf("an unknown retailer listed %v", page.Receipts)
+	}
+}
+
+func TestWhatIfTotalChangesOnlyTheTotalBonuses(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	whatIf := testReceipt(t, TARGET_RECEIPT)
+	whatIf.Total = "35.00"
+	before := CalculateBreakdown(testReceipt(t, TARGET_RECEIPT), defaultRules(), ScoringFacts{})
+	after := CalculateBreakdown(whatIf, defaultRules(), ScoringFacts{})
+	for _, contribution := range append(before.Rules, after.Rules...) {
+		changed := rulePoints(before, contribution.Rule) != rulePoints(after, contribution.Rule)
+		if totalBased := contribution.Rule == ROUND_DOLLAR_TOTAL_RULE || contribution.Rule == QUARTER_MULTIPLE_TOTAL_RULE; changed != totalBased {
+			t.Errorf("the %s rule changed %t with the total", contribution.Rule, changed)
+		}
+	}
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points?total=35.00", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("points with a what-if total responded %d: %s", recorder.Code, recorder.Body)
+	}
+	if points := decodeTestJSON[Points](t, recorder).Points; points != after.Points || points == TARGET_POINTS {
+		t.Errorf("a what-if total of 35.00 was awarded %d points, expected %d", points, after.Points)
+	}
+	// the stored receipt keeps its own total
+	if points := testPoints(t, router, id); points != TARGET_POINTS {
+		t.Errorf("the receipt has %d points after the what-if, expected %d", points, TARGET_POINTS)
+	}
+
+	for _, total := range []string{"abc", "35.0", "-1.00"} {
+		if recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points?total="+total, ""); recorder.Code != http.StatusBadRequest {
+			t.Errorf("a what-if total of %s responded %d, expected %d", total, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestPointsIncludeMax(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	target := processTestReceipt(t, router, TARGET_RECEIPT)
+	mm := processTestReceipt(t, router, MM_RECEIPT)
+
+	for _, test := range []struct {
+		name      string
+		id        string
+		points    int
+		maxPoints int
+	}{
+		// purchased at 13:01, before the afternoon window, for a total that is neither round nor a quarter
+		{"target", target, TARGET_POINTS, TARGET_POINTS + BETWEEN_2PM_AND_4PM_BONUS + ROUND_DOLLAR_AMOUNT_BONUS + MULTIPLE_OF_0_POINT_25_BONUS},
+		// purchased within the window for a round total, on the 20th rather than an odd day
+		{"m&m corner market", mm, MM_POINTS, MM_POINTS + ODD_DAY_BONUS},
+	} {
+		recorder := serveRequest(router, http.MethodGet, "/receipts/"+test.id+"/points?includeMax=true", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("points of %s with the max responded %d: %s", test.name, recorder.Code, recorder.Body)
+		}
+		points := decodeTestJSON[Points](t, recorder)
+		if points.Points != test.points || points.MaxPoints == nil || *points.MaxPoints != test.maxPoints {
+			t.Errorf("%s was awarded %d of at most %v points, expected %d of at most %d", test.name, points.Points, points.MaxPoints, test.points, test.maxPoints)
+		}
+	}
+
+	if points := decodeTestJSON[Points](t, serveRequest(router, http.MethodGet, "/receipts/"+target+"/points", "")); points.MaxPoints != nil {
+		t.Errorf("the max points were included without being asked for: %d", *points.MaxPoints)
+	}
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/"+target+"/points?includeMax=maybe", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("an invalid includeMax responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// the number of receipts currently held in the store, kept in sync on every write
+var receiptsStored = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "receipts_stored",
+	Help: "The number of receipts currently held in the store.",
+})
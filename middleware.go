@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Recovers from any panic while serving a request, logging it rather than crashing the app
+aborts with 500 error, unless the response has already been started
+*/
+func recoverPanics(context *gin.Context) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logInfo("panic recovered", "method", context.Request.Method, "path", context.Request.URL.Path, "panic", recovered)
+			if !context.Writer.Written() {
+				abortWithError(context, http.StatusInternalServerError, INTERNAL_ERROR_PROBLEM, "Something went wrong, please try again later")
+			} else {
+				context.Abort()
+			}
+		}
+	}()
+	context.Next()
+}
+
+/*
+Attaches a deadline to every request
+the store gives up once it passes, so a slow backend can not hold requests forever
+*/
+func timeout(duration time.Duration) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		request, cancel := withDeadline(context.Request, duration)
+		defer cancel()
+
+		context.Request = request
+		context.Next()
+	}
+}
+
+// a copy of the request whose context is cancelled after the given duration
+func withDeadline(request *http.Request, duration time.Duration) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(request.Context(), duration)
+	return request.WithContext(ctx), cancel
+}
+
+/*
+Transparently decompresses request bodies sent with a gzip or deflate Content-Encoding
+aborts with 400 error if the body is not validly compressed, and with 413 error if it decompresses to more than MAX_DECOMPRESSED_SIZE
+*/
+func decompressBody(context *gin.Context) {
+	var reader io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(context.GetHeader("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(context.Request.Body)
+	case "deflate":
+		reader, err = zlib.NewReader(context.Request.Body)
+	default:
+		context.Next()
+		return
+	}
+	if err != nil {
+		abortWithError(context, http.StatusBadRequest, MALFORMED_BODY_PROBLEM, "The request body is not validly compressed")
+		return
+	}
+	defer reader.Close()
+
+	// the body is decompressed up front, reading no more than one byte past the limit, so a small body cannot expand without bound
+	limited := io.Reader(reader)
+	if config.MaxDecompressedSize > 0 {
+		limited = io.LimitReader(reader, config.MaxDecompressedSize+1)
+	}
+	body, err := io.ReadAll(limited)
+	if err != nil {
+		abortWithError(context, http.StatusBadRequest, MALFORMED_BODY_PROBLEM, "The request body is not validly compressed")
+		return
+	}
+	if config.MaxDecompressedSize > 0 && int64(len(body)) > config.MaxDecompressedSize {
+		abortWithError(context, http.StatusRequestEntityTooLarge, BODY_TOO_LARGE_PROBLEM, fmt.Sprintf("The request body decompresses to more than %d bytes", config.MaxDecompressedSize))
+		return
+	}
+
+	// the body is no longer encoded once it is decompressed
+	context.Request.Body = io.NopCloser(bytes.NewReader(body))
+	context.Request.Header.Del("Content-Encoding")
+	context.Request.ContentLength = int64(len(body))
+	context.Next()
+}
+
+/*
+Redirects a request for a path in the wrong case, such as /Receipts/Process, to the route it was meant for
+used in place of gin's RedirectFixedPath, which panics looking up some paths under routes with params
+params are kept as they were given, since ids are case sensitive, and a stray trailing slash is dropped too
+GETs are redirected with 301 and other methods with 307, and paths matching no route are left to 404
+*/
+func redirectToFixedPath(router *gin.Engine) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		path := context.Request.URL.Path
+		if len(path) > 1 {
+			path = strings.TrimSuffix(path, "/")
+		}
+
+		// the route with the fewest params wins, as it does when routing, so /Receipts/Count is the count and not a receipt
+		best, bestParams := "", -1
+		for _, route := range router.Routes() {
+			if route.Method != context.Request.Method {
+				continue
+			}
+			fixed, params, matched := matchRouteIgnoringCase(route.Path, path)
+			if matched && fixed != context.Request.URL.Path && (bestParams < 0 || params < bestParams) {
+				best, bestParams = fixed, params
+			}
+		}
+		if bestParams < 0 {
+			return
+		}
+
+		status := http.StatusTemporaryRedirect
+		if context.Request.Method == http.MethodGet {
+			status = http.StatusMovedPermanently
+		}
+		location := *context.Request.URL
+		location.Path = best
+		context.Redirect(status, location.String())
+		context.Abort()
+	}
+}
+
+// the path as the route writes it, and how many params it filled, when it matches the route ignoring the case of everything but its params
+func matchRouteIgnoringCase(route string, path string) (fixed string, params int, matched bool) {
+	routeSegments, pathSegments := strings.Split(route, "/"), strings.Split(path, "/")
+	for i, segment := range routeSegments {
+		switch {
+		case strings.HasPrefix(segment, "*"):
+			if i >= len(pathSegments) {
+				return "", 0, false
+			}
+			return strings.Join(append(routeSegments[:i:i], pathSegments[i:]...), "/"), params + 1, true
+		case i >= len(pathSegments):
+			return "", 0, false
+		case strings.HasPrefix(segment, ":"):
+			if pathSegments[i] == "" {
+				return "", 0, false
+			}
+			routeSegments[i] = pathSegments[i]
+			params++
+		case !strings.EqualFold(segment, pathSegments[i]):
+			return "", 0, false
+		}
+	}
+	if len(routeSegments) != len(pathSegments) {
+		return "", 0, false
+	}
+	return strings.Join(routeSegments, "/"), params, true
+}
+
+/*
+Only lets through requests whose body has one of the given content types
+aborts with 415 error otherwise, rather than failing to bind the body
+*/
+func requireContentType(contentTypes ...string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		for _, contentType := range contentTypes {
+			if context.ContentType() == contentType {
+				context.Next()
+				return
+			}
+		}
+
+		abortWithError(context, http.StatusUnsupportedMediaType, UNSUPPORTED_MEDIA_TYPE_PROBLEM, "The Content-Type must be one of: "+strings.Join(contentTypes, ", "))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the body compressed with the given writer
+func compressTestBody(t *testing.T, body string, newWriter func(io.Writer) io.WriteCloser) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	writer := newWriter(&compressed)
+	if _, err := writer.Write([]byte(body)); err != nil {
+		t.Fatalf("could not compress %q: %v", body, err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("could not compress %q: %v", body, err)
+	}
+	return compressed.Bytes()
+}
+
+// serves the json body through the router, sent with the given Content-Encoding
+func serveEncodedRequest(router http.Handler, path string, body []byte, encoding string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	request.Header.Set("Content-Type", gin.MIMEJSON)
+	request.Header.Set("Content-Encoding", encoding)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestCompressedReceiptIsProcessed(t *testing.T) {
+	gzipWriter := func(writer io.Writer) io.WriteCloser { return gzip.NewWriter(writer) }
+	zlibWriter := func(writer io.Writer) io.WriteCloser { return zlib.NewWriter(writer) }
+
+	for _, test := range []struct {
+		encoding  string
+		newWriter func(io.Writer) io.WriteCloser
+	}{
+		{"gzip", gzipWriter},
+		{"x-gzip", gzipWriter},
+		{"deflate", zlibWriter},
+	} {
+		resetState(t)
+		router := newTestRouter(t)
+
+		recorder := serveEncodedRequest(router, "/receipts/process", compressTestBody(t, TARGET_RECEIPT, test.newWriter), test.encoding)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("a %s receipt responded %d: %s", test.encoding, recorder.Code, recorder.Body)
+		}
+		if points := testPoints(t, router, decodeTestJSON[Id](t, recorder).Id); points != TARGET_POINTS {
+			t.Errorf("a %s receipt scored %d, expected %d", test.encoding, points, TARGET_POINTS)
+		}
+	}
+}
+
+func TestCompressedBatchIsProcessed(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	body := compressTestBody(t, "["+TARGET_RECEIPT+","+MM_RECEIPT+"]", func(writer io.Writer) io.WriteCloser { return gzip.NewWriter(writer) })
+	recorder := serveEncodedRequest(router, "/receipts/batch", body, "gzip")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("a gzip batch responded %d: %s", recorder.Code, recorder.Body)
+	}
+	results := decodeTestJSON[[]BatchResult](t, recorder)
+	if len(results) != 2 || results[0].Points == nil || *results[0].Points != TARGET_POINTS || results[1].Points == nil || *results[1].Points != MM_POINTS {
+		t.Errorf("a gzip batch gave %s, expected %d and %d points", recorder.Body, TARGET_POINTS, MM_POINTS)
+	}
+}
+
+func TestMalformedCompressionIsRejected(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		recorder := serveEncodedRequest(router, "/receipts/process", []byte(TARGET_RECEIPT), encoding)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("an uncompressed body sent as %s responded %d, expected %d", encoding, recorder.Code, http.StatusBadRequest)
+		}
+	}
+	if count := testGauge(t, router, "receipts_stored"); count != "0" {
+		t.Errorf("%s receipts were stored from malformed bodies", count)
+	}
+}
+
+func TestDecompressedBodyIsLimited(t *testing.T) {
+	resetState(t)
+	config.MaxDecompressedSize = int64(len(TARGET_RECEIPT))
+	router := newTestRouter(t)
+	gzipWriter := func(writer io.Writer) io.WriteCloser { return gzip.NewWriter(writer) }
+
+	// a receipt right at the limit is processed
+	if recorder := serveEncodedRequest(router, "/receipts/process", compressTestBody(t, TARGET_RECEIPT, gzipWriter), "gzip"); recorder.Code != http.StatusOK {
+		t.Errorf("a gzip receipt at the limit responded %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// a body that compresses well but decompresses past the limit is rejected
+	padded := TARGET_RECEIPT + strings.Repeat(" ", 1<<20)
+	recorder := serveEncodedRequest(router, "/receipts/process", compressTestBody(t, padded, gzipWriter), "gzip")
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("a gzip body decompressing past the limit responded %d, expected %d", recorder.Code, http.StatusRequestEntityTooLarge)
+	}
+	if count := testGauge(t, router, "receipts_stored"); count != "1" {
+		t.Errorf("%s receipts were stored, expected only the one at the limit", count)
+	}
+
+	// the limit can be disabled
+	config.MaxDecompressedSize = 0
+	if recorder := serveEncodedRequest(router, "/receipts/process", compressTestBody(t, padded, gzipWriter), "gzip"); recorder.Code != http.StatusOK {
+		t.Errorf("a padded gzip receipt without a limit responded %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestProcessRequiresSupportedContentType(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	for _, contentType := range []string{"text/plain", "application/xml", ""} {
+		recorder := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT, "Content-Type", contentType, "Accept", "application/problem+json")
+		if recorder.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("a %q body responded %d, expected %d", contentType, recorder.Code, http.StatusUnsupportedMediaType)
+			continue
+		}
+		if problem := decodeTestJSON[Problem](t, recorder); problem.Type != UNSUPPORTED_MEDIA_TYPE_PROBLEM {
+			t.Errorf("a %q body was a %s problem, expected %s", contentType, problem.Type, UNSUPPORTED_MEDIA_TYPE_PROBLEM)
+		}
+	}
+
+	// a json content type may carry parameters such as its charset
+	if recorder := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT, "Content-Type", "application/json; charset=utf-8"); recorder.Code != http.StatusOK {
+		t.Errorf("a json body with a charset responded %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestMatchRouteIgnoringCase(t *testing.T) {
+	for _, test := range []struct {
+		route   string
+		path    string
+		fixed   string
+		params  int
+		matched bool
+	}{
+		{"/receipts/process", "/Receipts/PROCESS", "/receipts/process", 0, true},
+		{"/receipts/:id/points", "/RECEIPTS/AbC/Points", "/receipts/AbC/points", 1, true},
+		{"/receipts/:id", "/Receipts/count", "/receipts/count", 1, true},
+		{"/receipts/:id", "/Receipts/", "", 0, false},
+		{"/receipts/:id", "/Receipts/a/b", "", 0, false},
+		{"/receipts/:id/points", "/receipts/a", "", 0, false},
+		{"/files/*path", "/FILES/A/b", "/files/A/b", 1, true},
+		{"/rules", "/rulez", "", 0, false},
+	} {
+		fixed, params, matched := matchRouteIgnoringCase(test.route, test.path)
+		if fixed != test.fixed || params != test.params || matched != test.matched {
+			t.Errorf("matching %s against %s gave %q, %d, %t, expected %q, %d, %t", test.path, test.route, fixed, params, matched, test.fixed, test.params, test.matched)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the currency receipts are assumed to be in when they do not give one
+const DEFAULT_CURRENCY = "USD"
+
+// how many decimal places money in a currency is written with, when it is not the usual DEFAULT_MINOR_UNITS
+const DEFAULT_MINOR_UNITS = 2
+
+var CURRENCY_MINOR_UNITS = map[string]int{
+	// currencies with no minor unit, every total in them is a whole amount
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	// currencies divided into thousandths
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// the conventions money strings can be written in, us with a decimal point and eu with a decimal comma
+const US_MONEY_LOCALE = "us"
+const EU_MONEY_LOCALE = "eu"
+
+// the currency symbols money strings may be prefixed with when none are configured
+const CURRENCY_SYMBOLS = "$"
+
+// money strings for currencies with and without a minor unit, whole units followed by exactly that many decimal places
+var MONEY_PATTERNS = map[int]*regexp.Regexp{
+	0: regexp.MustCompile(`^(\d+)()$`),
+	2: regexp.MustCompile(`^(\d+)\.(\d{2})$`),
+	3: regexp.MustCompile(`^(\d+)\.(\d{3})$`),
+}
+
+// the number of decimal places money in the given currency is written with
+func minorUnits(currency string) int {
+	if units, found := CURRENCY_MINOR_UNITS[currency]; found {
+		return units
+	}
+	return DEFAULT_MINOR_UNITS
+}
+
+// the number of minor units in one whole unit of the given currency, such as 100 cents in a dollar
+func minorUnitsPerUnit(currency string) int64 {
+	perUnit := int64(1)
+	for i := 0; i < minorUnits(currency); i++ {
+		perUnit *= 10
+	}
+	return perUnit
+}
+
+/*
+Rewrites the given money string in the canonical form parseCents expects
+trims it, strips one leading configured currency symbol, and swaps a decimal comma for a point under the eu locale
+anything else, such as an unexpected symbol, is left for validation to reject
+*/
+func normalizeMoney(value string) string {
+	value = strings.TrimSpace(value)
+	for _, symbol := range config.CurrencySymbols {
+		if strings.HasPrefix(value, symbol) {
+			value = strings.TrimSpace(strings.TrimPrefix(value, symbol))
+			break
+		}
+	}
+	if config.MoneyLocale == EU_MONEY_LOCALE {
+		value = strings.Replace(value, ",", ".", 1)
+	}
+	return value
+}
+
+/*
+Parses the given money string in the given currency, such as "35.35" dollars, into a whole number of its minor unit
+the string must have exactly as many decimal places as the currency has, so "1.234" dinars but "1234" yen
+*/
+func parseCents(value string, currency string) (int64, error) {
+	match := MONEY_PATTERNS[minorUnits(currency)].FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("%q is not an amount of %s", value, currency)
+	}
+
+	units, err := strconv.ParseInt(match[1], 10, 64)
+	perUnit := minorUnitsPerUnit(currency)
+	if err != nil || units > math.MaxInt64/perUnit-1 {
+		return 0, fmt.Errorf("%q is too large an amount of %s", value, currency)
+	}
+	cents, _ := strconv.ParseInt("0"+match[2], 10, 64)
+	return units*perUnit + cents, nil
+}
+
+// writes the given whole number of minor units in the given currency as a money string, the reverse of parseCents
+func formatCents(cents int64, currency string) string {
+	places := minorUnits(currency)
+	if places == 0 {
+		return strconv.FormatInt(cents, 10)
+	}
+	perUnit := minorUnitsPerUnit(currency)
+	return fmt.Sprintf("%d.%0*d", cents/perUnit, places, cents%perUnit)
+}
+
+// which quarter of a whole unit the given amount of minor units ends in, 0 for .00 through 3 for .75
+// ok is false if it is not a multiple of a quarter, and always for a currency whose unit cannot be split into quarters
+func quarterOf(cents int64, currency string) (quarter int64, ok bool) {
+	perUnit := minorUnitsPerUnit(currency)
+	if perUnit%4 != 0 {
+		return 0, false
+	}
+	return (cents % perUnit) / (perUnit / 4), cents%(perUnit/4) == 0
+}
+
+// the currency of the given receipt, DEFAULT_CURRENCY if it does not give one
+func currencyOf(receipt Receipt) string {
+	if receipt.Currency == "" {
+		return DEFAULT_CURRENCY
+	}
+	return receipt.Currency
+}
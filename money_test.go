@@ -0,0 +1,213 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseCents(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		currency string
+		cents    int64
+		valid    bool
+	}{
+		{"35.35", "USD", 3535, true},
+		{"0.00", "USD", 0, true},
+		{"1001", "JPY", 1001, true},
+		{"1.234", "KWD", 1234, true},
+		{"35.3", "USD", 0, false},
+		{"35", "USD", 0, false},
+		{"10.00", "JPY", 0, false},
+		{" 35.35", "USD", 0, false},
+		{"3 5.35", "USD", 0, false},
+		{"-1.00", "USD", 0, false},
+	} {
+		cents, err := parseCents(test.value, test.currency)
+		if (err == nil) != test.valid || (test.valid && cents != test.cents) {
+			t.Errorf("parsing %q %s gave %d and error %v, expected %d and valid %t", test.value, test.currency, cents, err, test.cents, test.valid)
+		}
+	}
+}
+
+func TestNormalizeMoneyTrimsWhitespace(t *testing.T) {
+	resetState(t)
+	for value, normalized := range map[string]string{
+		" 3.00 ":   "3.00",
+		"\t3.00\n": "3.00",
+		"3.00":     "3.00",
+		"3 .00":    "3 .00",
+	} {
+		if got := normalizeMoney(value); got != normalized {
+			t.Errorf("normalized %q to %q, expected %q", value, got, normalized)
+		}
+	}
+}
+
+func TestMoneyWithSurroundingWhitespaceIsAccepted(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	// padded totals and prices are trimmed and scored as if they were never padded
+	padded := strings.Replace(strings.Replace(TARGET_RECEIPT, `"35.35"`, `"  35.35 "`, 1), `"12.25"`, `" 12.25"`, 1)
+	id := processTestReceipt(t, router, padded)
+	if points := testPoints(t, router, id); points != TARGET_POINTS {
+		t.Errorf("the padded receipt scored %d, expected %d", points, TARGET_POINTS)
+	}
+	stored := decodeTestJSON[StoredReceipt](t, serveRequest(router, http.MethodGet, "/receipts/"+id, ""))
+	if stored.Total != "35.35" || stored.Items[1].Price != "12.25" {
+		t.Errorf("the padded money was stored as %q and %q, expected it trimmed", stored.Total, stored.Items[1].Price)
+	}
+
+	// whitespace inside the amount is still rejected rather than scored as nothing
+	if recorder := serveRequest(router, http.MethodPost, "/receipts/process", strings.Replace(TARGET_RECEIPT, `"35.35"`, `"35 .35"`, 1)); recorder.Code != http.StatusBadRequest {
+		t.Errorf("a total with whitespace inside responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestNormalizeMoneyStripsCurrencySymbols(t *testing.T) {
+	for _, test := range []struct {
+		symbols    []string
+		locale     string
+		value      string
+		normalized string
+	}{
+		{[]string{"$"}, US_MONEY_LOCALE, "3.00", "3.00"},
+		{[]string{"$"}, US_MONEY_LOCALE, "$3.00", "3.00"},
+		{[]string{"$"}, US_MONEY_LOCALE, " $ 3.00 ", "3.00"},
+		{[]string{"$", "€"}, EU_MONEY_LOCALE, "€3,00", "3.00"},
+		{[]string{"$", "€"}, EU_MONEY_LOCALE, "3,00", "3.00"},
+		// only one symbol is stripped, and unexpected ones are left for validation to reject
+		{[]string{"$"}, US_MONEY_LOCALE, "$$3.00", "$3.00"},
+		{[]string{"$"}, US_MONEY_LOCALE, "£3.00", "£3.00"},
+		{[]string{"$"}, US_MONEY_LOCALE, "3,00", "3,00"},
+	} {
+		resetState(t)
+		config.CurrencySymbols, config.MoneyLocale = test.symbols, test.locale
+		if got := normalizeMoney(test.value); got != test.normalized {
+			t.Errorf("normalized %q under %v and the %s locale to %q, expected %q", test.value, test.symbols, test.locale, got, test.normalized)
+		}
+	}
+}
+
+func TestMoneyWithCurrencySymbolsIsScored(t *testing.T) {
+	for _, test := range []struct {
+		locale string
+		total  string
+		price  string
+		status int
+	}{
+		{US_MONEY_LOCALE, "35.35", "12.25", http.StatusOK},
+		{US_MONEY_LOCALE, "$35.35", "$12.25", http.StatusOK},
+		{EU_MONEY_LOCALE, "€35,35", "€12,25", http.StatusOK},
+		{US_MONEY_LOCALE, "£35.35", "12.25", http.StatusBadRequest},
+		{US_MONEY_LOCALE, "35.35", "¥12.25", http.StatusBadRequest},
+	} {
+		resetState(t)
+		config.CurrencySymbols, config.MoneyLocale = []string{"$", "€"}, test.locale
+		router := newTestRouter(t)
+
+		receipt := strings.Replace(strings.Replace(TARGET_RECEIPT, `"35.35"`, `"`+test.total+`"`, 1), `"12.25"`, `"`+test.price+`"`, 1)
+		recorder := serveRequest(router, http.MethodPost, "/receipts/process", receipt)
+		if recorder.Code != test.status {
+			t.Errorf("a total of %q and price of %q responded %d, expected %d: %s", test.total, test.price, recorder.Code, test.status, recorder.Body)
+			continue
+		}
+		if test.status == http.StatusOK {
+			if points := testPoints(t, router, decodeTestJSON[Id](t, recorder).Id); points != TARGET_POINTS {
+				t.Errorf("a total of %q and price of %q scored %d, expected %d", test.total, test.price, points, TARGET_POINTS)
+			}
+		}
+	}
+}
+
+func TestMinorUnitsFollowTheCurrency(t *testing.T) {
+	for currency, units := range map[string]int{"USD": 2, "EUR": 2, "": 2, "JPY": 0, "BHD": 3} {
+		if got := minorUnits(currency); got != units {
+			t.Errorf("%q has %d minor units, expected %d", currency, got, units)
+		}
+	}
+
+	for _, test := range []struct {
+		value string
+		cents int64
+		valid bool
+	}{
+		{"1.234", 1234, true},
+		{"0.005", 5, true},
+		{"1.23", 0, false},
+		{"1.2345", 0, false},
+		{"1", 0, false},
+	} {
+		cents, err := parseCents(test.value, "BHD")
+		if (err == nil) != test.valid || (test.valid && cents != test.cents) {
+			t.Errorf("parsing %q BHD gave %d and error %v, expected %d and valid %t", test.value, cents, err, test.cents, test.valid)
+		}
+	}
+}
+
+func TestQuarterOf(t *testing.T) {
+	for _, test := range []struct {
+		cents    int64
+		currency string
+		quarter  int64
+		ok       bool
+	}{
+		{3500, "USD", 0, true},
+		{3525, "USD", 1, true},
+		{3575, "USD", 3, true},
+		{3535, "USD", 1, false},
+		{1250, "BHD", 1, true},
+		{1025, "BHD", 0, false},
+		// a currency without cents can not be split into quarters
+		{1000, "JPY", 0, false},
+		{1001, "JPY", 0, false},
+	} {
+		quarter, ok := quarterOf(test.cents, test.currency)
+		if ok != test.ok || (ok && quarter != test.quarter) {
+			t.Errorf("the quarter of %d %s was %d and %t, expected %d and %t", test.cents, test.currency, quarter, ok, test.quarter, test.ok)
+		}
+	}
+}
+
+func TestQuarterTotals(t *testing.T) {
+	for _, test := range []struct {
+		total    string
+		currency string
+		totals   string
+	}{
+		{"35.35", "USD", "35.00,35.25,35.50,35.75"},
+		{"1.234", "BHD", "1.000,1.250,1.500,1.750"},
+		{"1001", "JPY", ""},
+		{"35.3", "USD", ""},
+	} {
+		if totals := strings.Join(quarterTotals(test.total, test.currency), ","); totals != test.totals {
+			t.Errorf("the quarter totals of %s %s were %q, expected %q", test.total, test.currency, totals, test.totals)
+		}
+	}
+}
+
+func TestThreeDecimalReceiptIsScored(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	// a quarter of a dinar is 250 fils, so the total earns the quarter bonus but not the round amount one
+	receipt := strings.NewReplacer(`"total":"9.00"`, `"total":"9.250","currency":"BHD"`, `"2.25"`, `"2.250"`).Replace(MM_RECEIPT)
+	id := processTestReceipt(t, router, receipt)
+	if points := testPoints(t, router, id); points != MM_POINTS-ROUND_DOLLAR_AMOUNT_BONUS {
+		t.Errorf("the dinar receipt scored %d, expected %d", points, MM_POINTS-ROUND_DOLLAR_AMOUNT_BONUS)
+	}
+
+	// a yen total can not end in a quarter, so the most it could be worth only moves with the day and time
+	receipt = strings.NewReplacer(`"total":"9.00"`, `"total":"1001","currency":"JPY"`, `"2.25"`, `"225"`).Replace(MM_RECEIPT)
+	id = processTestReceipt(t, router, receipt)
+	recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points?includeMax=true", "")
+	points := decodeTestJSON[Points](t, recorder)
+	if expected := MM_POINTS - ROUND_DOLLAR_AMOUNT_BONUS - MULTIPLE_OF_0_POINT_25_BONUS; points.Points != expected {
+		t.Errorf("the yen receipt scored %d, expected %d", points.Points, expected)
+	}
+	if points.MaxPoints == nil || *points.MaxPoints != points.Points+ODD_DAY_BONUS {
+		t.Errorf("the yen receipt could be worth %v, expected %d", points.MaxPoints, points.Points+ODD_DAY_BONUS)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// default and largest number of receipts returned in one page
+const PAGE_LIMIT = 20
+const MAX_PAGE_LIMIT = 100
+
+// response of paginated endpoints, one page of receipts, or of some of their fields
+type ReceiptPage[T any] struct {
+	Receipts []T `json:"receipts"`
+	// the number of receipts across every page
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+/*
+Reads the offset and limit query params of a paginated endpoint
+aborts with 400 error and returns false if either is malformed or out of bounds
+*/
+func pagination(context *gin.Context) (offset int, limit int, ok bool) {
+	offset, err := strconv.Atoi(context.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		abortWithError(context, http.StatusBadRequest, INVALID_PAGINATION_PROBLEM, "The offset must be a non-negative whole number")
+		return 0, 0, false
+	}
+	limit, err = strconv.Atoi(context.DefaultQuery("limit", strconv.Itoa(PAGE_LIMIT)))
+	if err != nil || limit < 1 || limit > MAX_PAGE_LIMIT {
+		abortWithError(context, http.StatusBadRequest, INVALID_PAGINATION_PROBLEM, fmt.Sprintf("The limit must be between 1 and %d", MAX_PAGE_LIMIT))
+		return 0, 0, false
+	}
+	return offset, limit, true
+}
+
+// the page of the given receipts starting at offset, holding at most limit receipts
+func paginate[T any](receipts []T, offset int, limit int) ReceiptPage[T] {
+	page := ReceiptPage[T]{Receipts: []T{}, Total: len(receipts), Offset: offset, Limit: limit}
+	if offset < len(receipts) {
+		end := offset + limit
+		if end > len(receipts) {
+			end = len(receipts)
+		}
+		page.Receipts = receipts[offset:end]
+	}
+	return page
+}
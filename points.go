@@ -0,0 +1,473 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// names of the rules a receipt's points are broken down by
+const RETAILER_NAME_RULE = "retailerName"
+const EVERY_TWO_ITEMS_RULE = "everyTwoItems"
+const ROUND_DOLLAR_TOTAL_RULE = "roundDollarTotal"
+const QUARTER_MULTIPLE_TOTAL_RULE = "quarterMultipleTotal"
+const ODD_PURCHASE_DAY_RULE = "oddPurchaseDay"
+const AFTERNOON_PURCHASE_RULE = "afternoonPurchase"
+const LONG_RECEIPT_RULE = "longReceipt"
+const FIRST_PURCHASE_OF_DAY_RULE = "firstPurchaseOfDay"
+const RETAILER_MULTIPLIER_RULE = "retailerMultiplier"
+const MAX_POINTS_RULE = "maxPoints"
+const ITEM_DESCRIPTION_RULE = "itemDescription"
+const HOLIDAY_PURCHASE_RULE = "holidayPurchase"
+const SCORE_ROUNDING_RULE = "scoreRounding"
+const BIG_SPENDER_RULE = "bigSpender"
+const EVEN_CENTS_TOTAL_RULE = "evenCentsTotal"
+const EVEN_ITEM_COUNT_RULE = "evenItemCount"
+const LAST_DAY_OF_MONTH_RULE = "lastDayOfMonth"
+const COMPLETENESS_RULE = "completeness"
+
+// anything but the letters and digits counted by the retailer name rule
+var NON_ALPHANUMERIC = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// ways the final points can be rounded, set by SCORE_ROUNDING
+const SCORE_ROUNDING_NONE = "none"
+const SCORE_ROUNDING_NEAREST_5 = "nearest5"
+const SCORE_ROUNDING_NEAREST_10 = "nearest10"
+
+// receipts with more qualifying items than this have their per-item contributions summarized in a single line
+const BREAKDOWN_ITEM_DETAIL_LIMIT = 20
+
+// longest detail string a breakdown line may carry, longer ones are cut short
+const MAX_BREAKDOWN_DETAIL_LENGTH = 100
+
+// most items a breakdown lists individually, the rest are only counted
+const MAX_BREAKDOWN_ITEMS = 100
+
+// what the store knows about a receipt beyond its own fields, for rules that compare it to other receipts
+type ScoringFacts struct {
+	// whether the receipt was the earliest stored with its purchase date
+	FirstOnDate bool
+}
+
+// one rule's share of the points a receipt is worth
+type Contribution struct {
+	Rule   string `json:"rule"`
+	Points int    `json:"points"`
+	Detail string `json:"detail,omitempty"`
+	// the points before any weighting rounded them to whole points
+	raw float64
+}
+
+// how one item fared under the item description rule
+type ItemContribution struct {
+	Description string `json:"description"`
+	Qualified   bool   `json:"qualified"`
+	Points      int    `json:"points"`
+}
+
+// response of /receipts/:id/breakdown endpoint, the points a receipt is worth and the rules that awarded them
+type Breakdown struct {
+	Points int `json:"points"`
+	// the points as they would be if weighted rules were not rounded to whole points, only given by /receipts/:id/points when asked for
+	RawPoints float64 `json:"-"`
+	// the version of the rules the points were calculated under
+	RulesVersion string         `json:"rulesVersion"`
+	Rules        []Contribution `json:"rules"`
+	// every item in the order given, up to MAX_BREAKDOWN_ITEMS, and how many more were left out
+	Items        []ItemContribution `json:"items,omitempty"`
+	ItemsOmitted int                `json:"itemsOmitted,omitempty"`
+}
+
+// adds an item's outcome under the item description rule to the breakdown, only counting it once the list is full
+func (breakdown *Breakdown) addItem(description string, qualified bool, points int) {
+	if len(breakdown.Items) >= MAX_BREAKDOWN_ITEMS {
+		breakdown.ItemsOmitted++
+		return
+	}
+	breakdown.Items = append(breakdown.Items, ItemContribution{Description: truncateDetail(description), Qualified: qualified, Points: points})
+}
+
+// the detail, cut short if it is longer than MAX_BREAKDOWN_DETAIL_LENGTH
+func truncateDetail(detail string) string {
+	if characters := []rune(detail); len(characters) > MAX_BREAKDOWN_DETAIL_LENGTH {
+		return string(characters[:MAX_BREAKDOWN_DETAIL_LENGTH-3]) + "..."
+	}
+	return detail
+}
+
+// adds a rule's contribution to the breakdown, rules that award no points are left out
+func (breakdown *Breakdown) add(rule string, points int, detail string) {
+	breakdown.addRaw(rule, points, float64(points), detail)
+}
+
+// adds a rule's contribution to the breakdown along with its share before rounding, which counts even if it rounds to no points
+func (breakdown *Breakdown) addRaw(rule string, points int, raw float64, detail string) {
+	breakdown.RawPoints += raw
+	if points == 0 {
+		return
+	}
+	breakdown.Points += points
+	breakdown.Rules = append(breakdown.Rules, Contribution{Rule: rule, Points: points, Detail: truncateDetail(detail), raw: raw})
+}
+
+/*
+Calculates the number of points the given receipt is worth under the given rules
+*/
+func CalculatePoints(receipt Receipt, rules Rules, facts ScoringFacts) int {
+	return CalculateBreakdown(receipt, rules, facts).Points
+}
+
+/*
+Calculates the most points the given receipt could be worth under the given rules, were its total, day, and time of purchase at their best
+its retailer, items, and the whole units of its total are kept, so the difference from its points is the bonuses it narrowly missed
+*/
+func CalculateMaxPoints(receipt Receipt, rules Rules, facts ScoringFacts) int {
+	// the odd day before an even one is tried alongside the day itself, which may still be worth more, such as a holiday or the last day of the month
+	dates := []string{receipt.PurchaseDate}
+	if purchaseDate, ok := oddDayBefore(receipt.PurchaseDate); ok {
+		dates = append(dates, purchaseDate)
+	}
+	times := []string{receipt.PurchaseTime}
+	if purchaseTime, ok := bonusWindowTime(rules); ok {
+		times = append(times, purchaseTime)
+	}
+	// the total as it is, and ending in each quarter of a whole unit if it can be read
+	totals := append([]string{receipt.Total}, quarterTotals(receipt.Total, currencyOf(receipt))...)
+
+	maxPoints := 0
+	for _, purchaseDate := range dates {
+		// whether the receipt would be the first stored on another day is not known, so it is not assumed to be
+		dateFacts := facts
+		if purchaseDate != receipt.PurchaseDate {
+			dateFacts = ScoringFacts{}
+		}
+		for _, purchaseTime := range times {
+			candidate := receipt
+			if purchaseDate != receipt.PurchaseDate || purchaseTime != receipt.PurchaseTime {
+				candidate = purchasedAt(receipt, purchaseDate, purchaseTime)
+			}
+			for _, total := range totals {
+				candidate.Total = total
+				if points := CalculatePoints(candidate, rules, dateFacts); points > maxPoints {
+					maxPoints = points
+				}
+			}
+		}
+	}
+	return maxPoints
+}
+
+/*
+Calculates the number of points the given receipt is worth under the given rules
+along with the contribution of every rule that awarded points
+*/
+func CalculateBreakdown(receipt Receipt, rules Rules, facts ScoringFacts) Breakdown {
+	breakdown := Breakdown{RulesVersion: rules.Version, Rules: []Contribution{}}
+
+	/*
+		Add the points pers
+			One point for every alphanumeric character in the retailer name, up to the configured cap.
+			5 points for every two items on the receipt, if it has at least the configured minimum, up to the configured cap.
+	*/
+	alphanumerics := len(NON_ALPHANUMERIC.ReplaceAllString(receipt.Retailer, ""))
+	if retailerPoints := alphanumerics * VALUE_PER_ALPHANUMERIC_CHAR; rules.RetailerNameCap > 0 && retailerPoints > rules.RetailerNameCap {
+		breakdown.add(RETAILER_NAME_RULE, rules.RetailerNameCap, fmt.Sprintf("%d alphanumeric characters, capped at %d points", alphanumerics, rules.RetailerNameCap))
+	} else {
+		breakdown.add(RETAILER_NAME_RULE, retailerPoints, fmt.Sprintf("%d alphanumeric characters", alphanumerics))
+	}
+	if counted := countedItems(receipt, rules); counted >= rules.EveryTwoItemsMinimum {
+		if itemPoints := (counted / 2) * VALUE_PER_TWO_ITEMS; rules.EveryTwoItemsCap > 0 && itemPoints > rules.EveryTwoItemsCap {
+			breakdown.add(EVERY_TWO_ITEMS_RULE, rules.EveryTwoItemsCap, fmt.Sprintf("%d items, capped at %d points", counted, rules.EveryTwoItemsCap))
+		} else {
+			breakdown.add(EVERY_TWO_ITEMS_RULE, itemPoints, fmt.Sprintf("%d items", counted))
+		}
+	}
+
+	/*
+		Add the points bonuses
+			50 points if the total is a round dollar amount with no cents, never for currencies without cents.
+			25 points if the total is a multiple of `0.25`, or as configured for its cents, never for currencies without cents.
+			6 points if the day in the purchase date is odd.
+			10 points if the time of purchase is after 2:00pm and before 4:00pm, or within the configured window.
+	*/
+	currency := currencyOf(receipt)
+	total, totalErr := parseCents(receipt.Total, currency)
+	if totalErr == nil && total%minorUnitsPerUnit(currency) == 0 && minorUnits(currency) > 0 {
+		breakdown.add(ROUND_DOLLAR_TOTAL_RULE, ROUND_DOLLAR_AMOUNT_BONUS, "total of "+receipt.Total)
+	}
+	if quarter, ok := quarterOf(total, currency); totalErr == nil && ok {
+		bonus := MULTIPLE_OF_0_POINT_25_BONUS
+		if rules.QuarterBonuses != nil {
+			bonus = rules.QuarterBonuses[fmt.Sprintf("%02d", quarter*25)]
+		}
+		breakdown.add(QUARTER_MULTIPLE_TOTAL_RULE, bonus, "total of "+receipt.Total)
+	}
+	// the even cents bonus is only awarded when configured, never for currencies without cents
+	if rules.EvenCentsBonus > 0 && totalErr == nil && minorUnits(currency) > 0 && (total%minorUnitsPerUnit(currency))%2 == 0 {
+		breakdown.add(EVEN_CENTS_TOTAL_RULE, rules.EvenCentsBonus, "total of "+receipt.Total)
+	}
+	// leniently validated receipts may have dates of any shape, so the day is only read from one with three parts
+	day, err := 0, fmt.Errorf("%q is not a date", receipt.PurchaseDate)
+	if parts := strings.Split(receipt.PurchaseDate, "-"); len(parts) == 3 {
+		day, err = strconv.Atoi(parts[2])
+	}
+	if err == nil && day%2 == 1 {
+		breakdown.add(ODD_PURCHASE_DAY_RULE, ODD_DAY_BONUS, "purchased on "+receipt.PurchaseDate)
+	}
+	purchaseTime, err := parsePurchaseTime(receipt.PurchaseTime)
+	windowStart, _ := parsePurchaseTime(rules.BonusWindowStart)
+	windowEnd, _ := parsePurchaseTime(rules.BonusWindowEnd)
+	if err == nil && purchaseTime.After(windowStart) && purchaseTime.Before(windowEnd) {
+		breakdown.add(AFTERNOON_PURCHASE_RULE, BETWEEN_2PM_AND_4PM_BONUS, "purchased at "+receipt.PurchaseTime)
+	}
+
+	// the long receipt bonus is only awarded when a threshold is configured
+	if rules.LongReceiptThreshold > 0 && len(receipt.Items) > rules.LongReceiptThreshold {
+		breakdown.add(LONG_RECEIPT_RULE, LONG_RECEIPT_BONUS, fmt.Sprintf("more than %d items", rules.LongReceiptThreshold))
+	}
+
+	// the even item count bonus is only awarded when configured
+	if rules.EvenItemCountBonus > 0 && len(receipt.Items)%2 == 0 {
+		breakdown.add(EVEN_ITEM_COUNT_RULE, rules.EvenItemCountBonus, fmt.Sprintf("%d items", len(receipt.Items)))
+	}
+
+	// the first purchase of the day bonus is only awarded when enabled, to the earliest receipt stored for its date
+	if rules.FirstPurchaseOfDay && facts.FirstOnDate {
+		breakdown.add(FIRST_PURCHASE_OF_DAY_RULE, FIRST_PURCHASE_OF_DAY_BONUS, "first receipt stored for "+receipt.PurchaseDate)
+	}
+
+	// the big spender bonus is only awarded when a threshold is configured, for every whole dollar, or unit, the total is over it
+	if rules.BigSpenderThreshold > 0 && totalErr == nil && total > rules.BigSpenderThreshold {
+		units := (total - rules.BigSpenderThreshold) / minorUnitsPerUnit(currency)
+		breakdown.add(BIG_SPENDER_RULE, int(units)*rules.BigSpenderPointsPerUnit, fmt.Sprintf("%d whole units over the threshold", units))
+	}
+
+	// the holiday bonus is only awarded when holidays are configured, matching either the full date or the month and day
+	if isHoliday(receipt.PurchaseDate, rules.Holidays) {
+		breakdown.add(HOLIDAY_PURCHASE_RULE, HOLIDAY_BONUS, "purchased on a holiday, "+receipt.PurchaseDate)
+	}
+
+	// the last day of the month bonus is only awarded when configured, on whichever day the month ends, leap years included
+	if rules.LastDayOfMonthBonus > 0 {
+		// the day is read from the date as written, as the odd day's is
+		date, err := time.Parse(PURCHASE_DATE_FORMAT, receipt.PurchaseDate)
+		if err == nil && date.AddDate(0, 0, 1).Day() == 1 {
+			breakdown.add(LAST_DAY_OF_MONTH_RULE, rules.LastDayOfMonthBonus, "purchased on the last day of the month, "+receipt.PurchaseDate)
+		}
+	}
+
+	// the completeness bonus is only awarded when configured
+	if rules.CompletenessBonus > 0 && isComplete(receipt) {
+		breakdown.add(COMPLETENESS_RULE, rules.CompletenessBonus, "every optional field given")
+	}
+
+	/*
+		Add the value of each item
+			each qualifying item gets its own line, unless there are too many to list
+			and every item, qualifying or not, is listed with the points it was awarded
+	*/
+	var itemContributions []Contribution
+	for _, item := range receipt.Items {
+		description := strings.TrimSpace(item.ShortDescription)
+		qualified, points := false, 0
+		if len(description)%rules.DescriptionLengthDivisor == 0 {
+			price, err := strconv.ParseFloat(item.Price, 64)
+			if err == nil {
+				// prices that cannot be read in minor units, as lenient validation lets through, take the flat multiplier
+				multiplier := rules.ItemPriceMultiplier
+				if cents, err := parseCents(item.Price, currency); err == nil {
+					multiplier = rules.priceMultiplier(cents)
+				}
+				// rounding up is part of the rule itself, so only the weighting below has a raw share apart from its points
+				qualified, points = true, int(math.Ceil(price*multiplier))
+				raw := float64(points)
+				detail := fmt.Sprintf("%q priced %s", description, item.Price)
+
+				// categorized items are weighted by their category's multiplier, items in unknown categories are not
+				if weight, found := rules.categoryMultiplier(item.Category); found && item.Category != "" {
+					raw = float64(points) * weight
+					points = int(math.Round(raw))
+					detail += fmt.Sprintf(", %g times for %s", weight, item.Category)
+				}
+				itemContributions = append(itemContributions, Contribution{Points: points, Detail: detail, raw: raw})
+			}
+		}
+		breakdown.addItem(description, qualified, points)
+	}
+	if len(itemContributions) > BREAKDOWN_ITEM_DETAIL_LIMIT {
+		points, raw := 0, 0.0
+		for _, contribution := range itemContributions {
+			points += contribution.Points
+			raw += contribution.raw
+		}
+		breakdown.addRaw(ITEM_DESCRIPTION_RULE, points, raw, fmt.Sprintf("%d qualifying items", len(itemContributions)))
+	} else {
+		for _, contribution := range itemContributions {
+			breakdown.addRaw(ITEM_DESCRIPTION_RULE, contribution.Points, contribution.raw, contribution.Detail)
+		}
+	}
+
+	// partner retailers multiply the sum of every rule above, recorded as the points the multiplier added
+	for retailer, multiplier := range rules.RetailerMultipliers {
+		if normalizeRetailer(retailer) == normalizeRetailer(receipt.Retailer) {
+			multiplied := int(math.Round(float64(breakdown.Points) * multiplier))
+			raw := breakdown.RawPoints*multiplier - breakdown.RawPoints
+			breakdown.addRaw(RETAILER_MULTIPLIER_RULE, multiplied-breakdown.Points, raw, fmt.Sprintf("%g times the points for %s", multiplier, retailer))
+			break
+		}
+	}
+
+	// the points are rounded as configured, half way rounding up, recorded as the points the rounding added or took away
+	if step := roundingStep(config.ScoreRounding); step > 1 {
+		rounded := int(math.Floor(float64(breakdown.Points)/float64(step)+0.5)) * step
+		breakdown.add(SCORE_ROUNDING_RULE, rounded-breakdown.Points, fmt.Sprintf("rounded to the nearest %d points", step))
+	}
+
+	// the points are capped last, recorded as the points the cap took away, and the raw points are capped the same
+	if rules.MaxPoints > 0 && breakdown.Points > rules.MaxPoints {
+		breakdown.addRaw(MAX_POINTS_RULE, rules.MaxPoints-breakdown.Points, float64(rules.MaxPoints)-breakdown.RawPoints, fmt.Sprintf("capped at %d points", rules.MaxPoints))
+	}
+
+	return breakdown
+}
+
+// the number of items the every two items rule counts, which leaves out free items if the rules say so
+func countedItems(receipt Receipt, rules Rules) int {
+	if !rules.ExcludeFreeItems {
+		return len(receipt.Items)
+	}
+
+	counted := 0
+	for _, item := range receipt.Items {
+		if price, err := parseCents(item.Price, currencyOf(receipt)); err != nil || price != 0 {
+			counted++
+		}
+	}
+	return counted
+}
+
+// the multiple of points the given SCORE_ROUNDING mode rounds to, 1 for none
+func roundingStep(mode string) int {
+	switch mode {
+	case SCORE_ROUNDING_NEAREST_5:
+		return 5
+	case SCORE_ROUNDING_NEAREST_10:
+		return 10
+	}
+	return 1
+}
+
+// the retailer name with case and anything but letters and digits ignored, so "Target" and " target " match
+func normalizeRetailer(retailer string) string {
+	return strings.ToLower(NON_ALPHANUMERIC.ReplaceAllString(retailer, ""))
+}
+
+// whether the purchase date, as YYYY-MM-DD, is one of the holidays, given as YYYY-MM-DD for one year or MM-DD for every year
+func isHoliday(purchaseDate string, holidays []string) bool {
+	for _, holiday := range holidays {
+		if holiday == purchaseDate || (len(holiday) == len(HOLIDAY_FORMAT) && strings.HasSuffix(purchaseDate, "-"+holiday)) {
+			return true
+		}
+	}
+	return false
+}
+
+// a time strictly within the bonus window, its middle minute, ok is false if the window is too narrow to hold one
+func bonusWindowTime(rules Rules) (purchaseTime string, ok bool) {
+	windowStart, startErr := parsePurchaseTime(rules.BonusWindowStart)
+	windowEnd, endErr := parsePurchaseTime(rules.BonusWindowEnd)
+	if startErr != nil || endErr != nil || windowEnd.Sub(windowStart) < 2*time.Minute {
+		return "", false
+	}
+	return windowStart.Add(windowEnd.Sub(windowStart) / 2).Truncate(time.Minute).Format("15:04"), true
+}
+
+// the day before the given purchase date when it is even, which is always odd and in the same month, ok is false otherwise
+func oddDayBefore(purchaseDate string) (before string, ok bool) {
+	date, err := time.Parse(PURCHASE_DATE_FORMAT, purchaseDate)
+	if err != nil || date.Day()%2 == 1 {
+		return "", false
+	}
+	return date.AddDate(0, 0, -1).Format(PURCHASE_DATE_FORMAT), true
+}
+
+// the given total with its fraction of a whole unit replaced by each quarter in turn
+// none if it cannot be read, or if its currency's unit cannot be split into quarters
+func quarterTotals(total string, currency string) []string {
+	cents, err := parseCents(total, currency)
+	perUnit := minorUnitsPerUnit(currency)
+	if err != nil || perUnit%4 != 0 {
+		return nil
+	}
+
+	totals := []string{}
+	for ending := int64(0); ending < perUnit; ending += perUnit / 4 {
+		totals = append(totals, formatCents(cents-cents%perUnit+ending, currency))
+	}
+	return totals
+}
+
+// whether the receipt gives every optional field, a currency, at least one tag, and a category for every item
+func isComplete(receipt Receipt) bool {
+	if receipt.Currency == "" || len(receipt.Tags) == 0 {
+		return false
+	}
+	for _, item := range receipt.Items {
+		if item.Category == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// a copy of the given receipt purchased at the given date and time instead
+func purchasedAt(receipt Receipt, purchaseDate string, purchaseTime string) Receipt {
+	receipt.PurchaseDate, receipt.PurchaseTime = purchaseDate, purchaseTime
+	receipt.PurchasedAt, _ = parsePurchaseInstant(receipt)
+	return receipt
+}
+
+/*
+Parses the given purchase time, which may or may not include seconds
+*/
+func parsePurchaseTime(value string) (time.Time, error) {
+	var parsed time.Time
+	var err error
+	for _, format := range PURCHASE_TIME_FORMATS {
+		parsed, err = time.Parse(format, value)
+		if err == nil {
+			return parsed, nil
+		}
+	}
+	return parsed, err
+}
+
+/*
+Parses the purchase date and time of the given receipt together, as the instant they name in SERVER_TZ
+wall clock times that never happen there, such as those skipped when daylight saving starts, are an error
+*/
+func parsePurchaseInstant(receipt Receipt) (time.Time, error) {
+	value := receipt.PurchaseDate + " " + receipt.PurchaseTime
+	for _, format := range PURCHASE_TIME_FORMATS {
+		layout := PURCHASE_DATE_FORMAT + " " + format
+		instant, err := time.ParseInLocation(layout, value, config.ServerLocation)
+		if err != nil {
+			continue
+		}
+
+		// times in a daylight saving gap are moved to a real instant, which then reads differently
+		if instant.Format(layout) != value {
+			return instant, fmt.Errorf("%q does not happen in %s", value, config.ServerLocation)
+		}
+		return instant, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date such as \"2022-01-01\" and a time such as \"13:01\"", value)
+}
+
+// the value rounded to two decimal places, as raw points are given
+func roundToHundredths(value float64) float64 {
+	return math.Round(value*100) / 100
+}
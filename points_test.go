@@ -0,0 +1,756 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCalculatePointsWithMultipleItems(t *testing.T) {
+	resetState(t)
+
+	for _, test := range []struct {
+		name    string
+		receipt string
+		points  int
+	}{
+		{"target", TARGET_RECEIPT, TARGET_POINTS},
+		{"m&m corner market", MM_RECEIPT, MM_POINTS},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			receipt := testReceipt(t, test.receipt)
+			if points := CalculatePoints(receipt, currentRules(), ScoringFacts{}); points != test.points {
+				t.Errorf("scored %d, expected %d", points, test.points)
+			}
+		})
+	}
+}
+
+func TestItemsAreScoredAsValues(t *testing.T) {
+	resetState(t)
+
+	// a copy of the items scored on their own is worth the same as the receipt they came from
+	receipt := testReceipt(t, MM_RECEIPT)
+	copied := receipt
+	copied.Items = append([]Item{}, receipt.Items...)
+	copied.Items[0].ShortDescription = "Gatorade Zero"
+
+	if points := CalculatePoints(receipt, currentRules(), ScoringFacts{}); points != MM_POINTS {
+		t.Errorf("changing a copied item changed the original, which scored %d rather than %d", points, MM_POINTS)
+	}
+	if receipt.Items[0].ShortDescription != "Gatorade" {
+		t.Errorf("changing a copied item changed the original to %q", receipt.Items[0].ShortDescription)
+	}
+}
+
+// the points the named rule contributed to the breakdown, 0 if it awarded none
+func rulePoints(breakdown Breakdown, rule string) int {
+	points := 0
+	for _, contribution := range breakdown.Rules {
+		if contribution.Rule == rule {
+			points += contribution.Points
+		}
+	}
+	return points
+}
+
+// the given receipt with the given number of copies of its first item in place of its items
+func withItemCount(receipt Receipt, count int) Receipt {
+	items := make([]Item, count)
+	for i := range items {
+		items[i] = receipt.Items[0]
+	}
+	receipt.Items = items
+	return receipt
+}
+
+func TestLongReceiptBonus(t *testing.T) {
+	resetState(t)
+	receipt := testReceipt(t, MM_RECEIPT)
+
+	for _, test := range []struct {
+		name      string
+		threshold int
+		items     int
+		points    int
+	}{
+		{"disabled by default", 0, 30, 0},
+		{"below the threshold", 5, 4, 0},
+		{"at the threshold", 5, 5, 0},
+		{"above the threshold", 5, 6, LONG_RECEIPT_BONUS},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ruleset := defaultRules()
+			ruleset.LongReceiptThreshold = test.threshold
+			breakdown := CalculateBreakdown(withItemCount(receipt, test.items), ruleset, ScoringFacts{})
+			if points := rulePoints(breakdown, LONG_RECEIPT_RULE); points != test.points {
+				t.Errorf("%d items under a threshold of %d were awarded %d points, expected %d", test.items, test.threshold, points, test.points)
+			}
+		})
+	}
+}
+
+func TestAfternoonBonusWithAndWithoutSeconds(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	for _, purchaseTime := range []string{"14:33", "14:33:05"} {
+		t.Run(purchaseTime, func(t *testing.T) {
+			receipt := testReceipt(t, TARGET_RECEIPT)
+			receipt.PurchaseTime = purchaseTime
+			breakdown := CalculateBreakdown(normalizeReceipt(receipt), currentRules(), ScoringFacts{})
+			if points := rulePoints(breakdown, AFTERNOON_PURCHASE_RULE); points != BETWEEN_2PM_AND_4PM_BONUS {
+				t.Errorf("purchased at %s was awarded %d afternoon points, expected %d", purchaseTime, points, BETWEEN_2PM_AND_4PM_BONUS)
+			}
+
+			// and the time is accepted as it is processed
+			id := processTestReceipt(t, router, testReceiptJSON(t, receipt))
+			if points := testPoints(t, router, id); points != TARGET_POINTS+BETWEEN_2PM_AND_4PM_BONUS {
+				t.Errorf("purchased at %s scored %d, expected %d", purchaseTime, points, TARGET_POINTS+BETWEEN_2PM_AND_4PM_BONUS)
+			}
+		})
+	}
+}
+
+func TestParsePurchaseTime(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		valid bool
+	}{
+		{"14:33", true},
+		{"14:33:05", true},
+		{"14:33:5", false},
+		{"2:33pm", false},
+		{"25:00", false},
+	} {
+		if _, err := parsePurchaseTime(test.value); (err == nil) != test.valid {
+			t.Errorf("parsing %q gave error %v, expected it to be valid: %t", test.value, err, test.valid)
+		}
+	}
+}
+
+func TestBreakdownSummarizesManyItems(t *testing.T) {
+	resetState(t)
+	// every item qualifies for the item description rule, and the first has a description too long to list in full
+	receipt := testReceipt(t, TARGET_RECEIPT)
+	receipt.Items = []Item{{ShortDescription: "Emils Cheese Pizza", Price: "12.25"}}
+	receipt = withItemCount(receipt, 1000)
+	receipt.Items[0].ShortDescription = strings.Repeat("Emils Cheese Pizza ", 10) + "Large"
+
+	breakdown := CalculateBreakdown(receipt, currentRules(), ScoringFacts{})
+	lines := 0
+	for _, contribution := range breakdown.Rules {
+		if contribution.Rule == ITEM_DESCRIPTION_RULE {
+			lines++
+			if contribution.Detail != "1000 qualifying items" {
+				t.Errorf("the items were summarized as %q", contribution.Detail)
+			}
+		}
+		if len(contribution.Detail) > MAX_BREAKDOWN_DETAIL_LENGTH {
+			t.Errorf("the %s detail is %d long, more than %d", contribution.Rule, len(contribution.Detail), MAX_BREAKDOWN_DETAIL_LENGTH)
+		}
+	}
+	if lines != 1 {
+		t.Errorf("the items took %d lines of the breakdown, expected them summarized in 1", lines)
+	}
+	if len(breakdown.Items) != MAX_BREAKDOWN_ITEMS || breakdown.ItemsOmitted != 1000-MAX_BREAKDOWN_ITEMS {
+		t.Errorf("listed %d items and omitted %d, expected %d and %d", len(breakdown.Items), breakdown.ItemsOmitted, MAX_BREAKDOWN_ITEMS, 1000-MAX_BREAKDOWN_ITEMS)
+	}
+	for _, item := range breakdown.Items {
+		if len(item.Description) > MAX_BREAKDOWN_DETAIL_LENGTH {
+			t.Errorf("an item description is %d long, more than %d", len(item.Description), MAX_BREAKDOWN_DETAIL_LENGTH)
+		}
+	}
+}
+
+func TestBreakdownListsFewItems(t *testing.T) {
+	resetState(t)
+	receipt := testReceipt(t, TARGET_RECEIPT)
+	receipt.Items = []Item{{ShortDescription: "Emils Cheese Pizza", Price: "12.25"}}
+	receipt = withItemCount(receipt, BREAKDOWN_ITEM_DETAIL_LIMIT)
+
+	breakdown := CalculateBreakdown(receipt, currentRules(), ScoringFacts{})
+	lines := 0
+	for _, contribution := range breakdown.Rules {
+		if contribution.Rule == ITEM_DESCRIPTION_RULE {
+			lines++
+		}
+	}
+	if lines != BREAKDOWN_ITEM_DETAIL_LIMIT {
+		t.Errorf("%d qualifying items took %d lines, expected one each", BREAKDOWN_ITEM_DETAIL_LIMIT, lines)
+	}
+}
+
+func TestRoundAmountBonusesFollowTheCurrency(t *testing.T) {
+	resetState(t)
+
+	for _, test := range []struct {
+		currency string
+		total    string
+		round    int
+		quarter  int
+	}{
+		{"", "9.00", ROUND_DOLLAR_AMOUNT_BONUS, MULTIPLE_OF_0_POINT_25_BONUS},
+		{"USD", "9.25", 0, MULTIPLE_OF_0_POINT_25_BONUS},
+		{"USD", "9.01", 0, 0},
+		// every yen total is a whole amount, so neither bonus would mean anything
+		{"JPY", "1000", 0, 0},
+		{"JPY", "1001", 0, 0},
+		{"KRW", "900", 0, 0},
+		{"KWD", "9.000", ROUND_DOLLAR_AMOUNT_BONUS, MULTIPLE_OF_0_POINT_25_BONUS},
+		{"KWD", "9.250", 0, MULTIPLE_OF_0_POINT_25_BONUS},
+		{"KWD", "9.025", 0, 0},
+	} {
+		receipt := testReceipt(t, MM_RECEIPT)
+		receipt.Currency, receipt.Total = test.currency, test.total
+		breakdown := CalculateBreakdown(receipt, currentRules(), ScoringFacts{})
+		if points := rulePoints(breakdown, ROUND_DOLLAR_TOTAL_RULE); points != test.round {
+			t.Errorf("a %s total of %s was awarded %d round amount points, expected %d", test.currency, test.total, points, test.round)
+		}
+		if points := rulePoints(breakdown, QUARTER_MULTIPLE_TOTAL_RULE); points != test.quarter {
+			t.Errorf("a %s total of %s was awarded %d quarter points, expected %d", test.currency, test.total, points, test.quarter)
+		}
+	}
+}
+
+func TestFirstPurchaseOfDayBonus(t *testing.T) {
+	resetState(t)
+	replaceTestRules(testRules(t, `{"firstPurchaseOfDay": true}`))
+	router := newTestRouter(t)
+
+	first := processTestReceipt(t, router, TARGET_RECEIPT)
+	second := processTestReceipt(t, router, TARGET_RECEIPT)
+	otherDay := processTestReceipt(t, router, MM_RECEIPT)
+
+	for _, test := range []struct {
+		name   string
+		id     string
+		points int
+	}{
+		{"the first receipt of the day", first, TARGET_POINTS + FIRST_PURCHASE_OF_DAY_BONUS},
+		{"the second receipt of the same day", second, TARGET_POINTS},
+		{"the first receipt of another day", otherDay, MM_POINTS + FIRST_PURCHASE_OF_DAY_BONUS},
+	} {
+		if points := testPoints(t, router, test.id); points != test.points {
+			t.Errorf("%s scored %d, expected %d", test.name, points, test.points)
+		}
+	}
+}
+
+func TestFirstPurchaseOfDayIsOptional(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	if points := testPoints(t, router, processTestReceipt(t, router, TARGET_RECEIPT)); points != TARGET_POINTS {
+		t.Errorf("the first receipt of the day scored %d without the rule, expected %d", points, TARGET_POINTS)
+	}
+}
+
+func TestRetailerMultiplier(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"retailerMultipliers": {"target": 2.0, "M & M Corner Market": 1.5}}`)
+
+	for _, test := range []struct {
+		name     string
+		receipt  string
+		retailer string
+		points   int
+	}{
+		{"a matched retailer", TARGET_RECEIPT, "Target", 2 * TARGET_POINTS},
+		{"a matched retailer in another case", TARGET_RECEIPT, "TARGET", 2 * TARGET_POINTS},
+		{"an unmatched retailer", TARGET_RECEIPT, "Targets", TARGET_POINTS + 1},
+		// names are matched however they are punctuated, and fractional points are rounded
+		{"a matched retailer punctuated differently", MM_RECEIPT, "M&M Corner Market", 164},
+	} {
+		receipt := testReceipt(t, test.receipt)
+		receipt.Retailer = test.retailer
+		breakdown := CalculateBreakdown(receipt, ruleset, ScoringFacts{})
+		if breakdown.Points != test.points {
+			t.Errorf("%s scored %d, expected %d", test.name, breakdown.Points, test.points)
+		}
+
+		// the multiplier is applied to the sum of the other rules, and documented in the breakdown as the points it added
+		unmultiplied := CalculateBreakdown(receipt, currentRules(), ScoringFacts{}).Points
+		if added := rulePoints(breakdown, RETAILER_MULTIPLIER_RULE); added != test.points-unmultiplied {
+			t.Errorf("%s recorded %d points for the multiplier, expected %d", test.name, added, test.points-unmultiplied)
+		}
+	}
+}
+
+func TestMaxPointsCap(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"maxPoints": 100}`)
+
+	// the high scoring receipt is capped, with the points the cap took away in the breakdown
+	capped := CalculateBreakdown(testReceipt(t, MM_RECEIPT), ruleset, ScoringFacts{})
+	if capped.Points != 100 {
+		t.Errorf("a receipt worth %d scored %d under a cap of 100", MM_POINTS, capped.Points)
+	}
+	if taken := rulePoints(capped, MAX_POINTS_RULE); taken != 100-MM_POINTS {
+		t.Errorf("the cap recorded %d points, expected %d", taken, 100-MM_POINTS)
+	}
+
+	// the low scoring receipt is left alone, with no mention of the cap
+	uncapped := CalculateBreakdown(testReceipt(t, TARGET_RECEIPT), ruleset, ScoringFacts{})
+	if uncapped.Points != TARGET_POINTS {
+		t.Errorf("a receipt worth %d scored %d under a cap of 100", TARGET_POINTS, uncapped.Points)
+	}
+	for _, contribution := range uncapped.Rules {
+		if contribution.Rule == MAX_POINTS_RULE {
+			t.Errorf("the cap was recorded for a receipt under it: %+v", contribution)
+		}
+	}
+
+	// the cap is disabled by default
+	if points := CalculatePoints(testReceipt(t, MM_RECEIPT), defaultRules(), ScoringFacts{}); points != MM_POINTS {
+		t.Errorf("a receipt worth %d scored %d under the default rules", MM_POINTS, points)
+	}
+	expectInvalidRules(t, `{"maxPoints": -1}`, "maxPoints")
+}
+
+func TestQuarterBonusTiers(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"quarterBonuses": {"00": 40, "25": 10, "50": 20, "75": 30}}`)
+
+	for _, test := range []struct {
+		total string
+		tiers int
+		flat  int
+	}{
+		{"9.00", 40, MULTIPLE_OF_0_POINT_25_BONUS},
+		{"9.25", 10, MULTIPLE_OF_0_POINT_25_BONUS},
+		{"9.50", 20, MULTIPLE_OF_0_POINT_25_BONUS},
+		{"9.75", 30, MULTIPLE_OF_0_POINT_25_BONUS},
+		{"9.10", 0, 0},
+	} {
+		receipt := testReceipt(t, MM_RECEIPT)
+		receipt.Total = test.total
+		if points := rulePoints(CalculateBreakdown(receipt, ruleset, ScoringFacts{}), QUARTER_MULTIPLE_TOTAL_RULE); points != test.tiers {
+			t.Errorf("a total of %s was awarded %d quarter points under the tiers, expected %d", test.total, points, test.tiers)
+		}
+		if points := rulePoints(CalculateBreakdown(receipt, defaultRules(), ScoringFacts{}), QUARTER_MULTIPLE_TOTAL_RULE); points != test.flat {
+			t.Errorf("a total of %s was awarded %d quarter points by default, expected %d", test.total, points, test.flat)
+		}
+	}
+
+	// quarters left out of the tiers are awarded nothing
+	partial := testRules(t, `{"quarterBonuses": {"50": 20}}`)
+	receipt := testReceipt(t, MM_RECEIPT)
+	receipt.Total = "9.25"
+	if points := rulePoints(CalculateBreakdown(receipt, partial, ScoringFacts{}), QUARTER_MULTIPLE_TOTAL_RULE); points != 0 {
+		t.Errorf("a quarter left out of the tiers was awarded %d points", points)
+	}
+}
+
+func TestDescriptionLengthDivisor(t *testing.T) {
+	resetState(t)
+	receipt := testReceipt(t, TARGET_RECEIPT)
+
+	for _, test := range []struct {
+		rules  string
+		points int
+	}{
+		// "Emils Cheese Pizza" and "Klarbrunn 12-PK 12 FL OZ" are 18 and 24 characters
+		{`{}`, 3 + 3},
+		// "Knorr Creamy Chicken" and "Doritos Nacho Cheese" are both 20 characters
+		{`{"descriptionLengthDivisor": 5}`, 1 + 1},
+		{`{"descriptionLengthDivisor": 5, "itemPriceMultiplier": 0.5}`, 1 + 2},
+		// "Mountain Dew 12PK" is 17 characters
+		{`{"descriptionLengthDivisor": 17}`, 2},
+	} {
+		breakdown := CalculateBreakdown(receipt, testRules(t, test.rules), ScoringFacts{})
+		if points := rulePoints(breakdown, ITEM_DESCRIPTION_RULE); points != test.points {
+			t.Errorf("under %s the descriptions were awarded %d points, expected %d", test.rules, points, test.points)
+		}
+	}
+
+	expectInvalidRules(t, `{"descriptionLengthDivisor": 0}`, "descriptionLengthDivisor")
+}
+
+func TestHolidayBonus(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"holidays": ["2022-01-01", "12-25"]}`)
+
+	for _, test := range []struct {
+		purchaseDate string
+		points       int
+	}{
+		{"2022-01-01", HOLIDAY_BONUS},
+		{"2023-01-01", 0},
+		{"2022-12-25", HOLIDAY_BONUS},
+		{"2031-12-25", HOLIDAY_BONUS},
+		{"2022-01-02", 0},
+	} {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.PurchaseDate = test.purchaseDate
+		if points := rulePoints(CalculateBreakdown(receipt, ruleset, ScoringFacts{}), HOLIDAY_PURCHASE_RULE); points != test.points {
+			t.Errorf("purchased on %s was awarded %d holiday points, expected %d", test.purchaseDate, points, test.points)
+		}
+		// no holidays are configured by default
+		if points := rulePoints(CalculateBreakdown(receipt, defaultRules(), ScoringFacts{}), HOLIDAY_PURCHASE_RULE); points != 0 {
+			t.Errorf("purchased on %s was awarded %d holiday points by default", test.purchaseDate, points)
+		}
+	}
+
+	expectInvalidRules(t, `{"holidays": ["Christmas"]}`, "holidays")
+	expectInvalidRules(t, `{"holidays": ["2022-02-30"]}`, "holidays")
+}
+
+func TestScoreRounding(t *testing.T) {
+	for _, test := range []struct {
+		mode     string
+		retailer string
+		points   int
+	}{
+		{SCORE_ROUNDING_NONE, "Target", 28},
+		{SCORE_ROUNDING_NEAREST_5, "Target", 30},
+		{SCORE_ROUNDING_NEAREST_10, "Target", 30},
+		{SCORE_ROUNDING_NEAREST_5, "Targetxyz", 30},
+		{SCORE_ROUNDING_NEAREST_10, "Targetxyz", 30},
+		// scores half way between are rounded up
+		{SCORE_ROUNDING_NONE, "Tar", 25},
+		{SCORE_ROUNDING_NEAREST_5, "Tar", 25},
+		{SCORE_ROUNDING_NEAREST_10, "Tar", 30},
+	} {
+		resetState(t)
+		config.ScoreRounding = test.mode
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Retailer = test.retailer
+
+		breakdown := CalculateBreakdown(receipt, currentRules(), ScoringFacts{})
+		if breakdown.Points != test.points {
+			t.Errorf("%s scored %d rounded %s, expected %d", test.retailer, breakdown.Points, test.mode, test.points)
+		}
+		// the rounding is recorded in the breakdown as the points it added or took away, and only when it changed them
+		rounded := false
+		for _, contribution := range breakdown.Rules {
+			rounded = rounded || contribution.Rule == SCORE_ROUNDING_RULE
+		}
+		if unrounded := breakdown.Points - rulePoints(breakdown, SCORE_ROUNDING_RULE); rounded != (unrounded != test.points) {
+			t.Errorf("%s rounded %s from %d recorded the rounding %t", test.retailer, test.mode, unrounded, rounded)
+		}
+	}
+}
+
+func TestBigSpenderBonus(t *testing.T) {
+	resetState(t)
+
+	for _, test := range []struct {
+		rules  string
+		total  string
+		points int
+	}{
+		// disabled by default
+		{`{}`, "250.00", 0},
+		{`{"bigSpenderThreshold": 10000}`, "99.99", 0},
+		{`{"bigSpenderThreshold": 10000}`, "100.00", 0},
+		{`{"bigSpenderThreshold": 10000}`, "100.99", 0},
+		{`{"bigSpenderThreshold": 10000}`, "101.00", 1},
+		{`{"bigSpenderThreshold": 10000}`, "250.75", 150},
+		{`{"bigSpenderThreshold": 10000, "bigSpenderPointsPerUnit": 3}`, "250.75", 450},
+	} {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Total = test.total
+		if points := rulePoints(CalculateBreakdown(receipt, testRules(t, test.rules), ScoringFacts{}), BIG_SPENDER_RULE); points != test.points {
+			t.Errorf("a total of %s under %s was awarded %d big spender points, expected %d", test.total, test.rules, points, test.points)
+		}
+	}
+}
+
+func TestItemBreakdownSumsToTheItemRule(t *testing.T) {
+	resetState(t)
+	breakdown := CalculateBreakdown(testReceipt(t, TARGET_RECEIPT), currentRules(), ScoringFacts{})
+
+	expected := []ItemContribution{
+		{Description: "Mountain Dew 12PK", Qualified: false, Points: 0},
+		{Description: "Emils Cheese Pizza", Qualified: true, Points: 3},
+		{Description: "Knorr Creamy Chicken", Qualified: false, Points: 0},
+		{Description: "Doritos Nacho Cheese", Qualified: false, Points: 0},
+		{Description: "Klarbrunn 12-PK 12 FL OZ", Qualified: true, Points: 3},
+	}
+	if len(breakdown.Items) != len(expected) || breakdown.ItemsOmitted != 0 {
+		t.Fatalf("the breakdown listed %+v and omitted %d, expected %+v", breakdown.Items, breakdown.ItemsOmitted, expected)
+	}
+	sum := 0
+	for i, item := range breakdown.Items {
+		if item != expected[i] {
+			t.Errorf("item %d was broken down as %+v, expected %+v", i, item, expected[i])
+		}
+		sum += item.Points
+	}
+	if total := rulePoints(breakdown, ITEM_DESCRIPTION_RULE); sum != total {
+		t.Errorf("the items were awarded %d points between them, but the rule %d", sum, total)
+	}
+}
+
+func TestItemBreakdownIsBounded(t *testing.T) {
+	resetState(t)
+	receipt := withItemCount(testReceipt(t, TARGET_RECEIPT), MAX_BREAKDOWN_ITEMS+50)
+
+	breakdown := CalculateBreakdown(receipt, currentRules(), ScoringFacts{})
+	if len(breakdown.Items) != MAX_BREAKDOWN_ITEMS || breakdown.ItemsOmitted != 50 {
+		t.Errorf("the breakdown of %d items listed %d and omitted %d, expected %d and 50", len(receipt.Items), len(breakdown.Items), breakdown.ItemsOmitted, MAX_BREAKDOWN_ITEMS)
+	}
+}
+
+func TestEvenCentsBonus(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"evenCentsBonus": 7}`)
+
+	for _, test := range []struct {
+		currency string
+		total    string
+		points   int
+	}{
+		{"", "3.02", 7},
+		{"", "3.03", 0},
+		{"", "3.00", 7},
+		{"", "3.10", 7},
+		{"", "3.99", 0},
+		{"KWD", "3.002", 7},
+		// every yen total has no cents at all, so none is awarded the bonus
+		{"JPY", "302", 0},
+	} {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Currency, receipt.Total = test.currency, test.total
+		if points := rulePoints(CalculateBreakdown(receipt, ruleset, ScoringFacts{}), EVEN_CENTS_TOTAL_RULE); points != test.points {
+			t.Errorf("a %s total of %s was awarded %d even cents points, expected %d", test.currency, test.total, points, test.points)
+		}
+		if points := rulePoints(CalculateBreakdown(receipt, defaultRules(), ScoringFacts{}), EVEN_CENTS_TOTAL_RULE); points != 0 {
+			t.Errorf("a %s total of %s was awarded %d even cents points by default", test.currency, test.total, points)
+		}
+	}
+}
+
+func TestEvenItemCountBonus(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"evenItemCountBonus": 4}`)
+	expectInvalidRules(t, `{"evenItemCountBonus": -1}`, "evenItemCountBonus")
+
+	for count, points := range map[int]int{1: 0, 2: 4, 3: 0, 4: 4, 7: 0} {
+		receipt := withItemCount(testReceipt(t, TARGET_RECEIPT), count)
+		if awarded := rulePoints(CalculateBreakdown(receipt, ruleset, ScoringFacts{}), EVEN_ITEM_COUNT_RULE); awarded != points {
+			t.Errorf("%d items were awarded %d even item count points, expected %d", count, awarded, points)
+		}
+		if awarded := rulePoints(CalculateBreakdown(receipt, defaultRules(), ScoringFacts{}), EVEN_ITEM_COUNT_RULE); awarded != 0 {
+			t.Errorf("%d items were awarded %d even item count points by default", count, awarded)
+		}
+	}
+}
+
+func TestEveryTwoItemsMinimum(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"everyTwoItemsMinimum": 4}`)
+	expectInvalidRules(t, `{"everyTwoItemsMinimum": -1}`, "everyTwoItemsMinimum")
+
+	for count, points := range map[int]int{2: 0, 3: 0, 4: 2 * VALUE_PER_TWO_ITEMS, 5: 2 * VALUE_PER_TWO_ITEMS, 6: 3 * VALUE_PER_TWO_ITEMS} {
+		receipt := withItemCount(testReceipt(t, TARGET_RECEIPT), count)
+		if awarded := rulePoints(CalculateBreakdown(receipt, ruleset, ScoringFacts{}), EVERY_TWO_ITEMS_RULE); awarded != points {
+			t.Errorf("%d items were awarded %d points for every two items, expected %d", count, awarded, points)
+		}
+		// the default minimum of 0 awards every receipt as before
+		if awarded := rulePoints(CalculateBreakdown(receipt, defaultRules(), ScoringFacts{}), EVERY_TWO_ITEMS_RULE); awarded != (count/2)*VALUE_PER_TWO_ITEMS {
+			t.Errorf("%d items were awarded %d points for every two items by default", count, awarded)
+		}
+	}
+}
+
+func TestRetailerNameCap(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"retailerNameCap": 50}`)
+	expectInvalidRules(t, `{"retailerNameCap": -1}`, "retailerNameCap")
+
+	for length, points := range map[int]int{6: 6, 50: 50, 51: 50, 5000: 50} {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Retailer = strings.Repeat("R", length)
+		breakdown := CalculateBreakdown(receipt, ruleset, ScoringFacts{})
+		if awarded := rulePoints(breakdown, RETAILER_NAME_RULE); awarded != points {
+			t.Errorf("a retailer name of %d characters was awarded %d points, expected %d", length, awarded, points)
+		}
+		for _, contribution := range breakdown.Rules {
+			if capped := strings.Contains(contribution.Detail, "capped"); contribution.Rule == RETAILER_NAME_RULE && capped != (length > 50) {
+				t.Errorf("a retailer name of %d characters was detailed as %q", length, contribution.Detail)
+			}
+		}
+		if awarded := rulePoints(CalculateBreakdown(receipt, defaultRules(), ScoringFacts{}), RETAILER_NAME_RULE); awarded != length {
+			t.Errorf("a retailer name of %d characters was awarded %d points by default, expected it uncapped", length, awarded)
+		}
+	}
+}
+
+func TestExcludeFreeItems(t *testing.T) {
+	resetState(t)
+	receipt := testReceipt(t, TARGET_RECEIPT)
+	receipt.Items = append(receipt.Items, Item{ShortDescription: "Free Sample", Price: "0.00"})
+	counted := len(receipt.Items)
+
+	for _, test := range []struct {
+		rules  string
+		points int
+	}{
+		{`{}`, (counted / 2) * VALUE_PER_TWO_ITEMS},
+		{`{"excludeFreeItems": false}`, (counted / 2) * VALUE_PER_TWO_ITEMS},
+		{`{"excludeFreeItems": true}`, ((counted - 1) / 2) * VALUE_PER_TWO_ITEMS},
+	} {
+		breakdown := CalculateBreakdown(receipt, testRules(t, test.rules), ScoringFacts{})
+		if awarded := rulePoints(breakdown, EVERY_TWO_ITEMS_RULE); awarded != test.points {
+			t.Errorf("%d items with one free under %s were awarded %d points for every two items, expected %d", counted, test.rules, awarded, test.points)
+		}
+		// the free item is still shown, whether or not it is counted
+		if len(breakdown.Items) != counted {
+			t.Errorf("the breakdown under %s showed %d items, expected %d", test.rules, len(breakdown.Items), counted)
+		}
+	}
+}
+
+func TestLastDayOfMonthBonus(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"lastDayOfMonthBonus": 12}`)
+	expectInvalidRules(t, `{"lastDayOfMonthBonus": -1}`, "lastDayOfMonthBonus")
+
+	for purchaseDate, points := range map[string]int{
+		"2022-01-31": 12,
+		"2022-01-30": 0,
+		"2023-02-28": 12,
+		"2024-02-28": 0,
+		"2024-02-29": 12,
+		"2022-04-30": 12,
+		"2022-04-29": 0,
+		"2022-12-31": 12,
+		"2023-01-01": 0,
+	} {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.PurchaseDate = purchaseDate
+		receipt.PurchasedAt, _ = parsePurchaseInstant(receipt)
+		if awarded := rulePoints(CalculateBreakdown(receipt, ruleset, ScoringFacts{}), LAST_DAY_OF_MONTH_RULE); awarded != points {
+			t.Errorf("a purchase on %s was awarded %d last day of the month points, expected %d", purchaseDate, awarded, points)
+		}
+		if awarded := rulePoints(CalculateBreakdown(receipt, defaultRules(), ScoringFacts{}), LAST_DAY_OF_MONTH_RULE); awarded != 0 {
+			t.Errorf("a purchase on %s was awarded %d last day of the month points by default", purchaseDate, awarded)
+		}
+	}
+}
+
+func TestCategoryMultipliers(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"categoryMultipliers": {"frozen": 2, "Produce": 0.5}}`)
+	expectInvalidRules(t, `{"categoryMultipliers": {"frozen": 0}}`, "frozen")
+	expectInvalidRules(t, `{"categoryMultipliers": {"frozen": 2, "FROZEN": 3}}`, "more than one")
+
+	// a price of 12.25 is worth 3 points uncategorized, 12.25 times 0.2 rounded up
+	for category, points := range map[string]int{"": 3, "frozen": 6, "FROZEN": 6, "produce": 2} {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Items = []Item{{ShortDescription: "Emils Cheese Pizza", Price: "12.25", Category: category}}
+		if awarded := rulePoints(CalculateBreakdown(receipt, ruleset, ScoringFacts{}), ITEM_DESCRIPTION_RULE); awarded != points {
+			t.Errorf("an item in the category %q was awarded %d points, expected %d", category, awarded, points)
+		}
+	}
+}
+
+func TestPriceBands(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"priceBands": [{"under": 500, "multiplier": 0.5}, {"under": 2000, "multiplier": 0.25}]}`)
+	expectInvalidRules(t, `{"priceBands": [{"under": 2000, "multiplier": 0.25}, {"under": 500, "multiplier": 0.5}]}`, "cheapest first")
+	expectInvalidRules(t, `{"priceBands": [{"under": 500, "multiplier": -0.5}]}`, "must not be negative")
+
+	for price, points := range map[string]int{
+		"3.00":  2, // 3.00 times 0.5 rounded up
+		"4.99":  3, // 4.99 times 0.5 rounded up
+		"5.00":  2, // 5.00 times 0.25 rounded up
+		"19.99": 5, // 19.99 times 0.25 rounded up
+		"20.00": 4, // 20.00 times the flat 0.2
+		"30.00": 6, // 30.00 times the flat 0.2
+	} {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Items = []Item{{ShortDescription: "Emils Cheese Pizza", Price: price}}
+		if awarded := rulePoints(CalculateBreakdown(receipt, ruleset, ScoringFacts{}), ITEM_DESCRIPTION_RULE); awarded != points {
+			t.Errorf("an item priced %s was awarded %d points, expected %d", price, awarded, points)
+		}
+	}
+}
+
+func TestCompletenessBonus(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"completenessBonus": 8}`)
+	expectInvalidRules(t, `{"completenessBonus": -1}`, "completenessBonus")
+	complete := func() Receipt {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Currency, receipt.Tags = "USD", []string{"groceries"}
+		for i := range receipt.Items {
+			receipt.Items[i].Category = "food"
+		}
+		return receipt
+	}
+
+	for _, test := range []struct {
+		name   string
+		change func(receipt *Receipt)
+		points int
+	}{
+		{"complete", func(receipt *Receipt) {}, 8},
+		{"without a currency", func(receipt *Receipt) { receipt.Currency = "" }, 0},
+		{"without tags", func(receipt *Receipt) { receipt.Tags = nil }, 0},
+		{"with an uncategorized item", func(receipt *Receipt) { receipt.Items[2].Category = "" }, 0},
+	} {
+		receipt := complete()
+		test.change(&receipt)
+		if awarded := rulePoints(CalculateBreakdown(receipt, ruleset, ScoringFacts{}), COMPLETENESS_RULE); awarded != test.points {
+			t.Errorf("a receipt %s was awarded %d completeness points, expected %d", test.name, awarded, test.points)
+		}
+		if awarded := rulePoints(CalculateBreakdown(receipt, defaultRules(), ScoringFacts{}), COMPLETENESS_RULE); awarded != 0 {
+			t.Errorf("a receipt %s was awarded %d completeness points by default", test.name, awarded)
+		}
+	}
+}
+
+func TestEveryTwoItemsCap(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"everyTwoItemsCap": 20}`)
+	expectInvalidRules(t, `{"everyTwoItemsCap": -1}`, "everyTwoItemsCap")
+
+	for count, points := range map[int]int{2: 5, 8: 20, 9: 20, 10: 20, 100: 20} {
+		receipt := withItemCount(testReceipt(t, TARGET_RECEIPT), count)
+		breakdown := CalculateBreakdown(receipt, ruleset, ScoringFacts{})
+		if awarded := rulePoints(breakdown, EVERY_TWO_ITEMS_RULE); awarded != points {
+			t.Errorf("%d items were awarded %d points for every two items, expected %d", count, awarded, points)
+		}
+		for _, contribution := range breakdown.Rules {
+			if capped := strings.Contains(contribution.Detail, "capped"); contribution.Rule == EVERY_TWO_ITEMS_RULE && capped != ((count/2)*VALUE_PER_TWO_ITEMS > 20) {
+				t.Errorf("%d items were detailed as %q", count, contribution.Detail)
+			}
+		}
+		if awarded := rulePoints(CalculateBreakdown(receipt, defaultRules(), ScoringFacts{}), EVERY_TWO_ITEMS_RULE); awarded != (count/2)*VALUE_PER_TWO_ITEMS {
+			t.Errorf("%d items were awarded %d points for every two items by default, expected them uncapped", count, awarded)
+		}
+	}
+}
+
+func TestMaxPointsKeepsEvenDayBonuses(t *testing.T) {
+	resetState(t)
+	// the afternoon window, a round total, and a quarter total are missed on any day
+	missed := BETWEEN_2PM_AND_4PM_BONUS + ROUND_DOLLAR_AMOUNT_BONUS + MULTIPLE_OF_0_POINT_25_BONUS
+
+	for _, test := range []struct {
+		name         string
+		rules        string
+		purchaseDate string
+		facts        ScoringFacts
+		more         int
+	}{
+		// the odd day before is worth more than an ordinary even day
+		{"an ordinary even day", `{}`, "2022-01-02", ScoringFacts{}, missed + ODD_DAY_BONUS},
+		// a holiday or the last day of the month on an even day is worth more than the odd day before
+		{"an even day holiday", `{"holidays": ["01-02"]}`, "2022-01-02", ScoringFacts{}, missed},
+		{"the last day of april", `{"lastDayOfMonthBonus": 12}`, "2022-04-30", ScoringFacts{}, missed},
+		// the receipt is not assumed to be the first stored on the day before
+		{"the first purchase of the day", `{"firstPurchaseOfDay": true}`, "2022-01-02", ScoringFacts{FirstOnDate: true}, missed + ODD_DAY_BONUS - FIRST_PURCHASE_OF_DAY_BONUS},
+	} {
+		ruleset := testRules(t, test.rules)
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.PurchaseDate = test.purchaseDate
+		receipt.PurchasedAt, _ = parsePurchaseInstant(receipt)
+		points := CalculatePoints(receipt, ruleset, test.facts)
+		if maxPoints := CalculateMaxPoints(receipt, ruleset, test.facts); maxPoints != points+test.more {
+			t.Errorf("%s worth %d points could be worth at most %d, expected %d", test.name, points, maxPoints, points+test.more)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// content type of RFC 7807 problem details, sent to clients that accept it
+const MIME_PROBLEM_JSON = "application/problem+json"
+
+// the kinds of errors the endpoints respond with, used as the type of a problem document
+const INVALID_RECEIPT_PROBLEM = "/problems/invalid-receipt"
+const MALFORMED_BODY_PROBLEM = "/problems/malformed-body"
+const UNSUPPORTED_MEDIA_TYPE_PROBLEM = "/problems/unsupported-media-type"
+const RECEIPT_NOT_FOUND_PROBLEM = "/problems/receipt-not-found"
+const INVALID_QR_CODE_SIZE_PROBLEM = "/problems/invalid-qr-code-size"
+const INVALID_REQUEST_PROBLEM = "/problems/invalid-request"
+const INVALID_PAGINATION_PROBLEM = "/problems/invalid-pagination"
+const MISSING_QUERY_PROBLEM = "/problems/missing-query"
+const UNKNOWN_FORMAT_PROBLEM = "/problems/unknown-format"
+const UNKNOWN_RULESET_PROBLEM = "/problems/unknown-ruleset"
+const STORE_UNAVAILABLE_PROBLEM = "/problems/store-unavailable"
+const MAINTENANCE_PROBLEM = "/problems/maintenance"
+const ADMIN_DISABLED_PROBLEM = "/problems/admin-disabled"
+const UNAUTHORIZED_PROBLEM = "/problems/unauthorized"
+const UNKNOWN_FIELD_PROBLEM = "/problems/unknown-field"
+const INTERNAL_ERROR_PROBLEM = "/problems/internal-error"
+const SCORING_BUSY_PROBLEM = "/problems/scoring-busy"
+const INVALID_RULES_PROBLEM = "/problems/invalid-rules"
+const RECEIPT_CONFLICT_PROBLEM = "/problems/receipt-conflict"
+const TOO_MANY_REQUESTS_PROBLEM = "/problems/too-many-requests"
+const DUPLICATE_SUBMISSION_PROBLEM = "/problems/duplicate-submission"
+const BODY_TOO_LARGE_PROBLEM = "/problems/body-too-large"
+
+// response for aborted endpoints when the client accepts application/problem+json
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance"`
+}
+
+/*
+Aborts the request with the given status
+responds with a problem document if the client accepts one, otherwise with the plain description
+*/
+func abortWithError(context *gin.Context, status int, problemType string, description string) {
+	if context.NegotiateFormat(gin.MIMEJSON, MIME_PROBLEM_JSON) != MIME_PROBLEM_JSON {
+		context.AbortWithStatusJSON(status, Description{Description: description})
+		return
+	}
+
+	// the json renderer keeps a content type that is already set
+	context.Header("Content-Type", MIME_PROBLEM_JSON)
+	context.AbortWithStatusJSON(status, Problem{
+		Type:     problemType,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   description,
+		Instance: context.Request.URL.Path,
+	})
+}
+
+/*
+Aborts the request after the store failed or ran out of time
+with 404 error for ErrNotFound, with 409 error for ErrConflict, and with 503 error for anything else
+*/
+func abortWithStoreError(context *gin.Context, err error) {
+	context.Error(err)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		abortWithError(context, http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM, "No receipt found for that id")
+	case errors.Is(err, ErrConflict):
+		abortWithError(context, http.StatusConflict, RECEIPT_CONFLICT_PROBLEM, "The receipt conflicts with one already stored")
+	case errors.Is(err, ErrScoringBusy):
+		abortWithError(context, http.StatusServiceUnavailable, SCORING_BUSY_PROBLEM, "Too many receipts are being scored at once, please try again later")
+	default:
+		abortWithError(context, http.StatusServiceUnavailable, STORE_UNAVAILABLE_PROBLEM, "The receipt store is unavailable")
+	}
+}
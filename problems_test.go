@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestProblemDocuments(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	for _, test := range []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		status      int
+		problemType string
+	}{
+		{"not found", http.MethodGet, "/receipts/missing/points", "", http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM},
+		{"invalid receipt", http.MethodPost, "/receipts/process", `{"retailer":"Target"}`, http.StatusBadRequest, INVALID_RECEIPT_PROBLEM},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serveRequest(router, test.method, test.path, test.body, "Accept", MIME_PROBLEM_JSON)
+			if recorder.Code != test.status {
+				t.Fatalf("responded %d, expected %d", recorder.Code, test.status)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != MIME_PROBLEM_JSON {
+				t.Errorf("responded as %q, expected %q", contentType, MIME_PROBLEM_JSON)
+			}
+
+			problem := decodeTestJSON[Problem](t, recorder)
+			expected := Problem{Type: test.problemType, Title: http.StatusText(test.status), Status: test.status, Detail: problem.Detail, Instance: test.path}
+			if problem != expected || problem.Detail == "" {
+				t.Errorf("responded with %+v, expected %+v with a detail", problem, expected)
+			}
+		})
+	}
+}
+
+func TestPlainErrorsWithoutProblemJSON(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	// clients that do not ask for a problem document keep getting the plain description
+	recorder := serveRequest(router, http.MethodGet, "/receipts/missing/points", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("responded %d, expected %d", recorder.Code, http.StatusNotFound)
+	}
+	if description := decodeTestJSON[Description](t, recorder); description.Description != "No receipt found for that id" {
+		t.Errorf("responded with %+v", description)
+	}
+}
+
+// a store whose reads fail with the given error
+type erroringStore struct {
+	*MemoryStore
+	err error
+}
+
+func (store erroringStore) Get(ctx context.Context, id string) (StoredReceipt, bool, error) {
+	return StoredReceipt{}, false, fmt.Errorf("getting %s: %w", id, store.err)
+}
+
+func TestStoreErrorsMapToStatuses(t *testing.T) {
+	for _, test := range []struct {
+		err         error
+		status      int
+		problemType string
+	}{
+		{ErrNotFound, http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM},
+		{ErrConflict, http.StatusConflict, RECEIPT_CONFLICT_PROBLEM},
+		{ErrUnavailable, http.StatusServiceUnavailable, STORE_UNAVAILABLE_PROBLEM},
+		// anything the store does not say otherwise of is taken for the backend failing
+		{errors.New("disk on fire"), http.StatusServiceUnavailable, STORE_UNAVAILABLE_PROBLEM},
+	} {
+		t.Run(test.err.Error(), func(t *testing.T) {
+			resetState(t)
+			receipts = erroringStore{MemoryStore: NewMemoryStore(), err: test.err}
+			router := newTestRouter(t)
+
+			recorder := serveRequest(router, http.MethodGet, "/receipts/any/points", "", "Accept", MIME_PROBLEM_JSON)
+			if recorder.Code != test.status {
+				t.Fatalf("responded %d, expected %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if problem := decodeTestJSON[Problem](t, recorder); problem.Type != test.problemType {
+				t.Errorf("responded with problem type %q, expected %q", problem.Type, test.problemType)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the fields of a stored receipt that can be asked for by name, and how to read each
+var RECEIPT_FIELDS = map[string]func(StoredReceipt) any{
+	"id":           func(stored StoredReceipt) any { return stored.Id },
+	"retailer":     func(stored StoredReceipt) any { return stored.Retailer },
+	"purchaseDate": func(stored StoredReceipt) any { return stored.PurchaseDate },
+	"purchaseTime": func(stored StoredReceipt) any { return stored.PurchaseTime },
+	"total":        func(stored StoredReceipt) any { return stored.Total },
+	"items":        func(stored StoredReceipt) any { return stored.Items },
+	"currency":     func(stored StoredReceipt) any { return stored.Currency },
+	"tags":         func(stored StoredReceipt) any { return stored.Tags },
+	"createdAt":    func(stored StoredReceipt) any { return stored.CreatedAt },
+}
+
+/*
+Reads the fields query param, a comma separated list of the receipt fields to respond with
+returns no fields if it is not given, for every field, and aborts with 400 error and returns false if any is unknown
+*/
+func projection(context *gin.Context) (fields []string, ok bool) {
+	for _, field := range strings.Split(context.Query("fields"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if _, known := RECEIPT_FIELDS[field]; !known {
+			known := make([]string, 0, len(RECEIPT_FIELDS))
+			for name := range RECEIPT_FIELDS {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			abortWithError(context, http.StatusBadRequest, UNKNOWN_FIELD_PROBLEM, "Unknown field "+field+", the fields are "+strings.Join(known, ", "))
+			return nil, false
+		}
+		fields = append(fields, field)
+	}
+	return fields, true
+}
+
+// the given fields of each receipt, keyed by field name
+func project(receipts []StoredReceipt, fields []string) []map[string]any {
+	projected := make([]map[string]any, len(receipts))
+	for i, stored := range receipts {
+		projected[i] = make(map[string]any, len(fields))
+		for _, field := range fields {
+			projected[i][field] = RECEIPT_FIELDS[field](stored)
+		}
+	}
+	return projected
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestListProjectsRequestedFields(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts?fields=id,+retailer+,total", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("listing a subset of fields responded %d: %s", recorder.Code, recorder.Body)
+	}
+	page := decodeTestJSON[ReceiptPage[map[string]any]](t, recorder)
+	if len(page.Receipts) != 1 || page.Total != 1 {
+		t.Fatalf("listed %+v, expected the one receipt", page)
+	}
+
+	expected := map[string]any{"id": id, "retailer": "Target", "total": "35.35"}
+	if !reflect.DeepEqual(page.Receipts[0], expected) {
+		t.Errorf("listed %v, expected only %v", page.Receipts[0], expected)
+	}
+}
+
+func TestListDefaultsToEveryField(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	processTestReceipt(t, router, TARGET_RECEIPT)
+
+	page := decodeTestJSON[ReceiptPage[map[string]any]](t, serveRequest(router, http.MethodGet, "/receipts", ""))
+	fields := []string{}
+	for field := range page.Receipts[0] {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range []string{"createdAt", "id", "items", "purchaseDate", "purchaseTime", "retailer", "total"} {
+		if i := sort.SearchStrings(fields, field); i == len(fields) || fields[i] != field {
+			t.Errorf("listed the fields %v without %s", fields, field)
+		}
+	}
+}
+
+func TestListRejectsUnknownFields(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts?fields=id,password", "", "Accept", MIME_PROBLEM_JSON)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("an unknown field responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+	if problem := decodeTestJSON[Problem](t, recorder); problem.Type != UNKNOWN_FIELD_PROBLEM {
+		t.Errorf("an unknown field was a %s problem, expected %s", problem.Type, UNKNOWN_FIELD_PROBLEM)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// default number of times a store write is attempted, and how long to wait before the first retry, doubling after each
+const STORE_ATTEMPTS = 3
+const STORE_RETRY_BACKOFF = 50 * time.Millisecond
+
+// a store whose writes are retried when they fail with ErrUnavailable, reads go straight through
+type RetryingStore struct {
+	Store
+	attempts int
+	backoff  time.Duration
+}
+
+// wraps the store so its writes are attempted up to the given number of times, waiting backoff, then twice as long, between them
+func NewRetryingStore(store Store, attempts int, backoff time.Duration) *RetryingStore {
+	return &RetryingStore{Store: store, attempts: attempts, backoff: backoff}
+}
+
+func (store *RetryingStore) Save(ctx context.Context, id string, receipt Receipt) error {
+	return store.retry(ctx, func() error { return store.Store.Save(ctx, id, receipt) })
+}
+
+func (store *RetryingStore) Restore(ctx context.Context, stored StoredReceipt) error {
+	return store.retry(ctx, func() error { return store.Store.Restore(ctx, stored) })
+}
+
+func (store *RetryingStore) Delete(ctx context.Context, id string) (found bool, err error) {
+	err = store.retry(ctx, func() error {
+		found, err = store.Store.Delete(ctx, id)
+		return err
+	})
+	return found, err
+}
+
+/*
+Calls the operation until it succeeds, fails with anything but ErrUnavailable, or runs out of attempts
+gives up early, with the context's error, if the context is done while waiting to retry
+*/
+func (store *RetryingStore) retry(ctx context.Context, operation func() error) error {
+	wait := store.backoff
+	err := operation()
+	for attempt := 1; attempt < store.attempts && errors.Is(err, ErrUnavailable); attempt++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+		err = operation()
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// a store whose saves fail with the given error a number of times before they succeed, counting every attempt
+type flakyStore struct {
+	*MemoryStore
+	failures int
+	err      error
+	attempts *int
+}
+
+func (store flakyStore) Save(ctx context.Context, id string, receipt Receipt) error {
+	*store.attempts++
+	if *store.attempts <= store.failures {
+		return store.err
+	}
+	return store.MemoryStore.Save(ctx, id, receipt)
+}
+
+func TestTransientStoreErrorsAreRetried(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		failures     int
+		err          error
+		maxAttempts  int
+		status       int
+		tookAttempts int
+	}{
+		{"failing twice then succeeding", 2, ErrUnavailable, 3, http.StatusOK, 3},
+		{"failing more often than it is attempted", 5, ErrUnavailable, 3, http.StatusServiceUnavailable, 3},
+		{"failing with an error that is not transient", 1, ErrConflict, 3, http.StatusConflict, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resetState(t)
+			router := newTestRouter(t)
+			attempts := 0
+			receipts = NewRetryingStore(flakyStore{MemoryStore: NewMemoryStore(), failures: test.failures, err: test.err, attempts: &attempts}, test.maxAttempts, time.Millisecond)
+
+			recorder := serveRequest(router, http.MethodPost, "/receipts/process", TARGET_RECEIPT)
+			if recorder.Code != test.status {
+				t.Errorf("responded %d, expected %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if attempts != test.tookAttempts {
+				t.Errorf("the save was attempted %d times, expected %d", attempts, test.tookAttempts)
+			}
+			if test.status == http.StatusOK {
+				if points := testPoints(t, router, decodeTestJSON[Id](t, recorder).Id); points != TARGET_POINTS {
+					t.Errorf("the retried receipt scored %d, expected %d", points, TARGET_POINTS)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryGivesUpWhenTheRequestDoes(t *testing.T) {
+	attempts := 0
+	store := NewRetryingStore(flakyStore{MemoryStore: NewMemoryStore(), failures: 5, err: ErrUnavailable, attempts: &attempts}, 5, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := store.Save(ctx, "a", testReceipt(t, TARGET_RECEIPT)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("saving gave %v, expected the deadline to be exceeded while waiting to retry", err)
+	}
+	if attempts != 1 {
+		t.Errorf("the save was attempted %d times, expected once before the request gave up", attempts)
+	}
+}
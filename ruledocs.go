@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// response of /rules endpoint, one rule currently awarding points, as named in breakdowns
+type RuleDescription struct {
+	Name string `json:"name"`
+	// the points the rule awards, or awards per unit it counts, 0 for rules that adjust the points the others awarded
+	Points      int    `json:"points"`
+	Description string `json:"description"`
+}
+
+/*
+Describes the rules receipts are currently scored against
+responds with the name, points, and a description of every active rule, in the order they are applied
+*/
+func getRules(context *gin.Context) {
+	// return the rules as a json array with a 200 status
+	context.JSON(http.StatusOK, describeRules(currentRules()))
+}
+
+// describes every rule the given rules award points by, as configured, leaving out the optional rules that are disabled
+func describeRules(rules Rules) []RuleDescription {
+	described := []RuleDescription{}
+	describe := func(name string, points int, description string, args ...any) {
+		described = append(described, RuleDescription{Name: name, Points: points, Description: fmt.Sprintf(description, args...)})
+	}
+
+	if rules.RetailerNameCap > 0 {
+		describe(RETAILER_NAME_RULE, VALUE_PER_ALPHANUMERIC_CHAR, "%d point for every alphanumeric character in the retailer name, up to %d points", VALUE_PER_ALPHANUMERIC_CHAR, rules.RetailerNameCap)
+	} else {
+		describe(RETAILER_NAME_RULE, VALUE_PER_ALPHANUMERIC_CHAR, "%d point for every alphanumeric character in the retailer name", VALUE_PER_ALPHANUMERIC_CHAR)
+	}
+	counted := "items"
+	if rules.ExcludeFreeItems {
+		counted = "items not priced at zero"
+	}
+	everyTwoItems := fmt.Sprintf("%d points for every two %s", VALUE_PER_TWO_ITEMS, counted)
+	if rules.EveryTwoItemsMinimum > 0 {
+		everyTwoItems += fmt.Sprintf(", on receipts with at least %d of them", rules.EveryTwoItemsMinimum)
+	}
+	if rules.EveryTwoItemsCap > 0 {
+		everyTwoItems += fmt.Sprintf(", up to %d points", rules.EveryTwoItemsCap)
+	}
+	describe(EVERY_TWO_ITEMS_RULE, VALUE_PER_TWO_ITEMS, "%s", everyTwoItems)
+
+	describe(ROUND_DOLLAR_TOTAL_RULE, ROUND_DOLLAR_AMOUNT_BONUS, "%d points if the total is a round dollar amount with no cents", ROUND_DOLLAR_AMOUNT_BONUS)
+	if rules.QuarterBonuses != nil {
+		quarters := make([]string, 0, len(rules.QuarterBonuses))
+		for cents := range rules.QuarterBonuses {
+			quarters = append(quarters, cents)
+		}
+		sort.Strings(quarters)
+		endings := make([]string, len(quarters))
+		for i, cents := range quarters {
+			endings[i] = fmt.Sprintf("%d points for .%s", rules.QuarterBonuses[cents], cents)
+		}
+		describe(QUARTER_MULTIPLE_TOTAL_RULE, 0, "if the total is a multiple of 0.25, %s", strings.Join(endings, ", "))
+	} else {
+		describe(QUARTER_MULTIPLE_TOTAL_RULE, MULTIPLE_OF_0_POINT_25_BONUS, "%d points if the total is a multiple of 0.25", MULTIPLE_OF_0_POINT_25_BONUS)
+	}
+	if rules.EvenCentsBonus > 0 {
+		describe(EVEN_CENTS_TOTAL_RULE, rules.EvenCentsBonus, "%d points if the cents of the total are even", rules.EvenCentsBonus)
+	}
+	describe(ODD_PURCHASE_DAY_RULE, ODD_DAY_BONUS, "%d points if the day in the purchase date is odd", ODD_DAY_BONUS)
+	describe(AFTERNOON_PURCHASE_RULE, BETWEEN_2PM_AND_4PM_BONUS, "%d points if the time of purchase is after %s and before %s", BETWEEN_2PM_AND_4PM_BONUS, rules.BonusWindowStart, rules.BonusWindowEnd)
+
+	if rules.LongReceiptThreshold > 0 {
+		describe(LONG_RECEIPT_RULE, LONG_RECEIPT_BONUS, "%d points if the receipt has more than %d items", LONG_RECEIPT_BONUS, rules.LongReceiptThreshold)
+	}
+	if rules.EvenItemCountBonus > 0 {
+		describe(EVEN_ITEM_COUNT_RULE, rules.EvenItemCountBonus, "%d points if the receipt has an even number of items", rules.EvenItemCountBonus)
+	}
+	if rules.FirstPurchaseOfDay {
+		describe(FIRST_PURCHASE_OF_DAY_RULE, FIRST_PURCHASE_OF_DAY_BONUS, "%d points for the earliest receipt stored for its purchase date", FIRST_PURCHASE_OF_DAY_BONUS)
+	}
+	if rules.BigSpenderThreshold > 0 {
+		describe(BIG_SPENDER_RULE, rules.BigSpenderPointsPerUnit, "%d points for every whole dollar, or unit, the total is over %d cents, or minor units", rules.BigSpenderPointsPerUnit, rules.BigSpenderThreshold)
+	}
+	if len(rules.Holidays) > 0 {
+		describe(HOLIDAY_PURCHASE_RULE, HOLIDAY_BONUS, "%d points if purchased on a holiday, %s", HOLIDAY_BONUS, strings.Join(rules.Holidays, ", "))
+	}
+	if rules.LastDayOfMonthBonus > 0 {
+		describe(LAST_DAY_OF_MONTH_RULE, rules.LastDayOfMonthBonus, "%d points if purchased on the last day of the month", rules.LastDayOfMonthBonus)
+	}
+	if rules.CompletenessBonus > 0 {
+		describe(COMPLETENESS_RULE, rules.CompletenessBonus, "%d points if the receipt gives a currency, tags, and a category for every item", rules.CompletenessBonus)
+	}
+
+	multiplier := fmt.Sprintf("%g", rules.ItemPriceMultiplier)
+	if len(rules.PriceBands) > 0 {
+		bands := make([]string, len(rules.PriceBands))
+		for i, band := range rules.PriceBands {
+			bands[i] = fmt.Sprintf("%g under %d", band.Multiplier, band.Under)
+		}
+		multiplier = fmt.Sprintf("%s, or %s cents", multiplier, strings.Join(bands, ", "))
+	}
+	if len(rules.CategoryMultipliers) > 0 {
+		weights := make([]string, 0, len(rules.CategoryMultipliers))
+		for category, multiplier := range rules.CategoryMultipliers {
+			weights = append(weights, fmt.Sprintf("%g times for %s", multiplier, category))
+		}
+		sort.Strings(weights)
+		describe(ITEM_DESCRIPTION_RULE, 0, "for every item whose trimmed description length is a multiple of %d, its price times %s, rounded up, then weighted by its category, %s", rules.DescriptionLengthDivisor, multiplier, strings.Join(weights, ", "))
+	} else {
+		describe(ITEM_DESCRIPTION_RULE, 0, "for every item whose trimmed description length is a multiple of %d, its price times %s, rounded up", rules.DescriptionLengthDivisor, multiplier)
+	}
+
+	// retailers are listed in order, so the description reads the same every time
+	if len(rules.RetailerMultipliers) > 0 {
+		multipliers := make([]string, 0, len(rules.RetailerMultipliers))
+		for retailer, multiplier := range rules.RetailerMultipliers {
+			multipliers = append(multipliers, fmt.Sprintf("%g times for %s", multiplier, retailer))
+		}
+		sort.Strings(multipliers)
+		describe(RETAILER_MULTIPLIER_RULE, 0, "the points above are multiplied for partner retailers, %s", strings.Join(multipliers, ", "))
+	}
+	if step := roundingStep(config.ScoreRounding); step > 1 {
+		describe(SCORE_ROUNDING_RULE, 0, "the points are rounded to the nearest %d", step)
+	}
+	if rules.MaxPoints > 0 {
+		describe(MAX_POINTS_RULE, 0, "the points are capped at %d", rules.MaxPoints)
+	}
+	return described
+}
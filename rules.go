@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// version of the default rules, and of any rules file that does not name its own
+const RULES_VERSION = "v1"
+
+// default window, as HH:MM, a purchase must fall strictly within to be awarded BETWEEN_2PM_AND_4PM_BONUS
+const BONUS_WINDOW_START = "14:00"
+const BONUS_WINDOW_END = "16:00"
+
+// formats holidays may be given in, on one date or on the same day every year
+const HOLIDAY_DATE_FORMAT = "2006-01-02"
+const HOLIDAY_FORMAT = "01-02"
+
+// optional scoring rules, loaded from the json file named by the RULES_FILE environment variable
+type Rules struct {
+	// the name the rules are known by, so receipts can be scored against a specific version
+	Version string `json:"version"`
+	// receipts with more items than this are awarded LONG_RECEIPT_BONUS, 0 disables the rule
+	LongReceiptThreshold int `json:"longReceiptThreshold"`
+	// whether the earliest receipt stored for each purchase date is awarded FIRST_PURCHASE_OF_DAY_BONUS
+	FirstPurchaseOfDay bool `json:"firstPurchaseOfDay"`
+	// multipliers applied to the points of receipts from the named retailers, matched ignoring case and punctuation
+	RetailerMultipliers map[string]float64 `json:"retailerMultipliers"`
+	// the bonus for totals ending in each multiple of a quarter, keyed by cents "00", "25", "50", and "75"
+	// replacing the flat MULTIPLE_OF_0_POINT_25_BONUS when given, quarters left out are awarded nothing
+	QuarterBonuses map[string]int `json:"quarterBonuses"`
+	// items whose trimmed description length is a multiple of this are awarded their price times the multiplier
+	DescriptionLengthDivisor int     `json:"descriptionLengthDivisor"`
+	ItemPriceMultiplier      float64 `json:"itemPriceMultiplier"`
+	// the most points a receipt can be worth, 0 leaves them uncapped
+	MaxPoints int `json:"maxPoints"`
+	// the window, as HH:MM, a purchase must fall strictly within to be awarded BETWEEN_2PM_AND_4PM_BONUS
+	BonusWindowStart string `json:"bonusWindowStart"`
+	BonusWindowEnd   string `json:"bonusWindowEnd"`
+	// purchases on these dates are awarded HOLIDAY_BONUS, as YYYY-MM-DD for one year or MM-DD for every year, none disables the rule
+	Holidays []string `json:"holidays"`
+	// totals over this many cents, or the minor unit of their currency, are awarded points for every whole unit over it, 0 disables the rule
+	BigSpenderThreshold     int64 `json:"bigSpenderThreshold"`
+	BigSpenderPointsPerUnit int   `json:"bigSpenderPointsPerUnit"`
+	// the bonus for totals whose cents are even, such as "3.02", 0 disables the rule
+	EvenCentsBonus int `json:"evenCentsBonus"`
+	// the bonus for receipts with an even number of items, 0 disables the rule
+	EvenItemCountBonus int `json:"evenItemCountBonus"`
+	// receipts with fewer items than this are awarded nothing for every two items, 0 awards every receipt
+	EveryTwoItemsMinimum int `json:"everyTwoItemsMinimum"`
+	// the most points every two items can be awarded, so splitting a purchase across receipts gains nothing, 0 leaves them uncapped
+	EveryTwoItemsCap int `json:"everyTwoItemsCap"`
+	// the most points the retailer name can be awarded, 0 leaves them uncapped
+	RetailerNameCap int `json:"retailerNameCap"`
+	// whether free items, priced at zero, are left out of the items counted for every two items
+	ExcludeFreeItems bool `json:"excludeFreeItems"`
+	// the bonus for purchases on the last day of their month, 0 disables the rule
+	LastDayOfMonthBonus int `json:"lastDayOfMonthBonus"`
+	// multipliers applied to the price-based points of items in the named categories, matched ignoring case
+	// items may only be given categories named here
+	CategoryMultipliers map[string]float64 `json:"categoryMultipliers"`
+	// multipliers for qualifying items priced under each band, in place of the ItemPriceMultiplier, cheapest band first
+	PriceBands []PriceBand `json:"priceBands"`
+	// the bonus for receipts giving every optional field, a currency, tags, and a category for every item, 0 disables the rule
+	CompletenessBonus int `json:"completenessBonus"`
+}
+
+// one band of item prices, those under the given number of cents, or minor units, and the multiplier their price is given
+type PriceBand struct {
+	Under      int64   `json:"under"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// the rules receipts are currently scored against
+var rules = defaultRules()
+
+// every rule-set loaded at startup, including the current rules, keyed by version
+var rulesets = map[string]Rules{RULES_VERSION: rules}
+
+// guards rules and rulesets once the app is serving, since the rules can be reloaded while it is
+var rulesLock sync.RWMutex
+
+// the rules used when no rules file is given, with every optional rule disabled
+func defaultRules() Rules {
+	return Rules{
+		Version:                  RULES_VERSION,
+		BonusWindowStart:         BONUS_WINDOW_START,
+		BonusWindowEnd:           BONUS_WINDOW_END,
+		DescriptionLengthDivisor: DESC_LENGTH_DIVISOR,
+		ItemPriceMultiplier:      ITEM_PRICE_MULTIPLIER,
+		BigSpenderPointsPerUnit:  BIG_SPENDER_POINTS_PER_UNIT,
+	}
+}
+
+/*
+Loads the rules from the json file at the given path
+fields missing from the file keep their defaults, and an empty path returns the defaults
+*/
+func loadRules(path string) (Rules, error) {
+	loaded := defaultRules()
+	if path == "" {
+		return loaded, nil
+	}
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return loaded, err
+	}
+	return parseRules(file)
+}
+
+// parses rules from the given json, overlaid on the defaults, and validates them
+func parseRules(data []byte) (Rules, error) {
+	parsed := defaultRules()
+	err := json.Unmarshal(data, &parsed)
+	if err != nil {
+		return parsed, err
+	}
+	return parsed, parsed.validate()
+}
+
+// the rules receipts are currently scored against
+func currentRules() Rules {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+
+	return rules
+}
+
+// finds the loaded rule-set with the given version, found is false if there is none
+func findRuleset(version string) (ruleset Rules, found bool) {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+
+	ruleset, found = rulesets[version]
+	return ruleset, found
+}
+
+/*
+Makes the given rules the current rules, in place of the rules they were loaded over
+they may not take the version of any other loaded rule-set
+*/
+func replaceRules(reloaded Rules) error {
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+
+	if _, duplicate := rulesets[reloaded.Version]; duplicate && reloaded.Version != rules.Version {
+		return fmt.Errorf("a rule-set with version %q is already loaded", reloaded.Version)
+	}
+
+	// the map is copied so readers holding the old one are left untouched
+	replaced := make(map[string]Rules, len(rulesets))
+	for version, ruleset := range rulesets {
+		replaced[version] = ruleset
+	}
+	delete(replaced, rules.Version)
+	replaced[reloaded.Version] = reloaded
+	rules, rulesets = reloaded, replaced
+	return nil
+}
+
+/*
+Loads the current rules and every additional rule-set at the given paths, keyed by version
+no two rule-sets may share a version
+*/
+func loadRulesets(current Rules, paths []string) (map[string]Rules, error) {
+	loaded := map[string]Rules{current.Version: current}
+	for _, path := range paths {
+		ruleset, err := loadRules(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if _, duplicate := loaded[ruleset.Version]; duplicate {
+			return nil, fmt.Errorf("%s: a rule-set with version %q is already loaded", path, ruleset.Version)
+		}
+		loaded[ruleset.Version] = ruleset
+	}
+	return loaded, nil
+}
+
+// checks the rules are consistent, returning the first problem found
+func (rules Rules) validate() error {
+	if rules.Version == "" {
+		return fmt.Errorf("version must not be empty")
+	}
+	start, err := parsePurchaseTime(rules.BonusWindowStart)
+	if err != nil {
+		return fmt.Errorf("bonusWindowStart must be a time such as %q, got %q", BONUS_WINDOW_START, rules.BonusWindowStart)
+	}
+	end, err := parsePurchaseTime(rules.BonusWindowEnd)
+	if err != nil {
+		return fmt.Errorf("bonusWindowEnd must be a time such as %q, got %q", BONUS_WINDOW_END, rules.BonusWindowEnd)
+	}
+	if !start.Before(end) {
+		return fmt.Errorf("bonusWindowStart %q must be before bonusWindowEnd %q", rules.BonusWindowStart, rules.BonusWindowEnd)
+	}
+	for cents, bonus := range rules.QuarterBonuses {
+		if cents != "00" && cents != "25" && cents != "50" && cents != "75" {
+			return fmt.Errorf("quarterBonuses may only be given for \"00\", \"25\", \"50\", and \"75\", got %q", cents)
+		}
+		if bonus < 0 {
+			return fmt.Errorf("the quarter bonus for %q must not be negative, got %d", cents, bonus)
+		}
+	}
+	if rules.DescriptionLengthDivisor < 1 {
+		return fmt.Errorf("descriptionLengthDivisor must be positive, got %d", rules.DescriptionLengthDivisor)
+	}
+	if rules.ItemPriceMultiplier < 0 {
+		return fmt.Errorf("itemPriceMultiplier must not be negative, got %g", rules.ItemPriceMultiplier)
+	}
+	if rules.MaxPoints < 0 {
+		return fmt.Errorf("maxPoints must not be negative, got %d", rules.MaxPoints)
+	}
+	if rules.BigSpenderThreshold < 0 {
+		return fmt.Errorf("bigSpenderThreshold must not be negative, got %d", rules.BigSpenderThreshold)
+	}
+	if rules.BigSpenderPointsPerUnit < 0 {
+		return fmt.Errorf("bigSpenderPointsPerUnit must not be negative, got %d", rules.BigSpenderPointsPerUnit)
+	}
+	if rules.EvenCentsBonus < 0 {
+		return fmt.Errorf("evenCentsBonus must not be negative, got %d", rules.EvenCentsBonus)
+	}
+	if rules.EvenItemCountBonus < 0 {
+		return fmt.Errorf("evenItemCountBonus must not be negative, got %d", rules.EvenItemCountBonus)
+	}
+	if rules.EveryTwoItemsMinimum < 0 {
+		return fmt.Errorf("everyTwoItemsMinimum must not be negative, got %d", rules.EveryTwoItemsMinimum)
+	}
+	if rules.EveryTwoItemsCap < 0 {
+		return fmt.Errorf("everyTwoItemsCap must not be negative, got %d", rules.EveryTwoItemsCap)
+	}
+	if rules.RetailerNameCap < 0 {
+		return fmt.Errorf("retailerNameCap must not be negative, got %d", rules.RetailerNameCap)
+	}
+	if rules.LastDayOfMonthBonus < 0 {
+		return fmt.Errorf("lastDayOfMonthBonus must not be negative, got %d", rules.LastDayOfMonthBonus)
+	}
+	if rules.CompletenessBonus < 0 {
+		return fmt.Errorf("completenessBonus must not be negative, got %d", rules.CompletenessBonus)
+	}
+	for _, holiday := range rules.Holidays {
+		_, dateErr := time.Parse(HOLIDAY_DATE_FORMAT, holiday)
+		_, yearlyErr := time.Parse(HOLIDAY_FORMAT, holiday)
+		if dateErr != nil && yearlyErr != nil {
+			return fmt.Errorf("holidays must be dates such as \"2024-12-25\" or \"12-25\", got %q", holiday)
+		}
+	}
+	normalized := make(map[string]bool, len(rules.RetailerMultipliers))
+	for retailer, multiplier := range rules.RetailerMultipliers {
+		if multiplier <= 0 {
+			return fmt.Errorf("the multiplier for %q must be positive, got %g", retailer, multiplier)
+		}
+		if normalized[normalizeRetailer(retailer)] {
+			return fmt.Errorf("more than one multiplier is given for %q", retailer)
+		}
+		normalized[normalizeRetailer(retailer)] = true
+	}
+	for i, band := range rules.PriceBands {
+		if band.Under <= 0 || i > 0 && band.Under <= rules.PriceBands[i-1].Under {
+			return fmt.Errorf("priceBands must be given cheapest first, with under positive and increasing, got %d", band.Under)
+		}
+		if band.Multiplier < 0 {
+			return fmt.Errorf("the multiplier for prices under %d must not be negative, got %g", band.Under, band.Multiplier)
+		}
+	}
+	categories := make(map[string]bool, len(rules.CategoryMultipliers))
+	for category, multiplier := range rules.CategoryMultipliers {
+		if multiplier <= 0 {
+			return fmt.Errorf("the multiplier for the category %q must be positive, got %g", category, multiplier)
+		}
+		if categories[strings.ToLower(category)] {
+			return fmt.Errorf("more than one multiplier is given for the category %q", category)
+		}
+		categories[strings.ToLower(category)] = true
+	}
+	return nil
+}
+
+// the multiplier of the first price band the price in minor units falls under, or the ItemPriceMultiplier if it is above them all
+func (rules Rules) priceMultiplier(price int64) float64 {
+	for _, band := range rules.PriceBands {
+		if price < band.Under {
+			return band.Multiplier
+		}
+	}
+	return rules.ItemPriceMultiplier
+}
+
+// the multiplier of the named category, matched ignoring case, found is false if the rules do not name it
+func (rules Rules) categoryMultiplier(category string) (multiplier float64, found bool) {
+	for named, multiplier := range rules.CategoryMultipliers {
+		if strings.EqualFold(named, category) {
+			return multiplier, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parses the rules from the given json overlaid on the defaults, failing the test if they are invalid
+func testRules(t *testing.T, data string) Rules {
+	t.Helper()
+	parsed, err := parseRules([]byte(data))
+	if err != nil {
+		t.Fatalf("could not parse rules %s: %v", data, err)
+	}
+	return parsed
+}
+
+// checks the rules in the given json are rejected with an error mentioning the given text
+func expectInvalidRules(t *testing.T, data string, mentions string) {
+	t.Helper()
+	_, err := parseRules([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), mentions) {
+		t.Errorf("parsing %s gave error %v, expected one mentioning %q", data, err, mentions)
+	}
+}
+
+func TestCustomBonusWindow(t *testing.T) {
+	resetState(t)
+	ruleset := testRules(t, `{"bonusWindowStart": "11:00", "bonusWindowEnd": "13:00"}`)
+
+	for _, test := range []struct {
+		purchaseTime string
+		points       int
+	}{
+		{"10:59", 0},
+		{"11:00", 0},
+		{"11:01", BETWEEN_2PM_AND_4PM_BONUS},
+		{"12:00:30", BETWEEN_2PM_AND_4PM_BONUS},
+		{"12:59", BETWEEN_2PM_AND_4PM_BONUS},
+		{"13:00", 0},
+		{"14:30", 0},
+	} {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.PurchaseTime = test.purchaseTime
+		breakdown := CalculateBreakdown(normalizeReceipt(receipt), ruleset, ScoringFacts{})
+		if points := rulePoints(breakdown, AFTERNOON_PURCHASE_RULE); points != test.points {
+			t.Errorf("purchased at %s was awarded %d window points, expected %d", test.purchaseTime, points, test.points)
+		}
+	}
+}
+
+func TestDefaultBonusWindow(t *testing.T) {
+	ruleset := defaultRules()
+	if ruleset.BonusWindowStart != "14:00" || ruleset.BonusWindowEnd != "16:00" {
+		t.Errorf("the default window is %s to %s, expected 14:00 to 16:00", ruleset.BonusWindowStart, ruleset.BonusWindowEnd)
+	}
+}
+
+func TestInvalidBonusWindow(t *testing.T) {
+	expectInvalidRules(t, `{"bonusWindowStart": "13:00", "bonusWindowEnd": "11:00"}`, "must be before")
+	expectInvalidRules(t, `{"bonusWindowStart": "12:00", "bonusWindowEnd": "12:00"}`, "must be before")
+	expectInvalidRules(t, `{"bonusWindowStart": "noon"}`, "bonusWindowStart")
+	expectInvalidRules(t, `{"bonusWindowEnd": "25:00"}`, "bonusWindowEnd")
+}
+
+// writes the rules to a file in a temporary directory, returning its path
+func writeTestRules(t *testing.T, name string, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("could not write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestScoreUnderTwoRulesets(t *testing.T) {
+	resetState(t)
+	loaded, err := loadRulesets(defaultRules(), []string{writeTestRules(t, "v2.json", `{"version": "v2", "longReceiptThreshold": 3}`)})
+	if err != nil {
+		t.Fatalf("could not load the rule-sets: %v", err)
+	}
+	rulesets = loaded
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	for _, test := range []struct {
+		query   string
+		version string
+		points  int
+	}{
+		{"", RULES_VERSION, TARGET_POINTS},
+		{"?ruleset=v1", RULES_VERSION, TARGET_POINTS},
+		{"?ruleset=v2", "v2", TARGET_POINTS + LONG_RECEIPT_BONUS},
+		// scoring under another rule-set leaves the score under the current rules as it was
+		{"", RULES_VERSION, TARGET_POINTS},
+	} {
+		recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points"+test.query, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("points%s responded %d: %s", test.query, recorder.Code, recorder.Body)
+		}
+		points := decodeTestJSON[Points](t, recorder)
+		if points.Points != test.points || points.RulesVersion != test.version {
+			t.Errorf("points%s were %d under %s, expected %d under %s", test.query, points.Points, points.RulesVersion, test.points, test.version)
+		}
+	}
+}
+
+func TestScoreUnderUnknownRuleset(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points?ruleset=v9", "", "Accept", "application/problem+json")
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("an unknown rule-set responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+	if problem := decodeTestJSON[Problem](t, recorder); problem.Type != UNKNOWN_RULESET_PROBLEM {
+		t.Errorf("an unknown rule-set was a %s problem, expected %s", problem.Type, UNKNOWN_RULESET_PROBLEM)
+	}
+}
+
+func TestRulesetVersionsMustBeUnique(t *testing.T) {
+	path := writeTestRules(t, "v1.json", `{"longReceiptThreshold": 3}`)
+	if _, err := loadRulesets(defaultRules(), []string{path}); err == nil || !strings.Contains(err.Error(), "already loaded") {
+		t.Errorf("loading a second %s gave error %v, expected it to be already loaded", RULES_VERSION, err)
+	}
+}
+
+func TestInvalidQuarterBonuses(t *testing.T) {
+	expectInvalidRules(t, `{"quarterBonuses": {"10": 5}}`, "quarterBonuses")
+	expectInvalidRules(t, `{"quarterBonuses": {"25": -5}}`, "must not be negative")
+}
+
+func TestResponsesReportTheLoadedRulesVersion(t *testing.T) {
+	resetState(t)
+	loaded, err := loadRules(writeTestRules(t, "rules.json", `{"version": "2026-spring"}`))
+	if err != nil {
+		t.Fatalf("could not load the rules: %v", err)
+	}
+	replaceTestRules(loaded)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	for _, endpoint := range []string{"points", "breakdown"} {
+		recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/"+endpoint, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s responded %d: %s", endpoint, recorder.Code, recorder.Body)
+		}
+		if version := decodeTestJSON[Breakdown](t, recorder).RulesVersion; version != "2026-spring" {
+			t.Errorf("%s reported rules version %q, expected the loaded %q", endpoint, version, "2026-spring")
+		}
+	}
+}
+
+func TestRulesDescribeTheLoadedConfig(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	describe := func() map[string]RuleDescription {
+		recorder := serveRequest(router, http.MethodGet, "/rules", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("the rules responded %d: %s", recorder.Code, recorder.Body)
+		}
+		described := make(map[string]RuleDescription)
+		for _, rule := range decodeTestJSON[[]RuleDescription](t, recorder) {
+			described[rule.Name] = rule
+		}
+		return described
+	}
+
+	described := describe()
+	if _, found := described[LONG_RECEIPT_RULE]; found {
+		t.Errorf("the disabled %s rule was described", LONG_RECEIPT_RULE)
+	}
+	if afternoon := described[AFTERNOON_PURCHASE_RULE]; !strings.Contains(afternoon.Description, "after 14:00 and before 16:00") {
+		t.Errorf("the %s rule was described as %q", AFTERNOON_PURCHASE_RULE, afternoon.Description)
+	}
+
+	replaceTestRules(testRules(t, `{"longReceiptThreshold": 3, "bonusWindowStart": "15:00", "evenItemCountBonus": 4}`))
+	described = describe()
+	for name, expected := range map[string]RuleDescription{
+		LONG_RECEIPT_RULE:       {LONG_RECEIPT_RULE, LONG_RECEIPT_BONUS, "10 points if the receipt has more than 3 items"},
+		AFTERNOON_PURCHASE_RULE: {AFTERNOON_PURCHASE_RULE, BETWEEN_2PM_AND_4PM_BONUS, "10 points if the time of purchase is after 15:00 and before 16:00"},
+		EVEN_ITEM_COUNT_RULE:    {EVEN_ITEM_COUNT_RULE, 4, "4 points if the receipt has an even number of items"},
+	} {
+		if described[name] != expected {
+			t.Errorf("the %s rule was described as %+v, expected %+v", name, described[name], expected)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the JSON Schema receipts are checked against when SCHEMA_VALIDATION is on, in the subset of Draft 2020-12 a Schema supports
+const RECEIPT_SCHEMA = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"$id": "/schemas/receipt",
+	"type": "object",
+	"required": ["retailer", "purchaseDate", "purchaseTime", "total", "items"],
+	"additionalProperties": false,
+	"properties": {
+		"retailer": {"type": "string", "minLength": 1},
+		"purchaseDate": {"type": "string", "minLength": 1},
+		"purchaseTime": {"type": "string", "minLength": 1},
+		"total": {"type": "string", "minLength": 1},
+		"currency": {"type": "string"},
+		"tags": {"type": "array", "items": {"type": "string"}},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"items": {
+				"type": "object",
+				"required": ["shortDescription", "price"],
+				"additionalProperties": false,
+				"properties": {
+					"shortDescription": {"type": "string", "minLength": 1},
+					"price": {"type": "string", "minLength": 1},
+					"category": {"type": "string"}
+				}
+			}
+		}
+	}
+}`
+
+/*
+The subset of JSON Schema the receipt schema is written in
+keywords beyond these are not supported, a schema using one fails to parse rather than having it silently ignored
+*/
+type Schema struct {
+	// the dialect and id of the schema, which are only ever noted
+	Dialect              string             `json:"$schema"`
+	Id                   string             `json:"$id"`
+	Type                 string             `json:"type"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*Schema `json:"properties"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	MinItems             int                `json:"minItems"`
+	MinLength            int                `json:"minLength"`
+}
+
+// the parsed RECEIPT_SCHEMA
+var receiptSchema = mustParseSchema(RECEIPT_SCHEMA)
+
+// parses the given schema, panicking if it is malformed or uses an unsupported keyword since it is built into the app
+func mustParseSchema(source string) *Schema {
+	var schema Schema
+	decoder := json.NewDecoder(strings.NewReader(source))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&schema); err != nil {
+		panic(fmt.Sprintf("malformed schema: %v", err))
+	}
+	return &schema
+}
+
+/*
+Checks the given json document against the schema
+returns an InvalidReceiptError listing every violation by its JSON pointer, or nil if there are none
+*/
+func (schema *Schema) validateDocument(document []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return InvalidReceiptError{Problems: []string{err.Error()}}
+	}
+
+	invalid := InvalidReceiptError{}
+	schema.check(value, "", &invalid)
+	if len(invalid.Problems) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// adds a problem to invalid for every way the value at the given pointer breaks the schema
+func (schema *Schema) check(value any, pointer string, invalid *InvalidReceiptError) {
+	if schema.Type != "" && jsonType(value) != schema.Type {
+		invalid.Problems = append(invalid.Problems, fmt.Sprintf("%s must be of type %s, got %s", pointerOrRoot(pointer), schema.Type, jsonType(value)))
+		return
+	}
+
+	switch value := value.(type) {
+	case string:
+		if len([]rune(value)) < schema.MinLength {
+			invalid.Problems = append(invalid.Problems, fmt.Sprintf("%s must be at least %d characters", pointerOrRoot(pointer), schema.MinLength))
+		}
+	case []any:
+		if len(value) < schema.MinItems {
+			invalid.Problems = append(invalid.Problems, fmt.Sprintf("%s must have at least %d items", pointerOrRoot(pointer), schema.MinItems))
+		}
+		if schema.Items != nil {
+			for i, item := range value {
+				schema.Items.check(item, fmt.Sprintf("%s/%d", pointer, i), invalid)
+			}
+		}
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, found := value[name]; !found {
+				invalid.Problems = append(invalid.Problems, fmt.Sprintf("%s is required", pointer+"/"+name))
+			}
+		}
+
+		// properties are checked in order, so the problems are listed the same way every time
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, known := schema.Properties[name]
+			if !known {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					invalid.Problems = append(invalid.Problems, fmt.Sprintf("%s is not an allowed property", pointer+"/"+name))
+				}
+				continue
+			}
+			property.check(value[name], pointer+"/"+name, invalid)
+		}
+	}
+}
+
+// the JSON Schema type of a value decoded with UseNumber
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+// the pointer, or a name for the whole document when it is empty
+func pointerOrRoot(pointer string) string {
+	if pointer == "" {
+		return "the receipt"
+	}
+	return pointer
+}
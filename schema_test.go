@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSchemaRejectsNumericTotal(t *testing.T) {
+	resetState(t)
+	config.SchemaValidation = true
+	router := newTestRouter(t)
+
+	recorder := serveRequest(router, http.MethodPost, "/receipts/process", strings.Replace(TARGET_RECEIPT, `"35.35"`, `35.35`, 1))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("a numeric total responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+	if description := decodeTestJSON[Description](t, recorder).Description; !strings.Contains(description, "/total must be of type string, got number") {
+		t.Errorf("a numeric total was rejected with %q, expected a schema error for /total", description)
+	}
+
+	// the schema is only checked when asked for
+	config.SchemaValidation = false
+	recorder = serveRequest(router, http.MethodPost, "/receipts/process", strings.Replace(TARGET_RECEIPT, `"35.35"`, `35.35`, 1))
+	if description := decodeTestJSON[Description](t, recorder).Description; recorder.Code != http.StatusBadRequest || strings.Contains(description, "/total must be of type") {
+		t.Errorf("a numeric total without schema validation responded %d with %q, expected a binding error", recorder.Code, description)
+	}
+}
+
+func TestSchemaListsEveryViolation(t *testing.T) {
+	document := `{"retailer": "", "purchaseDate": "2022-01-01", "total": "1.00", "items": [{"price": 1}], "extra": true}`
+	err := receiptSchema.validateDocument([]byte(document))
+	if err == nil {
+		t.Fatalf("%s passed the schema", document)
+	}
+
+	problems := err.(InvalidReceiptError).Problems
+	expected := []string{
+		"/purchaseTime is required",
+		"/extra is not an allowed property",
+		"/items/0/shortDescription is required",
+		"/items/0/price must be of type string, got number",
+		"/retailer must be at least 1 characters",
+	}
+	if strings.Join(problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("%s broke the schema with %q, expected %q", document, problems, expected)
+	}
+
+	if err := receiptSchema.validateDocument([]byte(TARGET_RECEIPT)); err != nil {
+		t.Errorf("the example receipt broke the schema: %v", err)
+	}
+}
+
+func TestSchemaRejectsUnsupportedKeywords(t *testing.T) {
+	defer func() {
+		if recovered := recover(); recovered == nil || !strings.Contains(recovered.(string), "pattern") {
+			t.Errorf("a schema using pattern parsed with %v, expected it to fail naming the keyword", recovered)
+		}
+	}()
+	mustParseSchema(`{"type": "string", "pattern": "^[0-9]+$"}`)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// default time a score waits for a free scoring slot before giving up
+const SCORING_QUEUE_TIMEOUT = 500 * time.Millisecond
+
+// what scoring fails with when every slot stays taken for longer than the queue timeout
+var ErrScoringBusy = errors.New("too many receipts are being scored at once")
+
+// one slot per score that may be computed at once, nil when MAX_CONCURRENT_SCORING leaves them unlimited
+var scoringSlots chan struct{}
+
+// a receipt's breakdown under one version of the rules, and when it was computed
+type cachedScore struct {
+	Breakdown  Breakdown
+	ComputedAt time.Time
+	// whether the score was served from the cache rather than computed for the caller
+	Cached bool
+}
+
+// one computation of a receipt's points, under the named rules version
+type HistoricalScore struct {
+	Ruleset    string    `json:"ruleset"`
+	Points     int       `json:"points"`
+	ComputedAt time.Time `json:"computedAt"`
+}
+
+// the breakdowns computed so far, keyed by receipt id then rules version
+type scoreCache struct {
+	lock   sync.RWMutex
+	scores map[string]map[string]cachedScore
+	// every score computed for each receipt, oldest first, kept even once the cached score is forgotten
+	history map[string][]HistoricalScore
+}
+
+// every score computed since startup, receipts are immutable so a score only changes with the rules
+var scores = &scoreCache{
+	scores:  make(map[string]map[string]cachedScore),
+	history: make(map[string][]HistoricalScore),
+}
+
+// finds the cached score of the receipt under the given rules version, found is false if it was never computed
+func (cache *scoreCache) get(id string, version string) (score cachedScore, found bool) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	score, found = cache.scores[id][version]
+	return score, found
+}
+
+// caches the breakdown of the receipt under the given rules version, computed now
+func (cache *scoreCache) put(id string, version string, breakdown Breakdown) cachedScore {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.scores[id] == nil {
+		cache.scores[id] = make(map[string]cachedScore)
+	}
+	score := cachedScore{Breakdown: breakdown, ComputedAt: time.Now()}
+	cache.scores[id][version] = score
+	cache.history[id] = append(cache.history[id], HistoricalScore{Ruleset: version, Points: breakdown.Points, ComputedAt: score.ComputedAt})
+	return score
+}
+
+// every score computed for the receipt, oldest first
+func (cache *scoreCache) historyOf(id string) []HistoricalScore {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	return append([]HistoricalScore{}, cache.history[id]...)
+}
+
+// forgets every cached score of the receipt, for when it is replaced
+func (cache *scoreCache) forget(id string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	delete(cache.scores, id)
+}
+
+// drops every cached score of the receipt along with its history, for when it is deleted
+func (cache *scoreCache) drop(id string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	delete(cache.scores, id)
+	delete(cache.history, id)
+}
+
+// forgets every cached score, for when the rules they were computed under change without changing version
+func (cache *scoreCache) forgetAll() {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.scores = make(map[string]map[string]cachedScore)
+}
+
+/*
+Makes the given write to the store, forgetting the cached scores of the receipts first on each of the given purchase dates
+before and after it, since the write may have moved the first purchase of the day bonus from one receipt to another
+*/
+func rewritingFirstsOnDates(ctx context.Context, purchaseDates []string, write func() error) error {
+	firsts := func() ([]string, error) {
+		ids := []string{}
+		for _, purchaseDate := range purchaseDates {
+			id, found, err := receipts.FirstOnDate(ctx, purchaseDate)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}
+
+	before, err := firsts()
+	if err != nil {
+		return err
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	after, err := firsts()
+	if err != nil {
+		return err
+	}
+	for _, id := range append(before, after...) {
+		scores.forget(id)
+	}
+	return nil
+}
+
+/*
+Scores the stored receipt under the given rules, reusing the cached score if there is one
+otherwise computes it, gathering what the store knows about the receipt, once a scoring slot is free, and caches it
+*/
+func scoreReceipt(ctx context.Context, stored StoredReceipt, ruleset Rules) (cachedScore, error) {
+	if score, found := scores.get(stored.Id, ruleset.Version); found {
+		score.Cached = true
+		return score, nil
+	}
+
+	facts, err := scoringFacts(ctx, receipts, stored)
+	if err != nil {
+		return cachedScore{}, err
+	}
+	release, err := acquireScoringSlot(ctx)
+	if err != nil {
+		return cachedScore{}, err
+	}
+	defer release()
+	return scores.put(stored.Id, ruleset.Version, CalculateBreakdown(stored.Receipt, ruleset, facts)), nil
+}
+
+/*
+Waits for a free scoring slot, queuing for at most SCORING_QUEUE_TIMEOUT
+returns the function that frees the slot once scoring is done, or ErrScoringBusy if none came free in time
+*/
+func acquireScoringSlot(ctx context.Context) (release func(), err error) {
+	if scoringSlots == nil {
+		return func() {}, nil
+	}
+
+	timer := time.NewTimer(config.ScoringQueueTimeout)
+	defer timer.Stop()
+	select {
+	case scoringSlots <- struct{}{}:
+		return func() { <-scoringSlots }, nil
+	case <-timer.C:
+		return nil, ErrScoringBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
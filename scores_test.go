@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPointsHistoryUnderTwoRulesets(t *testing.T) {
+	resetState(t)
+	loaded, err := loadRulesets(defaultRules(), []string{writeTestRules(t, "v2.json", `{"version": "v2", "longReceiptThreshold": 3}`)})
+	if err != nil {
+		t.Fatalf("could not load the rule-sets: %v", err)
+	}
+	rulesets = loaded
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	// the cached score is reused, so scoring again under the same rules adds nothing to the history
+	for _, query := range []string{"", "?ruleset=v2", "", "?ruleset=v2"} {
+		if recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points"+query, ""); recorder.Code != http.StatusOK {
+			t.Fatalf("points%s responded %d: %s", query, recorder.Code, recorder.Body)
+		}
+	}
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points/history", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("the history responded %d: %s", recorder.Code, recorder.Body)
+	}
+	history := decodeTestJSON[[]HistoricalScore](t, recorder)
+	if len(history) != 2 {
+		t.Fatalf("the history is %+v, expected a score under each rule-set", history)
+	}
+	for i, expected := range []HistoricalScore{{Ruleset: RULES_VERSION, Points: TARGET_POINTS}, {Ruleset: "v2", Points: TARGET_POINTS + LONG_RECEIPT_BONUS}} {
+		if history[i].Ruleset != expected.Ruleset || history[i].Points != expected.Points || history[i].ComputedAt.IsZero() {
+			t.Errorf("score %d of the history is %+v, expected %d points under %s", i, history[i], expected.Points, expected.Ruleset)
+		}
+	}
+	if history[1].ComputedAt.Before(history[0].ComputedAt) {
+		t.Errorf("the history is not in the order the scores were computed: %+v", history)
+	}
+
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/missing/points/history", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("the history of a missing receipt responded %d, expected %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+func TestScoringOverTheLimitIsShed(t *testing.T) {
+	resetState(t)
+	config.MaxConcurrentScoring = 1
+	config.ScoringQueueTimeout = 50 * time.Millisecond
+	scoringSlots = make(chan struct{}, config.MaxConcurrentScoring)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+	// a what-if total is never cached, so every request scores afresh
+	path := "/receipts/" + id + "/points?total=35.00"
+
+	// with the only slot taken, a score waits out the queue timeout and is turned away
+	scoringSlots <- struct{}{}
+	started := time.Now()
+	if recorder := serveRequest(router, http.MethodGet, path, ""); recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("a score over the limit responded %d, expected %d: %s", recorder.Code, http.StatusServiceUnavailable, recorder.Body)
+	}
+	if waited := time.Since(started); waited < config.ScoringQueueTimeout {
+		t.Errorf("a score over the limit was turned away after %v, before the queue timeout of %v", waited, config.ScoringQueueTimeout)
+	}
+
+	// a score queued behind one that finishes in time goes ahead
+	config.ScoringQueueTimeout = 5 * time.Second
+	queued := make(chan *httptest.ResponseRecorder)
+	go func() {
+		queued <- serveRequest(router, http.MethodGet, path, "")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	<-scoringSlots
+	if recorder := <-queued; recorder.Code != http.StatusOK {
+		t.Errorf("a queued score responded %d once the slot came free: %s", recorder.Code, recorder.Body)
+	}
+	if held := len(scoringSlots); held != 0 {
+		t.Errorf("%d scoring slots were still held once every score finished", held)
+	}
+}
+
+func TestPointsCacheInfo(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	id := processTestReceipt(t, router, TARGET_RECEIPT)
+	cacheInfo := func() Points {
+		recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points?cacheInfo=true", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("points with cache info responded %d: %s", recorder.Code, recorder.Body)
+		}
+		points := decodeTestJSON[Points](t, recorder)
+		if points.Cached == nil || points.ComputedAt == nil {
+			t.Fatalf("points with cache info were missing it: %+v", points)
+		}
+		return points
+	}
+
+	before := time.Now()
+	first := cacheInfo()
+	if *first.Cached || first.ComputedAt.Before(before) {
+		t.Errorf("the first points were cached %t, computed at %s, expected them computed afresh after %s", *first.Cached, first.ComputedAt, before)
+	}
+	second := cacheInfo()
+	if !*second.Cached || !second.ComputedAt.Equal(*first.ComputedAt) {
+		t.Errorf("the second points were cached %t, computed at %s, expected them from the cache computed at %s", *second.Cached, second.ComputedAt, first.ComputedAt)
+	}
+
+	// forgetting the score, as reloading the rules does, has it computed afresh
+	scores.forgetAll()
+	if third := cacheInfo(); *third.Cached {
+		t.Error("the points were cached after the scores were forgotten")
+	}
+
+	if points := decodeTestJSON[Points](t, serveRequest(router, http.MethodGet, "/receipts/"+id+"/points", "")); points.Cached != nil || points.ComputedAt != nil {
+		t.Errorf("the cache info was included without being asked for: %+v", points)
+	}
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points?cacheInfo=maybe", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("an invalid cacheInfo responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPointsAsDecimal(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		rules     string
+		category  string
+		points    int
+		rawPoints float64
+	}{
+		{"unweighted", `{}`, "", TARGET_POINTS, TARGET_POINTS},
+		// the pizza's 3 points are weighted to 4.5, rounded to 5
+		{"weighted by category", `{"categoryMultipliers": {"frozen": 1.5}}`, "frozen", TARGET_POINTS + 2, TARGET_POINTS + 1.5},
+		// the 28 points are weighted to 30.8, rounded to 31
+		{"weighted by retailer", `{"retailerMultipliers": {"Target": 1.1}}`, "", 31, 30.8},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resetState(t)
+			replaceTestRules(testRules(t, test.rules))
+			router := newTestRouter(t)
+			receipt := testReceipt(t, TARGET_RECEIPT)
+			receipt.Items[1].Category = test.category
+			id := processTestReceipt(t, router, testReceiptJSON(t, receipt))
+
+			recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points?decimal=true", "")
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("points as a decimal responded %d: %s", recorder.Code, recorder.Body)
+			}
+			points := decodeTestJSON[Points](t, recorder)
+			if points.Points != test.points || points.RawPoints == nil || *points.RawPoints != test.rawPoints {
+				t.Errorf("the points were %d, %v raw, expected %d, %g raw", points.Points, points.RawPoints, test.points, test.rawPoints)
+			}
+
+			// the whole points alone are sent unless the decimal is asked for
+			if points := decodeTestJSON[Points](t, serveRequest(router, http.MethodGet, "/receipts/"+id+"/points", "")); points.Points != test.points || points.RawPoints != nil {
+				t.Errorf("the points without decimal=true were %+v", points)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// a receipt from the challenge, along with the points it is documented to be worth under the default rules
+type Fixture struct {
+	Name    string
+	Receipt string
+	Points  int
+}
+
+// the canonical receipts of the challenge, built in so a deployment can check its own scoring
+var FIXTURES = []Fixture{
+	{
+		Name:    "target",
+		Receipt: `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"},{"shortDescription":"Emils Cheese Pizza","price":"12.25"},{"shortDescription":"Knorr Creamy Chicken","price":"1.26"},{"shortDescription":"Doritos Nacho Cheese","price":"3.35"},{"shortDescription":"   Klarbrunn 12-PK 12 FL OZ  ","price":"12.00"}],"total":"35.35"}`,
+		Points:  28,
+	},
+	{
+		Name:    "m&m corner market",
+		Receipt: `{"retailer":"M&M Corner Market","purchaseDate":"2022-03-20","purchaseTime":"14:33","items":[{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"}],"total":"9.00"}`,
+		Points:  109,
+	},
+}
+
+// response of /selftest endpoint, whether every fixture scored as documented, and the ones that did not
+type SelfTest struct {
+	Passed     bool       `json:"passed"`
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+// a fixture that did not score as documented
+type Mismatch struct {
+	Fixture  string `json:"fixture"`
+	Expected int    `json:"expected"`
+	Actual   int    `json:"actual"`
+	// why the fixture could not be scored at all, in place of its points
+	Error string `json:"error,omitempty"`
+}
+
+/*
+Scores every built-in fixture under the default rules, to check the deployment scores receipts correctly
+SCORE_ROUNDING is still applied, so a deployment rounding its scores will not match the documented points
+responds with 200 if every fixture scored as documented, and with 500 and every mismatch otherwise
+*/
+func getSelfTest(context *gin.Context) {
+	result := runSelfTest(defaultRules())
+
+	// return the result as a json object with a 200 status if it passed, and a 500 status otherwise
+	if !result.Passed {
+		context.JSON(http.StatusInternalServerError, result)
+		return
+	}
+	context.JSON(http.StatusOK, result)
+}
+
+// scores every fixture under the given rules, listing every one that did not score as documented
+func runSelfTest(rules Rules) SelfTest {
+	result := SelfTest{Mismatches: []Mismatch{}}
+	for _, fixture := range FIXTURES {
+		var receipt Receipt
+		if err := json.Unmarshal([]byte(fixture.Receipt), &receipt); err != nil {
+			result.Mismatches = append(result.Mismatches, Mismatch{Fixture: fixture.Name, Expected: fixture.Points, Error: fmt.Sprintf("malformed fixture: %v", err)})
+			continue
+		}
+
+		// the fixtures are scored as receipts are once stored, tidied up and as the only receipt of their day
+		points := CalculatePoints(normalizeReceipt(receipt), rules, ScoringFacts{FirstOnDate: true})
+		if points != fixture.Points {
+			result.Mismatches = append(result.Mismatches, Mismatch{Fixture: fixture.Name, Expected: fixture.Points, Actual: points})
+		}
+	}
+	result.Passed = len(result.Mismatches) == 0
+	return result
+}
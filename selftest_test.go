@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSelfTestPassesWithTheDefaultRules(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	recorder := serveRequest(router, http.MethodGet, "/selftest", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("the self-test responded %d: %s", recorder.Code, recorder.Body)
+	}
+	if result := decodeTestJSON[SelfTest](t, recorder); !result.Passed || len(result.Mismatches) != 0 {
+		t.Errorf("the self-test reported %+v, expected it to pass", result)
+	}
+}
+
+func TestSelfTestFailsWhenARuleIsTampered(t *testing.T) {
+	resetState(t)
+
+	// the target receipt has items priced for its description rule, the m&m corner market receipt none
+	result := runSelfTest(testRules(t, `{"itemPriceMultiplier": 0.5}`))
+	if result.Passed || len(result.Mismatches) != 1 || result.Mismatches[0].Fixture != "target" || result.Mismatches[0].Expected != TARGET_POINTS || result.Mismatches[0].Actual <= TARGET_POINTS {
+		t.Errorf("the self-test under a tampered multiplier reported %+v, expected only the target receipt to mismatch", result)
+	}
+
+	// rounding the scores tampers with every fixture, and the endpoint reports it
+	config.ScoreRounding = SCORE_ROUNDING_NEAREST_10
+	router := newTestRouter(t)
+	recorder := serveRequest(router, http.MethodGet, "/selftest", "")
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("the self-test with rounded scores responded %d, expected %d", recorder.Code, http.StatusInternalServerError)
+	}
+	if result := decodeTestJSON[SelfTest](t, recorder); result.Passed || len(result.Mismatches) != len(FIXTURES) {
+		t.Errorf("the self-test with rounded scores reported %+v, expected every fixture to mismatch", result)
+	}
+}
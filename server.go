@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// default time given to in-flight requests to finish once the app is told to stop
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
+// how often the in-flight request count is logged while draining
+const DRAIN_LOG_EVERY = time.Second
+
+// the number of requests currently being served
+var inFlight atomic.Int64
+
+// counts every request as in flight until it has been served
+func countInFlight(context *gin.Context) {
+	inFlight.Add(1)
+	defer inFlight.Add(-1)
+	context.Next()
+}
+
+/*
+Serves the handler on the given listener until the stop context is done, as it is once the app is interrupted or terminated
+then stops accepting connections and waits up to the drain timeout for in-flight requests to finish, logging how many remain,
+before closing whatever is left
+*/
+func serve(handler http.Handler, listener net.Listener, stop context.Context, drainTimeout time.Duration) error {
+	server := &http.Server{Handler: handler}
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case <-stop.Done():
+	}
+
+	logInfo("shutting down", "inFlight", inFlight.Load(), "timeout", drainTimeout)
+	drained, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+
+	// report the drain as it goes, until shutdown returns
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(DRAIN_LOG_EVERY)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				logInfo("draining", "inFlight", inFlight.Load())
+			}
+		}
+	}()
+
+	err := server.Shutdown(drained)
+	if errors.Is(err, context.DeadlineExceeded) {
+		logInfo("drain timed out, closing", "inFlight", inFlight.Load())
+		return server.Close()
+	}
+	if err != nil {
+		return err
+	}
+	logInfo("shut down")
+	return nil
+}
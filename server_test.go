@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Serves a router whose only route blocks until released, returning its url, the channels the route reports it was entered on
+and is released by, the function to stop serving, and the channel serve returns on
+*/
+func serveSlowTestRoute(t *testing.T, drainTimeout time.Duration) (string, chan struct{}, chan struct{}, context.CancelFunc, chan error) {
+	t.Helper()
+	entered, release := make(chan struct{}), make(chan struct{})
+	router := gin.New()
+	router.Use(countInFlight)
+	router.GET("/slow", func(context *gin.Context) {
+		close(entered)
+		<-release
+		context.String(http.StatusOK, "done")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	stop, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(router, listener, stop, drainTimeout)
+	}()
+	return "http://" + listener.Addr().String() + "/slow", entered, release, cancel, served
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	url, entered, release, cancel, served := serveSlowTestRoute(t, 5*time.Second)
+
+	responded := make(chan string, 1)
+	go func() {
+		response, err := http.Get(url)
+		if err != nil {
+			responded <- err.Error()
+			return
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		responded <- string(body)
+	}()
+	<-entered
+	if count := inFlight.Load(); count != 1 {
+		t.Errorf("%d requests were counted in flight, expected 1", count)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned %v while a request was in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	if body := <-responded; body != "done" {
+		t.Errorf("the in-flight request got %q, expected it to complete", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve returned %v once drained", err)
+	}
+	if count := inFlight.Load(); count != 0 {
+		t.Errorf("%d requests were counted in flight once drained", count)
+	}
+}
+
+func TestShutdownClosesRequestsLeftAfterTheDrainTimeout(t *testing.T) {
+	url, entered, release, cancel, served := serveSlowTestRoute(t, 50*time.Millisecond)
+	defer close(release)
+
+	failed := make(chan error, 1)
+	go func() {
+		response, err := http.Get(url)
+		if err == nil {
+			response.Body.Close()
+		}
+		failed <- err
+	}()
+	<-entered
+
+	cancel()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return once the drain timed out")
+	}
+	if err := <-failed; err == nil {
+		t.Error("the request left after the drain timeout still got a response")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// receipts are similar if their totals are at most this many cents, or minor units, apart
+const SIMILAR_TOTAL_TOLERANCE = 100
+
+// and if they were purchased at most this many days apart
+const SIMILAR_DATE_WINDOW = 3
+
+// most similar receipts returned
+const SIMILAR_LIMIT = 10
+
+// one receipt similar to another, and how similar, from 0 for barely to 1 for the same total on the same day
+type SimilarReceipt struct {
+	Id           string  `json:"id"`
+	Similarity   float64 `json:"similarity"`
+	Retailer     string  `json:"retailer"`
+	PurchaseDate string  `json:"purchaseDate"`
+	Total        string  `json:"total"`
+}
+
+/*
+Finds the stored receipts similar to a given one, to spot duplicates and fraud
+similar receipts are from the same retailer, in the same currency, with a total within SIMILAR_TOTAL_TOLERANCE
+and purchased within SIMILAR_DATE_WINDOW days
+takes the id of the receipt via url param
+responds with at most SIMILAR_LIMIT similar receipts, most similar first
+*/
+func getSimilarReceipts(context *gin.Context) {
+	// the id comes from the url
+	id := context.Param("id")
+
+	// attempt to find the receipt from the receipts store, abort on failure with 404 error
+	stored, found, err := receipts.Get(context.Request.Context(), id)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	if !found {
+		abortWithError(context, http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM, "No receipt found for that id")
+		return
+	}
+
+	others, err := receipts.List(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	similar := []SimilarReceipt{}
+	for _, other := range others {
+		if other.Id == id {
+			continue
+		}
+		if similarity, ok := similarityOf(stored.Receipt, other.Receipt); ok {
+			similar = append(similar, SimilarReceipt{
+				Id:           other.Id,
+				Similarity:   similarity,
+				Retailer:     other.Retailer,
+				PurchaseDate: other.PurchaseDate,
+				Total:        other.Total,
+			})
+		}
+	}
+
+	// the most similar first, and the earliest stored first among equals
+	sort.SliceStable(similar, func(i, j int) bool { return similar[i].Similarity > similar[j].Similarity })
+	if len(similar) > SIMILAR_LIMIT {
+		similar = similar[:SIMILAR_LIMIT]
+	}
+
+	// return the similar receipts as a json array with a 200 status
+	context.JSON(http.StatusOK, similar)
+}
+
+/*
+How similar the two receipts are, weighing how close their totals and purchase dates are equally
+ok is false if they are not similar at all
+*/
+func similarityOf(receipt Receipt, other Receipt) (similarity float64, ok bool) {
+	currency := currencyOf(receipt)
+	if normalizeRetailer(receipt.Retailer) != normalizeRetailer(other.Retailer) || currency != currencyOf(other) {
+		return 0, false
+	}
+
+	total, err := parseCents(receipt.Total, currency)
+	if err != nil {
+		return 0, false
+	}
+	otherTotal, err := parseCents(other.Total, currency)
+	if err != nil {
+		return 0, false
+	}
+	totalApart := math.Abs(float64(total - otherTotal))
+
+	date, err := time.Parse(PURCHASE_DATE_FORMAT, receipt.PurchaseDate)
+	if err != nil {
+		return 0, false
+	}
+	otherDate, err := time.Parse(PURCHASE_DATE_FORMAT, other.PurchaseDate)
+	if err != nil {
+		return 0, false
+	}
+	daysApart := math.Abs(date.Sub(otherDate).Hours() / 24)
+
+	if totalApart > SIMILAR_TOTAL_TOLERANCE || daysApart > SIMILAR_DATE_WINDOW {
+		return 0, false
+	}
+	similarity = 1 - totalApart/SIMILAR_TOTAL_TOLERANCE/2 - daysApart/SIMILAR_DATE_WINDOW/2
+	return math.Round(similarity*100) / 100, true
+}
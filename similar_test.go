@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// the target receipt with the given retailer, purchase date, and total
+func targetVariant(t *testing.T, retailer string, purchaseDate string, total string) string {
+	t.Helper()
+	receipt := testReceipt(t, TARGET_RECEIPT)
+	receipt.Retailer, receipt.PurchaseDate, receipt.Total = retailer, purchaseDate, total
+	return testReceiptJSON(t, receipt)
+}
+
+func TestSimilarReceiptsFindNearDuplicates(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	original := processTestReceipt(t, router, TARGET_RECEIPT)
+
+	exact := processTestReceipt(t, router, TARGET_RECEIPT)
+	near := processTestReceipt(t, router, targetVariant(t, "TARGET", "2022-01-02", "35.85"))
+	processTestReceipt(t, router, targetVariant(t, "Walmart", "2022-01-01", "35.35"))
+	processTestReceipt(t, router, targetVariant(t, "Target", "2022-01-01", "36.36"))
+	processTestReceipt(t, router, targetVariant(t, "Target", "2022-01-05", "35.35"))
+	processTestReceipt(t, router, MM_RECEIPT)
+
+	recorder := serveRequest(router, http.MethodGet, "/receipts/"+original+"/similar", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("similar responded %d: %s", recorder.Code, recorder.Body)
+	}
+	similar := decodeTestJSON[[]SimilarReceipt](t, recorder)
+	if len(similar) != 2 {
+		t.Fatalf("found %+v, expected only the exact and near duplicates", similar)
+	}
+	if similar[0].Id != exact || similar[0].Similarity != 1 {
+		t.Errorf("the most similar was %+v, expected %s with a similarity of 1", similar[0], exact)
+	}
+	// half the tolerance on the total and a third of the window on the date
+	if similar[1].Id != near || similar[1].Similarity != 0.58 {
+		t.Errorf("the next most similar was %+v, expected %s with a similarity of 0.58", similar[1], near)
+	}
+}
+
+func TestSimilarReceiptsAreCapped(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	original := processTestReceipt(t, router, TARGET_RECEIPT)
+	for i := 0; i < SIMILAR_LIMIT+5; i++ {
+		processTestReceipt(t, router, TARGET_RECEIPT)
+	}
+
+	if similar := decodeTestJSON[[]SimilarReceipt](t, serveRequest(router, http.MethodGet, "/receipts/"+original+"/similar", "")); len(similar) != SIMILAR_LIMIT {
+		t.Errorf("found %d similar receipts, expected at most %d", len(similar), SIMILAR_LIMIT)
+	}
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/missing/similar", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("a missing receipt responded %d, expected %d", recorder.Code, http.StatusNotFound)
+	}
+}
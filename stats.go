@@ -0,0 +1,278 @@
+package main
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// response of /metrics/receipts endpoint, the receipts purchased on one day and the points they are worth
+type DailyReceipts struct {
+	Date   string `json:"date"`
+	Count  int    `json:"count"`
+	Points int    `json:"points"`
+}
+
+// how many points a receipt is worth under the current rules and under candidate rules
+type ScoreDelta struct {
+	Id        string `json:"id"`
+	Current   int    `json:"current"`
+	Candidate int    `json:"candidate"`
+	Delta     int    `json:"delta"`
+}
+
+// response of /stats/ruleset-diff endpoint, how every stored receipt's points would change under candidate rules
+type RulesetDiff struct {
+	Receipts  []ScoreDelta `json:"receipts"`
+	Current   int          `json:"current"`
+	Candidate int          `json:"candidate"`
+	Delta     int          `json:"delta"`
+}
+
+// response of /receipts/:id/percentile endpoint, how a receipt's points compare to every stored receipt's
+type Percentile struct {
+	Id         string  `json:"id"`
+	Points     int     `json:"points"`
+	Percentile float64 `json:"percentile"`
+	Receipts   int     `json:"receipts"`
+}
+
+// running totals of the points each rule has awarded across every receipt processed
+type ruleTotals struct {
+	lock   sync.Mutex
+	points map[string]int
+	// the contributions counted for each receipt, so they can be taken back out of the totals when it is deleted
+	counted map[string][]Contribution
+}
+
+// the points awarded by each rule, counted once per receipt as it is processed under the current rules
+var awardedByRule = &ruleTotals{points: make(map[string]int), counted: make(map[string][]Contribution)}
+
+// adds every rule's contribution in the receipt's breakdown to its running total
+func (totals *ruleTotals) add(id string, breakdown Breakdown) {
+	totals.lock.Lock()
+	defer totals.lock.Unlock()
+
+	for _, contribution := range breakdown.Rules {
+		totals.points[contribution.Rule] += contribution.Points
+	}
+	totals.counted[id] = append(totals.counted[id], breakdown.Rules...)
+}
+
+// takes every contribution counted for the receipt back out of the running totals, for when it is deleted
+func (totals *ruleTotals) remove(id string) {
+	totals.lock.Lock()
+	defer totals.lock.Unlock()
+
+	for _, contribution := range totals.counted[id] {
+		totals.points[contribution.Rule] -= contribution.Points
+		if totals.points[contribution.Rule] == 0 {
+			delete(totals.points, contribution.Rule)
+		}
+	}
+	delete(totals.counted, id)
+}
+
+// a copy of the running totals, keyed by rule name
+func (totals *ruleTotals) snapshot() map[string]int {
+	totals.lock.Lock()
+	defer totals.lock.Unlock()
+
+	snapshot := make(map[string]int, len(totals.points))
+	for rule, points := range totals.points {
+		snapshot[rule] = points
+	}
+	return snapshot
+}
+
+/*
+Reports how many points each rule has awarded across every receipt processed
+responds with the total points keyed by rule name
+*/
+func getRuleStats(context *gin.Context) {
+	// return the totals as a json object with a 200 status
+	context.JSON(http.StatusOK, awardedByRule.snapshot())
+}
+
+/*
+Summarizes the stored receipts by the day they were purchased, under the current rules
+takes the first and last day, as YYYY-MM-DD, via the optional from and to query params
+responds with the number of receipts purchased and the points they are worth for each day with any, earliest first
+*/
+func getDailyReceipts(context *gin.Context) {
+	// both ends of the range are optional, but must be dates if given, abort otherwise with 400 error
+	from, to := context.Query("from"), context.Query("to")
+	for _, day := range []string{from, to} {
+		if _, err := time.Parse(PURCHASE_DATE_FORMAT, day); day != "" && err != nil {
+			abortWithError(context, http.StatusBadRequest, INVALID_REQUEST_PROBLEM, "The from and to query params must be dates such as 2022-01-01")
+			return
+		}
+	}
+
+	stored, err := receipts.List(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	// dates in YYYY-MM-DD order the same as strings, so the range can be compared directly
+	buckets := make(map[string]*DailyReceipts)
+	for _, receipt := range stored {
+		date := receipt.PurchaseDate
+		if (from != "" && date < from) || (to != "" && date > to) {
+			continue
+		}
+		score, err := scoreReceipt(context.Request.Context(), receipt, currentRules())
+		if err != nil {
+			abortWithStoreError(context, err)
+			return
+		}
+		if buckets[date] == nil {
+			buckets[date] = &DailyReceipts{Date: date}
+		}
+		buckets[date].Count++
+		buckets[date].Points += score.Breakdown.Points
+	}
+
+	days := make([]DailyReceipts, 0, len(buckets))
+	for _, bucket := range buckets {
+		days = append(days, *bucket)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+
+	// return the days as a json array with a 200 status
+	context.JSON(http.StatusOK, days)
+}
+
+/*
+Scores every stored receipt under candidate rules, to see their impact before they are rolled out
+nothing is stored or cached, so the current scores are left as they are
+takes the candidate rules as a json object, in the same form as the RULES_FILE
+responds with each receipt's points under the current and candidate rules, and the totals across every receipt
+*/
+func diffRuleset(context *gin.Context) {
+	body, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		abortWithError(context, http.StatusBadRequest, MALFORMED_BODY_PROBLEM, "The body could not be read")
+		return
+	}
+
+	// attempt to read the candidate rules, abort on failure with 400 error
+	candidate, err := parseRules(body)
+	if err != nil {
+		abortWithError(context, http.StatusBadRequest, INVALID_REQUEST_PROBLEM, "The candidate rules are invalid: "+err.Error())
+		return
+	}
+
+	stored, err := receipts.List(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	diff := RulesetDiff{Receipts: make([]ScoreDelta, 0, len(stored))}
+	for _, receipt := range stored {
+		current, err := scoreReceipt(context.Request.Context(), receipt, currentRules())
+		if err != nil {
+			abortWithStoreError(context, err)
+			return
+		}
+		facts, err := scoringFacts(context.Request.Context(), receipts, receipt)
+		if err != nil {
+			abortWithStoreError(context, err)
+			return
+		}
+		points := CalculatePoints(receipt.Receipt, candidate, facts)
+
+		diff.Receipts = append(diff.Receipts, ScoreDelta{
+			Id:        receipt.Id,
+			Current:   current.Breakdown.Points,
+			Candidate: points,
+			Delta:     points - current.Breakdown.Points,
+		})
+		diff.Current += current.Breakdown.Points
+		diff.Candidate += points
+	}
+	diff.Delta = diff.Candidate - diff.Current
+
+	// return the diff as a json object with a 200 status
+	context.JSON(http.StatusOK, diff)
+}
+
+/*
+Ranks a receipt's points among those of every stored receipt, under the current rules
+the scores are cached, so only receipts never scored under the current rules are computed
+takes the id of the receipt via url param
+responds with the receipt's points, its percentile rank from 0 to 100, and how many receipts it was ranked among
+*/
+func getPercentile(context *gin.Context) {
+	// the id comes from the url
+	id := context.Param("id")
+
+	// attempt to find the receipt from the receipts store, abort on failure with 404 error
+	stored, found, err := receipts.Get(context.Request.Context(), id)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	if !found {
+		abortWithError(context, http.StatusNotFound, RECEIPT_NOT_FOUND_PROBLEM, "No receipt found for that id")
+		return
+	}
+
+	all, err := receipts.List(context.Request.Context())
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+
+	ruleset := currentRules()
+	score, err := scoreReceipt(context.Request.Context(), stored, ruleset)
+	if err != nil {
+		abortWithStoreError(context, err)
+		return
+	}
+	points := make([]int, 0, len(all))
+	for _, receipt := range all {
+		other, err := scoreReceipt(context.Request.Context(), receipt, ruleset)
+		if err != nil {
+			abortWithStoreError(context, err)
+			return
+		}
+		points = append(points, other.Breakdown.Points)
+	}
+
+	// return the percentile as a json object with a 200 status
+	context.JSON(http.StatusOK, Percentile{
+		Id:         id,
+		Points:     score.Breakdown.Points,
+		Percentile: percentileRank(score.Breakdown.Points, points),
+		Receipts:   len(points),
+	})
+}
+
+/*
+The percentile rank of the given points among all of them, the share scoring less plus half the share scoring the same
+so the lowest of many distinct scores is near 0, the highest near 100, and a score everyone shares is 50
+rounded to two decimal places, and 0 when there are no points to rank among
+*/
+func percentileRank(points int, all []int) float64 {
+	if len(all) == 0 {
+		return 0
+	}
+	below, equal := 0, 0
+	for _, other := range all {
+		if other < points {
+			below++
+		} else if other == points {
+			equal++
+		}
+	}
+	rank := (float64(below) + float64(equal)/2) / float64(len(all)) * 100
+	return math.Round(rank*100) / 100
+}
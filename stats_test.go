@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// the totals /stats/rules reports, failing the test unless they are found
+func testRuleStats(t *testing.T, router http.Handler) map[string]int {
+	t.Helper()
+	recorder := serveRequest(router, http.MethodGet, "/stats/rules", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("rule stats responded %d: %s", recorder.Code, recorder.Body)
+	}
+	return decodeTestJSON[map[string]int](t, recorder)
+}
+
+func TestRuleStatsTotalEveryReceipt(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	if totals := testRuleStats(t, router); len(totals) != 0 {
+		t.Errorf("no receipts have awarded %v", totals)
+	}
+
+	target := processTestReceipt(t, router, TARGET_RECEIPT)
+	processTestReceipt(t, router, MM_RECEIPT)
+	expected := map[string]int{
+		RETAILER_NAME_RULE:          6 + 14,
+		EVERY_TWO_ITEMS_RULE:        10 + 10,
+		ITEM_DESCRIPTION_RULE:       6,
+		ODD_PURCHASE_DAY_RULE:       6,
+		ROUND_DOLLAR_TOTAL_RULE:     ROUND_DOLLAR_AMOUNT_BONUS,
+		QUARTER_MULTIPLE_TOTAL_RULE: MULTIPLE_OF_0_POINT_25_BONUS,
+		AFTERNOON_PURCHASE_RULE:     BETWEEN_2PM_AND_4PM_BONUS,
+	}
+	if totals := testRuleStats(t, router); !reflect.DeepEqual(totals, expected) {
+		t.Errorf("the two receipts awarded %v, expected %v", totals, expected)
+	}
+
+	// reading the points of a receipt already processed does not count them again
+	testPoints(t, router, target)
+	if totals := testRuleStats(t, router); !reflect.DeepEqual(totals, expected) {
+		t.Errorf("reading the points changed the totals to %v, expected %v", totals, expected)
+	}
+}
+
+func TestDailyReceiptsBucketByPurchaseDate(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	processTestReceipt(t, router, TARGET_RECEIPT)
+	sameDay := processTestReceipt(t, router, targetVariant(t, "Target Store", "2022-01-01", "35.35"))
+	processTestReceipt(t, router, MM_RECEIPT)
+	processTestReceipt(t, router, targetVariant(t, "Target", "2022-04-01", "35.35"))
+	firstDay := TARGET_POINTS + testPoints(t, router, sameDay)
+
+	for _, test := range []struct {
+		query    string
+		expected []DailyReceipts
+	}{
+		{"", []DailyReceipts{{"2022-01-01", 2, firstDay}, {"2022-03-20", 1, MM_POINTS}, {"2022-04-01", 1, TARGET_POINTS}}},
+		{"?from=2022-01-02", []DailyReceipts{{"2022-03-20", 1, MM_POINTS}, {"2022-04-01", 1, TARGET_POINTS}}},
+		{"?from=2022-01-01&to=2022-03-20", []DailyReceipts{{"2022-01-01", 2, firstDay}, {"2022-03-20", 1, MM_POINTS}}},
+		{"?to=2021-12-31", []DailyReceipts{}},
+	} {
+		recorder := serveRequest(router, http.MethodGet, "/metrics/receipts"+test.query, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("daily receipts%s responded %d: %s", test.query, recorder.Code, recorder.Body)
+		}
+		if days := decodeTestJSON[[]DailyReceipts](t, recorder); !reflect.DeepEqual(days, test.expected) {
+			t.Errorf("daily receipts%s were %v, expected %v", test.query, days, test.expected)
+		}
+	}
+
+	if recorder := serveRequest(router, http.MethodGet, "/metrics/receipts?from=yesterday", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("an invalid from date responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRulesetDiffDoublingTheItemBonus(t *testing.T) {
+	resetState(t)
+	config.AdminToken = TEST_ADMIN_TOKEN
+	router := newTestRouter(t)
+	ids := map[string]string{
+		processTestReceipt(t, router, TARGET_RECEIPT): TARGET_RECEIPT,
+		processTestReceipt(t, router, MM_RECEIPT):     MM_RECEIPT,
+	}
+	doubled := `{"itemPriceMultiplier": 0.4}`
+	candidate := testRules(t, doubled)
+
+	recorder := serveAdminRequest(router, http.MethodPost, "/stats/ruleset-diff", doubled)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("the ruleset diff responded %d: %s", recorder.Code, recorder.Body)
+	}
+	diff := decodeTestJSON[RulesetDiff](t, recorder)
+	if len(diff.Receipts) != len(ids) {
+		t.Fatalf("the diff covered %d receipts, expected %d", len(diff.Receipts), len(ids))
+	}
+	for _, delta := range diff.Receipts {
+		receipt := testReceipt(t, ids[delta.Id])
+		current := CalculatePoints(receipt, defaultRules(), ScoringFacts{})
+		expected := CalculatePoints(receipt, candidate, ScoringFacts{})
+		if delta.Current != current || delta.Candidate != expected || delta.Delta != expected-current {
+			t.Errorf("%s was diffed as %+v, expected %d under the current rules and %d under the candidate", delta.Id, delta, current, expected)
+		}
+	}
+	if diff.Current != TARGET_POINTS+MM_POINTS || diff.Delta != diff.Candidate-diff.Current || diff.Delta <= 0 {
+		t.Errorf("the diff totals were %d to %d, a delta of %d", diff.Current, diff.Candidate, diff.Delta)
+	}
+
+	// the candidate rules are only scored against, never stored
+	for id, receipt := range ids {
+		if points, expected := testPoints(t, router, id), CalculatePoints(testReceipt(t, receipt), defaultRules(), ScoringFacts{}); points != expected {
+			t.Errorf("%s has %d points after the diff, expected %d", id, points, expected)
+		}
+	}
+
+	if recorder := serveAdminRequest(router, http.MethodPost, "/stats/ruleset-diff", `{"maxPoints": -1}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid candidate rules responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+	if recorder := serveRequest(router, http.MethodPost, "/stats/ruleset-diff", doubled); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("the diff without the admin token responded %d, expected %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestPercentileRanksAmongStoredReceipts(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+	target := processTestReceipt(t, router, TARGET_RECEIPT)
+	processTestReceipt(t, router, TARGET_RECEIPT)
+	mm := processTestReceipt(t, router, MM_RECEIPT)
+	evenDay := processTestReceipt(t, router, targetVariant(t, "Target", "2022-01-02", "35.35"))
+
+	// 22, 28, 28, and 109 points, each ranked by the share scoring less plus half the share scoring the same
+	for id, expected := range map[string]Percentile{
+		evenDay: {evenDay, TARGET_POINTS - ODD_DAY_BONUS, 12.5, 4},
+		target:  {target, TARGET_POINTS, 50, 4},
+		mm:      {mm, MM_POINTS, 87.5, 4},
+	} {
+		recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/percentile", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("the percentile of %s responded %d: %s", id, recorder.Code, recorder.Body)
+		}
+		if percentile := decodeTestJSON[Percentile](t, recorder); percentile != expected {
+			t.Errorf("the percentile of %s was %+v, expected %+v", id, percentile, expected)
+		}
+	}
+
+	if recorder := serveRequest(router, http.MethodGet, "/receipts/missing/percentile", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("the percentile of a missing receipt responded %d, expected %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+func TestPercentileRank(t *testing.T) {
+	for _, test := range []struct {
+		points     int
+		all        []int
+		percentile float64
+	}{
+		{10, nil, 0},
+		{10, []int{10}, 50},
+		{10, []int{10, 10, 10}, 50},
+		{1, []int{1, 2, 3}, 16.67},
+		{3, []int{1, 2, 3}, 83.33},
+	} {
+		if percentile := percentileRank(test.points, test.all); percentile != test.percentile {
+			t.Errorf("%d among %v ranked %g, expected %g", test.points, test.all, percentile, test.percentile)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// what a store returns, wrapped, when the receipt asked for is not stored, so handlers can tell it from a failure
+var ErrNotFound = errors.New("receipt not found")
+
+// what a store returns, wrapped, when a write clashes with what is already stored
+var ErrConflict = errors.New("receipt conflicts with one already stored")
+
+// what a store returns, wrapped, for failures that may go away if the operation is tried again, such as a dropped connection
+var ErrUnavailable = errors.New("store temporarily unavailable")
+
+/*
+Where receipts are kept, every method gives up once the given context is done
+failures are wrapped around ErrNotFound, ErrConflict, or ErrUnavailable where one of them applies
+*/
+type Store interface {
+	// adds the receipt to the store under the given id
+	Save(ctx context.Context, id string, receipt Receipt) error
+	// adds the receipt as it was stored before, such as in an export, keeping its id and when it was stored
+	// and replacing any receipt already stored under its id
+	Restore(ctx context.Context, stored StoredReceipt) error
+	// finds the receipt stored under the given id, found is false if there is none
+	Get(ctx context.Context, id string) (stored StoredReceipt, found bool, err error)
+	// the number of receipts currently stored
+	Count(ctx context.Context) (int, error)
+	// every receipt stored, in the order they were stored
+	List(ctx context.Context) ([]StoredReceipt, error)
+	// the id of the earliest stored receipt with the given purchase date, by when it was stored, found is false if there is none
+	FirstOnDate(ctx context.Context, purchaseDate string) (id string, found bool, err error)
+	// removes the receipt stored under the given id, found is false if there was none
+	Delete(ctx context.Context, id string) (found bool, err error)
+}
+
+// a receipt along with the id it is stored under and when it was stored
+type StoredReceipt struct {
+	Id string `json:"id"`
+	Receipt
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// all receipts processed, held in memory and guarded by a read/write lock
+type MemoryStore struct {
+	lock     sync.RWMutex
+	receipts map[string]StoredReceipt
+	// the id of the earliest stored receipt for every purchase date
+	firstOnDate map[string]string
+}
+
+// the store every endpoint reads and writes receipts through, a real implementation would use a database
+var receipts Store = NewMemoryStore()
+
+// creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		receipts:    make(map[string]StoredReceipt),
+		firstOnDate: make(map[string]string),
+	}
+}
+
+/*
+Gathers what the store knows about the given receipt that the scoring rules need
+*/
+func scoringFacts(ctx context.Context, store Store, stored StoredReceipt) (ScoringFacts, error) {
+	first, found, err := store.FirstOnDate(ctx, stored.PurchaseDate)
+	if err != nil {
+		return ScoringFacts{}, err
+	}
+	return ScoringFacts{FirstOnDate: found && first == stored.Id}, nil
+}
+
+func (store *MemoryStore) Save(ctx context.Context, id string, receipt Receipt) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.receipts[id] = StoredReceipt{Id: id, Receipt: receipt, CreatedAt: time.Now()}
+	if _, found := store.firstOnDate[receipt.PurchaseDate]; !found {
+		store.firstOnDate[receipt.PurchaseDate] = id
+	}
+	receiptsStored.Set(float64(len(store.receipts)))
+	return nil
+}
+
+func (store *MemoryStore) Restore(ctx context.Context, stored StoredReceipt) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	// receipts exported before they carried when they were stored count as stored now
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = time.Now()
+	}
+	previous, replaced := store.receipts[stored.Id]
+	store.receipts[stored.Id] = stored
+	if replaced {
+		store.findFirstOnDate(previous.PurchaseDate)
+	}
+	store.findFirstOnDate(stored.PurchaseDate)
+	receiptsStored.Set(float64(len(store.receipts)))
+	return nil
+}
+
+func (store *MemoryStore) Get(ctx context.Context, id string) (stored StoredReceipt, found bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return stored, false, err
+	}
+
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	stored, found = store.receipts[id]
+	return stored, found, nil
+}
+
+func (store *MemoryStore) Count(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return len(store.receipts), nil
+}
+
+func (store *MemoryStore) List(ctx context.Context) ([]StoredReceipt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	list := make([]StoredReceipt, 0, len(store.receipts))
+	for _, stored := range store.receipts {
+		list = append(list, stored)
+	}
+
+	// xids sort in the order they were created
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list, nil
+}
+
+func (store *MemoryStore) FirstOnDate(ctx context.Context, purchaseDate string) (id string, found bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	id, found = store.firstOnDate[purchaseDate]
+	return id, found, nil
+}
+
+func (store *MemoryStore) Delete(ctx context.Context, id string) (found bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	deleted, found := store.receipts[id]
+	if !found {
+		return false, nil
+	}
+	delete(store.receipts, id)
+	receiptsStored.Set(float64(len(store.receipts)))
+
+	// the earliest receipt left for the date, if any, takes the deleted one's place as its first
+	if store.firstOnDate[deleted.PurchaseDate] == id {
+		store.findFirstOnDate(deleted.PurchaseDate)
+	}
+	return true, nil
+}
+
+// records the earliest stored receipt with the given purchase date as its first, or none if there is none, the lock must be held
+func (store *MemoryStore) findFirstOnDate(purchaseDate string) {
+	delete(store.firstOnDate, purchaseDate)
+	var first StoredReceipt
+	for _, stored := range store.receipts {
+		if stored.PurchaseDate != purchaseDate {
+			continue
+		}
+		if first.Id == "" || stored.CreatedAt.Before(first.CreatedAt) || stored.CreatedAt.Equal(first.CreatedAt) && stored.Id < first.Id {
+			first = stored
+		}
+	}
+	if first.Id != "" {
+		store.firstOnDate[purchaseDate] = first.Id
+	}
+}
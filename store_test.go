@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// a store that hangs on every read until the request gives up on it
+type slowStore struct {
+	*MemoryStore
+}
+
+func (store slowStore) Get(ctx context.Context, id string) (StoredReceipt, bool, error) {
+	<-ctx.Done()
+	return StoredReceipt{}, false, ctx.Err()
+}
+
+func TestSlowStoreTimesOut(t *testing.T) {
+	resetState(t)
+	config.RequestTimeout = 20 * time.Millisecond
+	router := newTestRouter(t)
+	receipts = slowStore{NewMemoryStore()}
+
+	start := time.Now()
+	recorder := serveRequest(router, http.MethodGet, "/receipts/some-id", "", "Accept", MIME_PROBLEM_JSON)
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("a hung store responded %d, expected %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	if problem := decodeTestJSON[Problem](t, recorder); problem.Type != STORE_UNAVAILABLE_PROBLEM {
+		t.Errorf("a hung store responded with a %q problem", problem.Type)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the request took %s to give up on a %s timeout", elapsed, config.RequestTimeout)
+	}
+}
+
+// the earliest receipt the store holds for the date, failing the test if it cannot be read
+func testFirstOnDate(t *testing.T, store Store, purchaseDate string) string {
+	t.Helper()
+	id, found, err := store.FirstOnDate(context.Background(), purchaseDate)
+	if err != nil {
+		t.Fatalf("could not find the first receipt on %s: %v", purchaseDate, err)
+	}
+	if !found {
+		return ""
+	}
+	return id
+}
+
+func TestMemoryStoreTracksFirstOnDate(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	target, mm := testReceipt(t, TARGET_RECEIPT), testReceipt(t, MM_RECEIPT)
+
+	for _, saved := range []struct {
+		id      string
+		receipt Receipt
+	}{{"a", target}, {"b", target}, {"c", mm}} {
+		if err := store.Save(ctx, saved.id, saved.receipt); err != nil {
+			t.Fatalf("could not save %s: %v", saved.id, err)
+		}
+	}
+
+	if first := testFirstOnDate(t, store, target.PurchaseDate); first != "a" {
+		t.Errorf("the first receipt on %s was %q, expected a", target.PurchaseDate, first)
+	}
+	if first := testFirstOnDate(t, store, mm.PurchaseDate); first != "c" {
+		t.Errorf("the first receipt on %s was %q, expected c", mm.PurchaseDate, first)
+	}
+	if first := testFirstOnDate(t, store, "2022-02-02"); first != "" {
+		t.Errorf("the first receipt on a date with none was %q", first)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// how strictly receipts are validated, set by VALIDATION_MODE
+const VALIDATION_LENIENT = "lenient"
+const VALIDATION_STANDARD = "standard"
+const VALIDATION_STRICT = "strict"
+
+// most of a multipart form held in memory while it is parsed, the rest goes to temporary files
+const MAX_FORM_MEMORY = 1 << 20
+
+// a receipt that failed validation, along with every problem found with it
+type InvalidReceiptError struct {
+	Problems []string
+}
+
+func (err InvalidReceiptError) Error() string {
+	return strings.Join(err.Problems, "; ")
+}
+
+// response of /receipts/validate endpoint, whether the receipt is valid and if not, why
+type Validity struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// response of /receipts/invalid endpoint, a stored receipt that would now fail validation, and why
+type InvalidReceipt struct {
+	Id     string   `json:"id"`
+	Errors []string `json:"errors"`
+}
+
+// binding errors name fields by their json names, as the client sent them
+func init() {
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				return field.Name
+			}
+			return name
+		})
+	}
+}
+
+/*
+Binds the receipt in the request body, tidies it up, and validates it
+the same path is taken by every endpoint that accepts a receipt, failures are an InvalidReceiptError
+*/
+func bindReceipt(context *gin.Context) (Receipt, error) {
+	var receipt Receipt
+
+	// html forms send receipts as form fields rather than json
+	if context.ContentType() == gin.MIMEMultipartPOSTForm {
+		return bindReceiptForm(context)
+	}
+
+	// the body is read up front when its aliased fields are renamed, or when it is checked against the receipt schema
+	if len(config.FieldAliases) > 0 || config.SchemaValidation {
+		body, err := io.ReadAll(context.Request.Body)
+		if err != nil {
+			return receipt, InvalidReceiptError{Problems: []string{err.Error()}}
+		}
+		body, err = remapFields(body)
+		if err != nil {
+			return receipt, err
+		}
+
+		// strict clients have the body checked against the schema first, for more precise errors than binding gives
+		if config.SchemaValidation {
+			if err := receiptSchema.validateDocument(body); err != nil {
+				return receipt, err
+			}
+		}
+		context.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	// attempt to create a Receipt struct from the given JSON object
+	err := context.ShouldBindJSON(&receipt)
+	if err != nil {
+		return receipt, bindingError(err)
+	}
+
+	receipt = normalizeReceipt(receipt)
+	return receipt, validateReceipt(receipt)
+}
+
+/*
+Binds the receipt in a multipart form, tidies it up, and validates it
+the fields are named as in json, with each item given by a repeated item[].shortDescription and item[].price
+and each tag by a repeated tags field
+*/
+func bindReceiptForm(context *gin.Context) (Receipt, error) {
+	var receipt Receipt
+
+	err := context.Request.ParseMultipartForm(MAX_FORM_MEMORY)
+	if err != nil {
+		return receipt, InvalidReceiptError{Problems: []string{err.Error()}}
+	}
+	form := context.Request.MultipartForm.Value
+	first := func(name string) string {
+		if values := form[name]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	receipt.Retailer = first("retailer")
+	receipt.PurchaseDate = first("purchaseDate")
+	receipt.PurchaseTime = first("purchaseTime")
+	receipt.Total = first("total")
+	receipt.Currency = first("currency")
+	receipt.Tags = form["tags"]
+
+	// items are paired up by the order their fields were given in
+	// categories are optional, but when any is given every item must have one, even if empty
+	descriptions, prices, categories := form["item[].shortDescription"], form["item[].price"], form["item[].category"]
+	if len(descriptions) != len(prices) {
+		return receipt, InvalidReceiptError{Problems: []string{fmt.Sprintf("there are %d item descriptions but %d item prices", len(descriptions), len(prices))}}
+	}
+	if len(categories) > 0 && len(categories) != len(descriptions) {
+		return receipt, InvalidReceiptError{Problems: []string{fmt.Sprintf("there are %d item descriptions but %d item categories", len(descriptions), len(categories))}}
+	}
+	if len(descriptions) > 0 {
+		receipt.Items = make([]Item, len(descriptions))
+		for i := range descriptions {
+			receipt.Items[i] = Item{ShortDescription: descriptions[i], Price: prices[i]}
+			if len(categories) > 0 {
+				receipt.Items[i].Category = categories[i]
+			}
+		}
+	}
+
+	err = binding.Validator.ValidateStruct(receipt)
+	if err != nil {
+		return receipt, bindingError(err)
+	}
+
+	receipt = normalizeReceipt(receipt)
+	return receipt, validateReceipt(receipt)
+}
+
+// describes why the receipt could not be bound, with one problem per failed field
+func bindingError(err error) InvalidReceiptError {
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return InvalidReceiptError{Problems: []string{err.Error()}}
+	}
+
+	invalid := InvalidReceiptError{}
+	for _, fieldError := range fieldErrors {
+		// the namespace starts with the struct name, which means nothing to the client
+		field := fieldError.Namespace()
+		if dot := strings.Index(field, "."); dot >= 0 {
+			field = field[dot+1:]
+		}
+		invalid.Problems = append(invalid.Problems, fmt.Sprintf("%s failed the %s check", field, fieldError.Tag()))
+	}
+	return invalid
+}
+
+/*
+Tidies up the given receipt before it is validated, rewriting its money strings in canonical form, trimming its tags
+and item categories, and working out when it was purchased
+*/
+func normalizeReceipt(receipt Receipt) Receipt {
+	receipt.Total = normalizeMoney(receipt.Total)
+
+	// the items are copied so the caller's receipt is left untouched
+	items := make([]Item, len(receipt.Items))
+	for i, item := range receipt.Items {
+		item.Price = normalizeMoney(item.Price)
+		item.Category = strings.TrimSpace(item.Category)
+		items[i] = item
+	}
+	receipt.Items = items
+
+	// the instant is left unset if it cannot be worked out, which validation reports
+	receipt.PurchasedAt, _ = parsePurchaseInstant(receipt)
+
+	// tags are compared ignoring case and surrounding whitespace, so they are kept lowercase and trimmed, once each
+	if receipt.Tags != nil {
+		tags := make([]string, 0, len(receipt.Tags))
+		seen := make(map[string]bool, len(receipt.Tags))
+		for _, tag := range receipt.Tags {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		receipt.Tags = tags
+	}
+	return receipt
+}
+
+/*
+Checks the given receipt against the validations of the configured VALIDATION_MODE, beyond what binding already requires
+lenient only guards against control characters, oversized fields, and disallowed retailers,
+standard also checks dates, times, money, MAX_TOTAL, and MIN_PURCHASE_DATE,
+and strict also checks the total is positive and the sum of the prices, and that the purchase is not in the future
+returns an InvalidReceiptError listing every problem found, or nil if there are none
+*/
+func validateReceipt(receipt Receipt) error {
+	invalid := InvalidReceiptError{}
+
+	// control characters would corrupt logs and responses, only standard whitespace is let through
+	fields := [][2]string{
+		{"retailer", receipt.Retailer},
+		{"purchaseDate", receipt.PurchaseDate},
+		{"purchaseTime", receipt.PurchaseTime},
+		{"total", receipt.Total},
+		{"currency", receipt.Currency},
+	}
+	for i, item := range receipt.Items {
+		fields = append(fields,
+			[2]string{fmt.Sprintf("items[%d].shortDescription", i), item.ShortDescription},
+			[2]string{fmt.Sprintf("items[%d].price", i), item.Price})
+	}
+	for i, tag := range receipt.Tags {
+		fields = append(fields, [2]string{fmt.Sprintf("tags[%d]", i), tag})
+	}
+	for _, field := range fields {
+		if strings.IndexFunc(field[1], isDisallowedControl) >= 0 {
+			invalid.Problems = append(invalid.Problems, field[0]+" contains a control character")
+		}
+	}
+
+	// descriptions are meant to be short, and a huge one would bloat the store and every response carrying it
+	for i, item := range receipt.Items {
+		if length := utf8.RuneCountInString(item.ShortDescription); config.MaxDescriptionLength > 0 && int64(length) > config.MaxDescriptionLength {
+			invalid.Problems = append(invalid.Problems, fmt.Sprintf("the short description of items[%d] is %d characters, more than the maximum of %d", i, length, config.MaxDescriptionLength))
+		}
+	}
+
+	// categories must be ones the current rules weight, so a typo is not silently scored as uncategorized
+	for i, item := range receipt.Items {
+		if _, found := currentRules().categoryMultiplier(item.Category); item.Category != "" && !found {
+			invalid.Problems = append(invalid.Problems, fmt.Sprintf("items[%d] has the unknown category %q", i, item.Category))
+		}
+	}
+
+	// tags are meant as short labels, not free text
+	if len(receipt.Tags) > config.MaxTags {
+		invalid.Problems = append(invalid.Problems, fmt.Sprintf("there are %d tags, more than the maximum of %d", len(receipt.Tags), config.MaxTags))
+	}
+	for i, tag := range receipt.Tags {
+		if length := utf8.RuneCountInString(tag); length == 0 || length > config.MaxTagLength {
+			invalid.Problems = append(invalid.Problems, fmt.Sprintf("tags[%d] must be between 1 and %d characters", i, config.MaxTagLength))
+		}
+	}
+
+	// some deployments only take receipts from certain retailers, or never from others
+	if len(config.RetailerAllowlist) > 0 && !matchesRetailer(receipt.Retailer, config.RetailerAllowlist) ||
+		matchesRetailer(receipt.Retailer, config.RetailerBlocklist) {
+		invalid.Problems = append(invalid.Problems, fmt.Sprintf("receipts from %q are not accepted", receipt.Retailer))
+	}
+
+	// lenient deployments stop there, taking whatever dates, times, and money they are given
+	if config.ValidationMode == VALIDATION_LENIENT {
+		return invalid.orNil()
+	}
+
+	// the purchase date and time must name a real instant in the server's timezone
+	instant, instantErr := parsePurchaseInstant(receipt)
+	if instantErr != nil {
+		invalid.Problems = append(invalid.Problems, "the purchase date and time "+instantErr.Error())
+	}
+
+	// receipts from before the earliest plausible date are taken for bogus data, dates in YYYY-MM-DD order the same as strings
+	if instantErr == nil && config.MinPurchaseDate != "" && receipt.PurchaseDate < config.MinPurchaseDate {
+		invalid.Problems = append(invalid.Problems, fmt.Sprintf("the purchase date %s is before the earliest accepted, %s", receipt.PurchaseDate, config.MinPurchaseDate))
+	}
+
+	// money must be given as whole units and the currency's minor units, such as "3.00", rather than silently scoring nothing
+	total, totalErr := parseCents(receipt.Total, currencyOf(receipt))
+	if totalErr != nil {
+		invalid.Problems = append(invalid.Problems, "the total "+totalErr.Error())
+	}
+	var sum int64
+	var pricesErr error
+	for i, item := range receipt.Items {
+		price, err := parseCents(item.Price, currencyOf(receipt))
+		if err != nil {
+			pricesErr = err
+			invalid.Problems = append(invalid.Problems, fmt.Sprintf("the price of items[%d] %v", i, err))
+		}
+		sum += price
+	}
+
+	// totals over the configured ceiling are most likely corrupt
+	if config.MaxTotal > 0 && total > config.MaxTotal {
+		invalid.Problems = append(invalid.Problems, fmt.Sprintf("the total %s exceeds the maximum of %d minor units", receipt.Total, config.MaxTotal))
+	}
+
+	// strict deployments also insist the receipt adds up and has already happened
+	if config.ValidationMode == VALIDATION_STRICT {
+		if totalErr == nil && total <= 0 {
+			invalid.Problems = append(invalid.Problems, fmt.Sprintf("the total %s must be more than zero", receipt.Total))
+		}
+		if totalErr == nil && pricesErr == nil && sum != total {
+			invalid.Problems = append(invalid.Problems, fmt.Sprintf("the total %s is not the sum of the item prices", receipt.Total))
+		}
+		if instantErr == nil && instant.After(time.Now()) {
+			invalid.Problems = append(invalid.Problems, "the purchase date and time "+receipt.PurchaseDate+" "+receipt.PurchaseTime+" is in the future")
+		}
+	}
+
+	return invalid.orNil()
+}
+
+// the error, or nil if it found no problems
+func (err InvalidReceiptError) orNil() error {
+	if len(err.Problems) > 0 {
+		return err
+	}
+	return nil
+}
+
+// whether the character is a control character other than a tab, newline, or carriage return
+func isDisallowedControl(character rune) bool {
+	return unicode.IsControl(character) && character != '\t' && character != '\n' && character != '\r'
+}
+
+/*
+Whether the retailer is one of the given retailers, ignoring case and punctuation as retailer multipliers do
+entries ending in * match every retailer starting with the rest of them
+*/
+func matchesRetailer(retailer string, retailers []string) bool {
+	normalized := normalizeRetailer(retailer)
+	for _, entry := range retailers {
+		if strings.HasSuffix(entry, "*") {
+			if strings.HasPrefix(normalized, normalizeRetailer(strings.TrimSuffix(entry, "*"))) {
+				return true
+			}
+		} else if normalized == normalizeRetailer(entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxTotal(t *testing.T) {
+	for _, test := range []struct {
+		maxTotal int64
+		total    string
+		status   int
+	}{
+		{0, "35.35", http.StatusOK},
+		{3536, "35.35", http.StatusOK},
+		{3535, "35.35", http.StatusOK},
+		{3534, "35.35", http.StatusBadRequest},
+		{1000, "35.35", http.StatusBadRequest},
+	} {
+		resetState(t)
+		config.MaxTotal = test.maxTotal
+		router := newTestRouter(t)
+
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Total = test.total
+		if recorder := serveRequest(router, http.MethodPost, "/receipts/process", testReceiptJSON(t, receipt)); recorder.Code != test.status {
+			t.Errorf("a total of %s under a maximum of %d responded %d, expected %d: %s", test.total, test.maxTotal, recorder.Code, test.status, recorder.Body)
+		}
+	}
+}
+
+func TestMaxTotalFromEnvironment(t *testing.T) {
+	t.Setenv("MAX_TOTAL", "5000")
+	loaded, err := loadConfig()
+	if err != nil {
+		t.Fatalf("could not load the config: %v", err)
+	}
+	if loaded.MaxTotal != 5000 {
+		t.Errorf("MAX_TOTAL loaded as %d, expected 5000", loaded.MaxTotal)
+	}
+
+	t.Setenv("MAX_TOTAL", "-1")
+	if _, err := loadConfig(); err == nil {
+		t.Errorf("a negative MAX_TOTAL was accepted")
+	}
+}
+
+func TestControlCharactersAreRejected(t *testing.T) {
+	for _, test := range []struct {
+		retailer    string
+		description string
+		status      int
+	}{
+		{"Tar\x00get", "Emils Cheese Pizza", http.StatusBadRequest},
+		{"Target\x1b[31m", "Emils Cheese Pizza", http.StatusBadRequest},
+		{"Target", "Emils\x00Cheese Pizza", http.StatusBadRequest},
+		{"Target", "Emils\u0085Cheese Pizza", http.StatusBadRequest},
+		// standard whitespace is left alone
+		{"Tar\nget", "Emils\tCheese Pizza", http.StatusOK},
+		{"Target", "Emils Cheese\r\nPizza", http.StatusOK},
+	} {
+		resetState(t)
+		router := newTestRouter(t)
+
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Retailer, receipt.Items[1].ShortDescription = test.retailer, test.description
+		recorder := serveRequest(router, http.MethodPost, "/receipts/process", testReceiptJSON(t, receipt))
+		if recorder.Code != test.status {
+			t.Errorf("a retailer of %q and description of %q responded %d, expected %d: %s", test.retailer, test.description, recorder.Code, test.status, recorder.Body)
+		}
+		if test.status == http.StatusBadRequest && !strings.Contains(recorder.Body.String(), "control character") {
+			t.Errorf("a retailer of %q and description of %q was rejected without naming the control character: %s", test.retailer, test.description, recorder.Body)
+		}
+	}
+}
+
+func TestValidateWithoutStoring(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	// validates the receipt, failing the test unless the endpoint responds with its validity
+	validate := func(receipt string) Validity {
+		t.Helper()
+		recorder := serveRequest(router, http.MethodPost, "/receipts/validate", receipt)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("validating %s responded %d: %s", receipt, recorder.Code, recorder.Body)
+		}
+		if strings.Contains(recorder.Body.String(), `"id"`) {
+			t.Errorf("validating %s assigned an id: %s", receipt, recorder.Body)
+		}
+		return decodeTestJSON[Validity](t, recorder)
+	}
+
+	if validity := validate(TARGET_RECEIPT); !validity.Valid || len(validity.Errors) != 0 {
+		t.Errorf("the valid receipt was reported as %+v", validity)
+	}
+
+	invalid := strings.Replace(strings.Replace(TARGET_RECEIPT, `"2022-01-01"`, `"2022-13-01"`, 1), `"35.35"`, `"35.3"`, 1)
+	validity := validate(invalid)
+	if validity.Valid || len(validity.Errors) != 2 {
+		t.Errorf("the receipt with a bad date and total was reported as %+v, expected two errors", validity)
+	}
+
+	// the same problems are found as when processing the receipt
+	recorder := serveRequest(router, http.MethodPost, "/receipts/process", invalid)
+	description := decodeTestJSON[Description](t, recorder).Description
+	for _, problem := range validity.Errors {
+		if !strings.Contains(description, problem) {
+			t.Errorf("processing the receipt did not report %q: %s", problem, description)
+		}
+	}
+
+	if count := testGauge(t, router, "receipts_stored"); count != "0" {
+		t.Errorf("validating stored %s receipts", count)
+	}
+}
+
+func TestTagCountAndLength(t *testing.T) {
+	for _, test := range []struct {
+		maxTags      int
+		maxTagLength int
+		tags         []string
+		status       int
+	}{
+		{2, 10, []string{"groceries", "fuel"}, http.StatusOK},
+		// duplicates are dropped before they are counted
+		{2, 10, []string{"fuel", "FUEL", "snacks"}, http.StatusOK},
+		{2, 10, []string{"groceries", "fuel", "snacks"}, http.StatusBadRequest},
+		{2, 5, []string{"groceries"}, http.StatusBadRequest},
+		{2, 10, []string{"  "}, http.StatusBadRequest},
+		{0, 10, []string{"fuel"}, http.StatusBadRequest},
+	} {
+		resetState(t)
+		config.MaxTags, config.MaxTagLength = test.maxTags, test.maxTagLength
+		router := newTestRouter(t)
+
+		if recorder := serveRequest(router, http.MethodPost, "/receipts/process", withTestTags(t, TARGET_RECEIPT, test.tags...)); recorder.Code != test.status {
+			t.Errorf("the tags %q under %d tags of %d characters responded %d, expected %d: %s", test.tags, test.maxTags, test.maxTagLength, recorder.Code, test.status, recorder.Body)
+		}
+	}
+}
+
+func TestPurchaseInstantAcrossDaylightSaving(t *testing.T) {
+	resetState(t)
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	config.ServerLocation = location
+
+	for _, test := range []struct {
+		purchaseDate string
+		purchaseTime string
+		utc          string
+	}{
+		{"2022-03-13", "01:59", "2022-03-13T06:59:00Z"},
+		// clocks in New York skip from 2am to 3am as daylight saving starts
+		{"2022-03-13", "02:00", ""},
+		{"2022-03-13", "02:30:15", ""},
+		{"2022-03-13", "03:00", "2022-03-13T07:00:00Z"},
+		// and repeat the hour from 1am as it ends, which is still a real instant
+		{"2022-11-06", "01:30", "2022-11-06T05:30:00Z"},
+	} {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.PurchaseDate, receipt.PurchaseTime = test.purchaseDate, test.purchaseTime
+		instant, err := parsePurchaseInstant(receipt)
+		if test.utc == "" {
+			if err == nil {
+				t.Errorf("%s %s was accepted as %s, though it never happens in New York", test.purchaseDate, test.purchaseTime, instant)
+			}
+			if err := validateReceipt(normalizeReceipt(receipt)); err == nil {
+				t.Errorf("%s %s passed validation, though it never happens in New York", test.purchaseDate, test.purchaseTime)
+			}
+			continue
+		}
+		if err != nil || instant.UTC().Format(time.RFC3339) != test.utc {
+			t.Errorf("%s %s was parsed as %s and error %v, expected %s", test.purchaseDate, test.purchaseTime, instant.UTC(), err, test.utc)
+		}
+		if stored := normalizeReceipt(receipt).PurchasedAt; !stored.Equal(instant) {
+			t.Errorf("%s %s was stored as the instant %s, expected %s", test.purchaseDate, test.purchaseTime, stored, instant)
+		}
+	}
+}
+
+func TestOverlongDescriptionIsRejected(t *testing.T) {
+	for _, test := range []struct {
+		maxLength int64
+		length    int
+		status    int
+	}{
+		{MAX_DESCRIPTION_LENGTH, MAX_DESCRIPTION_LENGTH, http.StatusOK},
+		{MAX_DESCRIPTION_LENGTH, MAX_DESCRIPTION_LENGTH + 1, http.StatusBadRequest},
+		{MAX_DESCRIPTION_LENGTH, 1 << 20, http.StatusBadRequest},
+		{10, 11, http.StatusBadRequest},
+		// 0 disables the check
+		{0, 10000, http.StatusOK},
+	} {
+		resetState(t)
+		config.MaxDescriptionLength = test.maxLength
+		router := newTestRouter(t)
+
+		// multi-byte characters count once, so the length is in characters rather than bytes
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Items[0].ShortDescription = strings.Repeat("é", test.length)
+		recorder := serveRequest(router, http.MethodPost, "/receipts/process", testReceiptJSON(t, receipt))
+		if recorder.Code != test.status {
+			t.Errorf("a description of %d characters under a maximum of %d responded %d, expected %d", test.length, test.maxLength, recorder.Code, test.status)
+		}
+		if test.status == http.StatusBadRequest && !strings.Contains(recorder.Body.String(), "more than the maximum") {
+			t.Errorf("an overlong description was rejected without saying why: %.200s", recorder.Body)
+		}
+	}
+}
+
+// encodes the receipt as a multipart form, with its items as repeated item[] fields, returning the body and its content type
+func testReceiptForm(t *testing.T, receipt Receipt, extraPrices ...string) (string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := [][2]string{
+		{"retailer", receipt.Retailer},
+		{"purchaseDate", receipt.PurchaseDate},
+		{"purchaseTime", receipt.PurchaseTime},
+		{"total", receipt.Total},
+	}
+	for _, item := range receipt.Items {
+		fields = append(fields, [2]string{"item[].shortDescription", item.ShortDescription}, [2]string{"item[].price", item.Price})
+	}
+	for _, price := range extraPrices {
+		fields = append(fields, [2]string{"item[].price", price})
+	}
+	for _, field := range fields {
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			t.Fatalf("could not write the form field %s: %v", field[0], err)
+		}
+	}
+	if err := form.Close(); err != nil {
+		t.Fatalf("could not close the form: %v", err)
+	}
+	return body.String(), form.FormDataContentType()
+}
+
+func TestMultipartFormReceiptIsProcessed(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	body, contentType := testReceiptForm(t, testReceipt(t, TARGET_RECEIPT))
+	recorder := serveRequest(router, http.MethodPost, "/receipts/process", body, "Content-Type", contentType)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("the form responded %d: %s", recorder.Code, recorder.Body)
+	}
+	if points := testPoints(t, router, decodeTestJSON[Id](t, recorder).Id); points != TARGET_POINTS {
+		t.Errorf("the form receipt was awarded %d points, expected %d", points, TARGET_POINTS)
+	}
+
+	// a price without its description cannot be paired up with an item
+	body, contentType = testReceiptForm(t, testReceipt(t, TARGET_RECEIPT), "1.00")
+	if recorder := serveRequest(router, http.MethodPost, "/receipts/process", body, "Content-Type", contentType); recorder.Code != http.StatusBadRequest {
+		t.Errorf("a form with an unpaired price responded %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestValidationModes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		change   func(receipt *Receipt)
+		lenient  int
+		standard int
+		strict   int
+	}{
+		{"a total a cent off the sum", func(receipt *Receipt) { receipt.Total = "35.36" }, http.StatusOK, http.StatusOK, http.StatusBadRequest},
+		{"a purchase in the future", func(receipt *Receipt) { receipt.PurchaseDate = "2999-01-01" }, http.StatusOK, http.StatusOK, http.StatusBadRequest},
+		{"a date that does not exist", func(receipt *Receipt) { receipt.PurchaseDate = "2022-02-30" }, http.StatusOK, http.StatusBadRequest, http.StatusBadRequest},
+		{"a price without cents", func(receipt *Receipt) { receipt.Items[0].Price = "6" }, http.StatusOK, http.StatusBadRequest, http.StatusBadRequest},
+		{"a control character", func(receipt *Receipt) { receipt.Retailer = "Tar\x07get" }, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest},
+	} {
+		for mode, status := range map[string]int{VALIDATION_LENIENT: test.lenient, VALIDATION_STANDARD: test.standard, VALIDATION_STRICT: test.strict} {
+			resetState(t)
+			config.ValidationMode = mode
+			router := newTestRouter(t)
+
+			receipt := testReceipt(t, TARGET_RECEIPT)
+			test.change(&receipt)
+			if recorder := serveRequest(router, http.MethodPost, "/receipts/process", testReceiptJSON(t, receipt)); recorder.Code != status {
+				t.Errorf("%s in %s mode responded %d, expected %d: %s", test.name, mode, recorder.Code, status, recorder.Body)
+			}
+		}
+	}
+}
+
+func TestInvalidReceiptsFlagsThoseTighterValidationRejects(t *testing.T) {
+	resetState(t)
+	config.ValidationMode = VALIDATION_LENIENT
+	router := newTestRouter(t)
+	valid := processTestReceipt(t, router, TARGET_RECEIPT)
+	receipt := testReceipt(t, MM_RECEIPT)
+	receipt.Total = "9.99"
+	offTotal := processTestReceipt(t, router, testReceiptJSON(t, receipt))
+
+	list := func() []InvalidReceipt {
+		recorder := serveRequest(router, http.MethodGet, "/receipts/invalid", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("the invalid receipts responded %d: %s", recorder.Code, recorder.Body)
+		}
+		return decodeTestJSON[[]InvalidReceipt](t, recorder)
+	}
+	if invalid := list(); len(invalid) != 0 {
+		t.Errorf("the lenient receipts were listed as invalid: %+v", invalid)
+	}
+
+	config.ValidationMode = VALIDATION_STRICT
+	invalid := list()
+	if len(invalid) != 1 || invalid[0].Id != offTotal || len(invalid[0].Errors) != 1 || !strings.Contains(invalid[0].Errors[0], "not the sum") {
+		t.Errorf("under strict validation the invalid receipts were %+v, expected only %s for its total", invalid, offTotal)
+	}
+
+	// finding them changes nothing
+	for _, id := range []string{valid, offTotal} {
+		if recorder := serveRequest(router, http.MethodGet, "/receipts/"+id+"/points", ""); recorder.Code != http.StatusOK {
+			t.Errorf("%s responded %d after the check, expected it still stored", id, recorder.Code)
+		}
+	}
+}
+
+func TestRetailerAllowlistAndBlocklist(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		allowlist []string
+		blocklist []string
+		retailer  string
+		status    int
+	}{
+		{"no lists", nil, nil, "Target", http.StatusOK},
+		{"allowed exactly", []string{"target"}, nil, "Target", http.StatusOK},
+		{"allowed ignoring punctuation", []string{"TAR-GET"}, nil, "Target", http.StatusOK},
+		{"allowed by prefix", []string{"Tar*"}, nil, "Target Express", http.StatusOK},
+		{"not allowed", []string{"Walmart", "Wal*"}, nil, "Target", http.StatusBadRequest},
+		{"a prefix is not an exact match", []string{"Tar"}, nil, "Target", http.StatusBadRequest},
+		{"blocked exactly", nil, []string{"Target"}, "target", http.StatusBadRequest},
+		{"blocked by prefix", nil, []string{"Target*"}, "Target Express", http.StatusBadRequest},
+		{"not blocked", nil, []string{"Walmart*"}, "Target", http.StatusOK},
+		{"blocked though allowed", []string{"Target*"}, []string{"Target Express"}, "Target Express", http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resetState(t)
+			config.RetailerAllowlist, config.RetailerBlocklist = test.allowlist, test.blocklist
+			router := newTestRouter(t)
+
+			receipt := testReceipt(t, TARGET_RECEIPT)
+			receipt.Retailer = test.retailer
+			recorder := serveRequest(router, http.MethodPost, "/receipts/process", testReceiptJSON(t, receipt))
+			if recorder.Code != test.status {
+				t.Fatalf("a receipt from %s responded %d, expected %d: %s", test.retailer, recorder.Code, test.status, recorder.Body)
+			}
+			if test.status == http.StatusBadRequest && !strings.Contains(recorder.Body.String(), "are not accepted") {
+				t.Errorf("a rejected retailer was described as %s", recorder.Body)
+			}
+		})
+	}
+}
+
+func TestUnknownCategoriesAreRejected(t *testing.T) {
+	resetState(t)
+	replaceTestRules(testRules(t, `{"categoryMultipliers": {"frozen": 2}}`))
+	router := newTestRouter(t)
+
+	for category, status := range map[string]int{"": http.StatusOK, "Frozen": http.StatusOK, "frozn": http.StatusBadRequest} {
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.Items[0].Category = category
+		recorder := serveRequest(router, http.MethodPost, "/receipts/process", testReceiptJSON(t, receipt))
+		if recorder.Code != status {
+			t.Errorf("an item in the category %q responded %d, expected %d: %s", category, recorder.Code, status, recorder.Body)
+		}
+	}
+}
+
+func TestMinPurchaseDate(t *testing.T) {
+	for _, test := range []struct {
+		minPurchaseDate string
+		purchaseDate    string
+		status          int
+	}{
+		{"", "1970-01-01", http.StatusOK},
+		{"2022-01-01", "2021-12-31", http.StatusBadRequest},
+		{"2022-01-01", "2022-01-01", http.StatusOK},
+		{"2022-01-01", "2022-01-02", http.StatusOK},
+	} {
+		resetState(t)
+		config.MinPurchaseDate = test.minPurchaseDate
+		router := newTestRouter(t)
+
+		receipt := testReceipt(t, TARGET_RECEIPT)
+		receipt.PurchaseDate = test.purchaseDate
+		recorder := serveRequest(router, http.MethodPost, "/receipts/process", testReceiptJSON(t, receipt))
+		if recorder.Code != test.status {
+			t.Errorf("a purchase on %s under a minimum of %q responded %d, expected %d: %s", test.purchaseDate, test.minPurchaseDate, recorder.Code, test.status, recorder.Body)
+		}
+		if test.status == http.StatusBadRequest && !strings.Contains(recorder.Body.String(), "before the earliest accepted") {
+			t.Errorf("a purchase before the minimum was described as %s", recorder.Body)
+		}
+	}
+
+	t.Setenv("MIN_PURCHASE_DATE", "last year")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "MIN_PURCHASE_DATE") {
+		t.Errorf("an invalid MIN_PURCHASE_DATE gave error %v, expected one naming it", err)
+	}
+}
+
+func TestDuplicateTagsAreDropped(t *testing.T) {
+	resetState(t)
+	router := newTestRouter(t)
+
+	id := processTestReceipt(t, router, withTestTags(t, TARGET_RECEIPT, "Snacks", "fuel", " snacks", "SNACKS ", "Fuel", "drinks"))
+	stored := decodeTestJSON[StoredReceipt](t, serveRequest(router, http.MethodGet, "/receipts/"+id, ""))
+	if tags := strings.Join(stored.Tags, ","); tags != "snacks,fuel,drinks" {
+		t.Errorf("the tags were stored as %s, expected each once, tidied up, in the order first given: snacks,fuel,drinks", tags)
+	}
+}
+
+func TestTagLimitsFromEnvironment(t *testing.T) {
+	t.Setenv("MAX_TAGS", "3")
+	t.Setenv("MAX_TAG_LENGTH", "12")
+	if loaded := loadTestConfig(t); loaded.MaxTags != 3 || loaded.MaxTagLength != 12 {
+		t.Errorf("MAX_TAGS and MAX_TAG_LENGTH loaded as %d and %d, expected 3 and 12", loaded.MaxTags, loaded.MaxTagLength)
+	}
+	t.Setenv("MAX_TAG_LENGTH", "0")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "MAX_TAG_LENGTH") {
+		t.Errorf("MAX_TAG_LENGTH=0 gave error %v, expected one naming it", err)
+	}
+}